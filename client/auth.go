@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Session is the result of a successful login.
+type Session struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	UserID       string `json:"userId"`
+	// MustChangeCredentials limits the session to Me and ChangePassword
+	// until the password and PIN are changed.
+	MustChangeCredentials bool `json:"mustChangeCredentials"`
+}
+
+// Registration is the result of Register.
+type Registration struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	UserID       string `json:"userId"`
+	// ChatID is set when the invite also joins a chat; ChatPending means the
+	// join awaits approval.
+	ChatID      string `json:"chatId,omitempty"`
+	ChatPending bool   `json:"chatPending,omitempty"`
+}
+
+// RegisterRequest signs up with an invite code.
+type RegisterRequest struct {
+	InviteCode  string `json:"inviteCode"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Password    string `json:"password"`
+	PIN         string `json:"pin"`
+	PublicKey   string `json:"publicKey"`
+}
+
+// Register creates an account and starts a renewable session for it.
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (*Registration, error) {
+	var r Registration
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/register", body: req, public: true}, &r); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setSession(r.Token, r.RefreshToken)
+	return &r, nil
+}
+
+// Login starts a session. When the account has a second factor it returns
+// a *TwoFactorRequiredError.
+func (c *Client) Login(ctx context.Context, creds Credentials) (*Session, error) {
+	var resp struct {
+		Session
+		TwoFactorRequired bool     `json:"twoFactorRequired"`
+		ChallengeToken    string   `json:"challengeToken"`
+		Methods           []string `json:"methods"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/login", body: creds, public: true}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.TwoFactorRequired {
+		return nil, &TwoFactorRequiredError{ChallengeToken: resp.ChallengeToken, Methods: resp.Methods}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setSession(resp.Token, resp.RefreshToken)
+	return &resp.Session, nil
+}
+
+// LoginTOTP finishes a two-factor login with a TOTP or recovery code.
+func (c *Client) LoginTOTP(ctx context.Context, challengeToken, code string) (*Session, error) {
+	var s Session
+	body := map[string]string{"challengeToken": challengeToken, "code": code}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/login/totp", body: body, public: true}, &s); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setSession(s.Token, s.RefreshToken)
+	return &s, nil
+}
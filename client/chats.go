@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CreateChat creates a chat owned by the caller and returns its ID.
+// Unknown member IDs are skipped by the server.
+func (c *Client) CreateChat(ctx context.Context, title string, isGroup bool, memberIDs ...string) (string, error) {
+	if memberIDs == nil {
+		memberIDs = []string{}
+	}
+	body := map[string]any{"title": title, "isGroup": isGroup, "memberIds": memberIDs}
+	var resp struct {
+		ChatID string `json:"chatId"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/chats/", body: body}, &resp); err != nil {
+		return "", err
+	}
+	return resp.ChatID, nil
+}
+
+// ClearChat deletes every message and attachment of a chat.
+func (c *Client) ClearChat(ctx context.Context, chatID string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: chatPath(chatID) + "/clear"}, nil)
+}
+
+// ChatInviteRequest configures a chat-join invite. MaxUses defaults to 1
+// and Role to member.
+type ChatInviteRequest struct {
+	MaxUses          int        `json:"maxUses,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	Role             string     `json:"role,omitempty"`
+	RequiresApproval bool       `json:"requiresApproval,omitempty"`
+}
+
+// CreatedInvite identifies a new invite.
+type CreatedInvite struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+	// ExpiresAt is set for registration invites that expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// CreateChatInvite creates an invite existing users join chatID with.
+// Only the chat's owner and admins may.
+func (c *Client) CreateChatInvite(ctx context.Context, chatID string, req ChatInviteRequest) (*CreatedInvite, error) {
+	var inv CreatedInvite
+	if err := c.call(ctx, request{method: http.MethodPost, path: chatPath(chatID) + "/invites", body: req}, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// JoinChat joins the chat of a chat-join invite. pending is true when the
+// invite requires approval. Joining a chat twice fails with ErrConflict.
+func (c *Client) JoinChat(ctx context.Context, code string) (chatID string, pending bool, err error) {
+	var resp struct {
+		ChatID  string `json:"chatId"`
+		Pending bool   `json:"pending"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/chats/join", body: map[string]string{"code": code}}, &resp); err != nil {
+		return "", false, err
+	}
+	return resp.ChatID, resp.Pending, nil
+}
+
+// JoinRequest is a pending request to join a chat.
+type JoinRequest struct {
+	UserID      string    `json:"userId"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Role        string    `json:"role"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// JoinRequests lists the pending join requests of a chat.
+func (c *Client) JoinRequests(ctx context.Context, chatID string) ([]JoinRequest, error) {
+	var resp struct {
+		Requests []JoinRequest `json:"requests"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: chatPath(chatID) + "/join-requests"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Requests, nil
+}
+
+// ApproveJoinRequest adds userID to the chat.
+func (c *Client) ApproveJoinRequest(ctx context.Context, chatID, userID string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: chatPath(chatID) + "/join-requests/" + url.PathEscape(userID) + "/approve"}, nil)
+}
+
+// RejectJoinRequest drops the join request of userID; requesters may
+// withdraw their own.
+func (c *Client) RejectJoinRequest(ctx context.Context, chatID, userID string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: chatPath(chatID) + "/join-requests/" + url.PathEscape(userID)}, nil)
+}
+
+// ChatDevice is a device of a chat member, for addressing envelopes.
+type ChatDevice struct {
+	UserID   string `json:"userId"`
+	DeviceID string `json:"deviceId"`
+}
+
+// ChatDevices lists the devices of every member of a chat.
+func (c *Client) ChatDevices(ctx context.Context, chatID string) ([]ChatDevice, error) {
+	var resp struct {
+		Devices []ChatDevice `json:"devices"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: chatPath(chatID) + "/devices"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Devices, nil
+}
+
+// History returns a page of a chat's messages, newest first. Pass the
+// page's NextCursor as before for older messages; "" starts at the newest.
+// limit 0 uses the server's default.
+func (c *Client) History(ctx context.Context, chatID, before string, limit int) (*MessagePage, error) {
+	var page MessagePage
+	req := request{method: http.MethodGet, path: chatPath(chatID) + "/messages", query: pageQuery("before", before, limit), device: true}
+	if err := c.call(ctx, req, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func chatPath(chatID string) string {
+	return "/api/v1/chats/" + url.PathEscape(chatID)
+}
+
+func pageQuery(key, cursor string, limit int) url.Values {
+	q := url.Values{}
+	if cursor != "" {
+		q.Set(key, cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	return q
+}
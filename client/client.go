@@ -0,0 +1,280 @@
+// Package client is the Go SDK for the messaging API.
+//
+// A Client holds one user's session. After Login, LoginTOTP or Register it
+// renews the access token with the session's refresh token shortly before
+// it expires, or when the server rejects it as invalid, so long-running
+// services do not have to handle expiry. The password and PIN are not
+// kept. Sessions started with WithToken alone cannot be renewed and fail
+// with ErrUnauthorized once the token expires.
+//
+// Error responses are returned as *Error and match the sentinel errors
+// with errors.Is:
+//
+//	_, err := c.History(ctx, chatID, "", 0)
+//	if errors.Is(err, client.ErrForbidden) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// refreshMargin is how long before expiry the token is renewed.
+const refreshMargin = time.Minute
+
+// invalidToken is the error the API answers when an access token is
+// expired, revoked or otherwise unusable; only that 401 is worth renewing.
+const invalidToken = "invalid token"
+
+// Client calls the API at a base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL  string
+	http     *http.Client
+	deviceID string
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	// refresh renews the token; "" when the session cannot be renewed.
+	refresh string
+}
+
+// Credentials are what Login needs.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	PIN      string `json:"pin"`
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc, e.g. one with a client
+// certificate for service accounts. The default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithToken starts with an existing access token.
+func WithToken(token string) Option {
+	return func(c *Client) { c.setToken(token) }
+}
+
+// WithRefreshToken resumes a session from a refresh token saved with
+// RefreshToken; the first request renews the access token with it.
+func WithRefreshToken(token string) Option {
+	return func(c *Client) { c.refresh = token }
+}
+
+// WithDeviceID sends X-Device-ID on history and sync requests, so fan-out
+// messages come back with this device's envelope.
+func WithDeviceID(id string) Option {
+	return func(c *Client) { c.deviceID = id }
+}
+
+// New returns a client for the API at baseURL, e.g. https://chat.example.com.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), http: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token returns the current access token, or "" before logging in.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// RefreshToken returns the session's refresh token, or "" when it cannot be
+// renewed. It changes with every renewal and the previous one stops
+// working, so save it again after requests to resume the session later.
+func (c *Client) RefreshToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refresh
+}
+
+// setSession records a new access and refresh token. Callers hold mu or own
+// c exclusively.
+func (c *Client) setSession(token, refresh string) {
+	c.setToken(token)
+	c.refresh = refresh
+}
+
+// setToken records token and its expiry. The signature is the server's
+// business; the expiry is only read to renew in time. Callers hold mu or
+// own c exclusively.
+func (c *Client) setToken(token string) {
+	c.token, c.expiry = token, time.Time{}
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil && claims.ExpiresAt != nil {
+		c.expiry = claims.ExpiresAt.Time
+	}
+}
+
+// accessToken returns a token to send, renewing it first when the session
+// can be renewed and the token is missing, about to expire, or equal to
+// stale, a token the server just rejected.
+func (c *Client) accessToken(ctx context.Context, stale string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refresh == "" {
+		return c.token, nil
+	}
+	expiring := !c.expiry.IsZero() && time.Until(c.expiry) < refreshMargin
+	if c.token != "" && c.token != stale && !expiring {
+		return c.token, nil
+	}
+	// Holding mu makes concurrent requests wait for one renewal, which
+	// matters since each refresh token works only once.
+	var s Session
+	err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/refresh", body: map[string]string{"refreshToken": c.refresh}, public: true}, &s)
+	if errors.Is(err, ErrUnauthorized) {
+		// Revoked or expired: the session is over until the next login.
+		c.refresh = ""
+	}
+	if err != nil {
+		return "", err
+	}
+	c.setSession(s.Token, s.RefreshToken)
+	return c.token, nil
+}
+
+// canRenew reports whether a rejected token is worth renewing.
+func (c *Client) canRenew() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refresh != ""
+}
+
+// payload is a request body that is not JSON. write streams it while the
+// request is sent. A request carrying a payload is only retried when
+// replayable, i.e. write can run twice.
+type payload struct {
+	contentType string
+	write       func(io.Writer) error
+	replayable  bool
+}
+
+// request describes one API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// body is marshalled as JSON unless it is a *payload.
+	body any
+	// public requests are sent without a token.
+	public bool
+	device bool
+}
+
+// call sends req and decodes the JSON response into out, if not nil.
+func (c *Client) call(ctx context.Context, req request, out any) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends req and returns the response if its status is below 400. A
+// request whose token the server rejects as invalid, e.g. expired, is sent
+// once more after renewing it; other 401s, such as a wrong PIN, are not.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		if _, ok := req.body.(*payload); !ok {
+			b, err := json.Marshal(req.body)
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		}
+	}
+	var token string
+	if !req.public {
+		t, err := c.accessToken(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		token = t
+	}
+	resp, err := c.do(ctx, req, body, token)
+	if err != nil {
+		return nil, err
+	}
+	p, streamed := req.body.(*payload)
+	streamed = streamed && !p.replayable
+	if resp.StatusCode >= 400 {
+		err := parseError(resp)
+		resp.Body.Close()
+		var e *Error
+		if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized || e.Message != invalidToken || token == "" || streamed || !c.canRenew() {
+			return nil, err
+		}
+		if token, err = c.accessToken(ctx, token); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(ctx, req, body, token); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			defer resp.Body.Close()
+			return nil, parseError(resp)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, req request, body []byte, token string) (*http.Response, error) {
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var r io.Reader
+	contentType := ""
+	switch b := req.body.(type) {
+	case nil:
+	case *payload:
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(b.write(pw)) }()
+		r, contentType = pr, b.contentType
+	default:
+		r, contentType = bytes.NewReader(body), "application/json"
+	}
+	hr, err := http.NewRequestWithContext(ctx, req.method, u, r)
+	if err != nil {
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return nil, err
+	}
+	if contentType != "" {
+		hr.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		hr.Header.Set("Authorization", "Bearer "+token)
+	}
+	if req.device && c.deviceID != "" {
+		hr.Header.Set("X-Device-ID", c.deviceID)
+	}
+	return c.http.Do(hr)
+}
@@ -0,0 +1,341 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"messagingapi/client"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/httpserver"
+	"messagingapi/internal/httpserver/apitest"
+	"messagingapi/internal/store"
+	"messagingapi/internal/store/memstore"
+)
+
+// start serves s over HTTP and returns its base URL.
+func start(t *testing.T, s *apitest.Server) string {
+	t.Helper()
+	srv := httptest.NewServer(s.Router)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// login returns a client signed in as u.
+func login(t *testing.T, base string, u *apitest.User, opts ...client.Option) *client.Client {
+	t.Helper()
+	c := client.New(base, opts...)
+	if _, err := c.Login(context.Background(), client.Credentials{Username: u.Username, Password: apitest.Password, PIN: apitest.PIN}); err != nil {
+		t.Fatalf("login %s: %v", u.Username, err)
+	}
+	return c
+}
+
+// TestErrors needs no database: authentication fails before any query.
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(httpserver.NewRouter(memstore.New(), config.Config{}, auth.NewKeySet(nil), nil))
+	defer srv.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		opts    []client.Option
+		message string
+	}{
+		{"no token", nil, "missing token"},
+		{"bad token", []client.Option{client.WithToken("not-a-jwt")}, "invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.New(srv.URL+"/", tt.opts...).Me(ctx)
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 || apiErr.Message != tt.message {
+				t.Fatalf("Me() error = %v, want 401 %q", err, tt.message)
+			}
+			if !errors.Is(err, client.ErrUnauthorized) || errors.Is(err, client.ErrForbidden) {
+				t.Errorf("errors.Is does not match the status of %v", err)
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := apitest.New(t)
+	base := start(t, s)
+	ctx := context.Background()
+
+	c := client.New(base)
+	reg, err := c.Register(ctx, client.RegisterRequest{
+		InviteCode: s.Invite(store.NewInvite{}), Username: "alice", DisplayName: "Alice",
+		Password: apitest.Password, PIN: apitest.PIN, PublicKey: "pk",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, err := c.Me(ctx)
+	if err != nil || me.ID != reg.UserID || me.Username != "alice" {
+		t.Fatalf("Me() = %+v, %v", me, err)
+	}
+
+	_, err = client.New(base).Login(ctx, client.Credentials{Username: "alice", Password: "wrong", PIN: apitest.PIN})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrUnauthorized) || apiErr.Message != "invalid credentials" {
+		t.Errorf("Login with a wrong password: %v", err)
+	}
+
+	// An expired token is renewed before the request is sent, which
+	// rotates the refresh token.
+	first := c.RefreshToken()
+	expired, err := s.Keys.GenerateJWT(reg.UserID, "alice", -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = client.New(base, client.WithToken(expired), client.WithRefreshToken(first))
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatalf("Me() with an expired token: %v", err)
+	}
+	if c.Token() == expired || c.RefreshToken() == first || c.RefreshToken() == "" {
+		t.Error("expired token was not renewed")
+	}
+
+	// A token the server rejects as invalid is renewed and the request sent
+	// again.
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	foreign := auth.NewKeySet([]auth.SigningKey{{KID: "foreign", Private: priv, CreatedAt: time.Now(), ActivatesAt: time.Now().Add(-time.Minute)}})
+	rejected, err := foreign.GenerateJWT(reg.UserID, "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = client.New(base, client.WithToken(rejected), client.WithRefreshToken(c.RefreshToken()))
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatalf("Me() with a rejected token: %v", err)
+	}
+	if _, err := client.New(base, client.WithToken(rejected)).Me(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Me() with a rejected token and no refresh token: %v", err)
+	}
+
+	// Other 401s go back to the caller without renewing.
+	token, refresh := c.Token(), c.RefreshToken()
+	if _, err := c.ChangePassword(ctx, apitest.Password, "0000", "battery staple", "1357"); !errors.As(err, &apiErr) || apiErr.Message != "invalid credentials" {
+		t.Fatalf("ChangePassword with a wrong PIN: %v", err)
+	}
+	if c.Token() != token || c.RefreshToken() != refresh {
+		t.Error("a wrong PIN renewed the session")
+	}
+
+	// Replaying a spent refresh token ends its session.
+	replay := client.New(base, client.WithRefreshToken(first))
+	if _, err := replay.Me(ctx); !errors.Is(err, client.ErrUnauthorized) || replay.RefreshToken() != "" {
+		t.Fatalf("Me() with a spent refresh token: %v", err)
+	}
+	if _, err := client.New(base, client.WithRefreshToken(refresh)).Me(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Me() with the session's latest refresh token after a replay: %v", err)
+	}
+
+	// Changing the password revokes other sessions; the caller's goes on.
+	other := login(t, base, &apitest.User{Username: "alice"})
+	c = login(t, base, &apitest.User{Username: "alice"})
+	if _, err := c.ChangePassword(ctx, apitest.Password, apitest.PIN, "battery staple", "1357"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.New(base, client.WithToken(expired), client.WithRefreshToken(c.RefreshToken())).Me(ctx); err != nil {
+		t.Errorf("Me() renewed after changing the password: %v", err)
+	}
+	if _, err := client.New(base, client.WithRefreshToken(other.RefreshToken())).Me(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Me() renewed by another session after changing the password: %v", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	s := apitest.New(t)
+	base := start(t, s)
+	ctx := context.Background()
+	alice, bob, eve := s.Register("alice"), s.Register("bob"), s.Register("eve")
+	ca, cb, ce := login(t, base, alice), login(t, base, bob), login(t, base, eve)
+
+	chatID, err := ca.CreateChat(ctx, "", false, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := cb.Stream("", 10*time.Millisecond)
+
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+	id, err := ca.SendMessage(ctx, client.NewMessage{
+		ChatID: chatID, Ciphertext: "hello", Nonce: "n",
+		Attachments: []client.File{{Name: "a.png", ContentType: "image/png", Body: bytes.NewReader(png)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != id || m.SenderID != alice.ID || m.Ciphertext != "hello" || len(m.Attachments) != 1 {
+		t.Fatalf("streamed message = %+v", m)
+	}
+
+	d, err := cb.Attachment(ctx, m.Attachments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(d)
+	d.Close()
+	if !bytes.Equal(got, png) || d.ContentType != "image/png" {
+		t.Errorf("attachment = %q (%s)", got, d.ContentType)
+	}
+	if _, err := ce.Attachment(ctx, m.Attachments[0].ID); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("non-member download: %v", err)
+	}
+	if _, err := cb.Attachment(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("missing attachment: %v", err)
+	}
+
+	// Caught up, the stream waits for the next message.
+	short := func() context.Context {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	if _, err := stream.Next(short()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Next() with nothing new: %v", err)
+	}
+	id2, err := cb.SendMessage(ctx, client.NewMessage{ChatID: chatID, Ciphertext: "hi", ReplyToID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := stream.Next(ctx); err != nil || m.ID != id2 || m.ReplyToID != id {
+		t.Fatalf("Next() = %+v, %v", m, err)
+	}
+	// A new stream resumes from the cursor.
+	if m, err := cb.Stream(stream.Cursor(), 10*time.Millisecond).Next(short()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("resumed stream returned %+v, %v", m, err)
+	}
+
+	if err := ca.EditMessage(ctx, id, "hello again", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.EditMessage(ctx, id, "forged", ""); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("editing someone else's message: %v", err)
+	}
+	if err := ca.DeleteMessage(ctx, id2); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("deleting someone else's message: %v", err)
+	}
+	if err := cb.DeleteMessage(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ca.History(ctx, chatID, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Ciphertext != "hello again" || page.Messages[0].EditedAt == nil || page.NextCursor == "" {
+		t.Errorf("History() = %+v", page)
+	}
+	if _, err := ce.History(ctx, chatID, "", 0); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("non-member history: %v", err)
+	}
+}
+
+func TestAvatar(t *testing.T) {
+	s := apitest.New(t)
+	base := start(t, s)
+	ctx := context.Background()
+	c := login(t, base, s.Register("alice"))
+
+	if _, err := c.Avatar(ctx); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Avatar() before upload: %v", err)
+	}
+	if err := c.UploadAvatar(ctx, client.File{Name: "me.png", ContentType: "image/png", Body: strings.NewReader("avatar")}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.Avatar(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if got, _ := io.ReadAll(d); string(got) != "avatar" {
+		t.Errorf("Avatar() = %q", got)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	s := apitest.New(t)
+	base := start(t, s)
+	ctx := context.Background()
+	admin, alice := s.Admin("admin"), s.Register("alice")
+	ca, cu := login(t, base, admin), login(t, base, alice)
+
+	inv, err := ca.CreateInvite(ctx, client.InviteRequest{MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.New(base).Register(ctx, client.RegisterRequest{
+		InviteCode: inv.Code, Username: "bob", DisplayName: "Bob", Password: apitest.Password, PIN: apitest.PIN, PublicKey: "pk",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := ca.InviteUsage(ctx, inv.ID)
+	if err != nil || usage.Uses != 1 || len(usage.Users) != 1 || usage.Users[0].Username != "bob" {
+		t.Fatalf("InviteUsage() = %+v, %v", usage, err)
+	}
+	inactive := false
+	if err := ca.UpdateInvite(ctx, inv.ID, client.InviteUpdate{Active: &inactive}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ca.Invites(ctx)
+	if err != nil || list.Quota != nil {
+		t.Fatalf("Invites() = %+v, %v", list, err)
+	}
+	for _, i := range list.Invites {
+		if i.ID == inv.ID && i.Active {
+			t.Error("invite still active after UpdateInvite")
+		}
+	}
+
+	// alice has no invite quota and no admin rights.
+	var apiErr *client.Error
+	if _, err := cu.CreateInvite(ctx, client.InviteRequest{}); !errors.As(err, &apiErr) || !errors.Is(err, client.ErrForbidden) || apiErr.Message != "invites not allowed" {
+		t.Errorf("CreateInvite() without quota: %v", err)
+	}
+	if err := cu.DeleteInvite(ctx, inv.ID); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("DeleteInvite() by a non-admin: %v", err)
+	}
+	if err := ca.DeleteInvite(ctx, inv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.InviteUsage(ctx, inv.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("InviteUsage() of a deleted invite: %v", err)
+	}
+
+	// Chat-join invites, with approval.
+	chatID, err := ca.CreateChat(ctx, "team", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	join, err := ca.CreateChatInvite(ctx, chatID, client.ChatInviteRequest{MaxUses: 2, RequiresApproval: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, pending, err := cu.JoinChat(ctx, join.Code); err != nil || got != chatID || !pending {
+		t.Fatalf("JoinChat() = %s, %v, %v", got, pending, err)
+	}
+	requests, err := ca.JoinRequests(ctx, chatID)
+	if err != nil || len(requests) != 1 || requests[0].UserID != alice.ID {
+		t.Fatalf("JoinRequests() = %+v, %v", requests, err)
+	}
+	if err := ca.ApproveJoinRequest(ctx, chatID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = cu.JoinChat(ctx, join.Code)
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrConflict) || apiErr.ChatID != chatID {
+		t.Errorf("joining twice: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sentinel errors an *Error matches with errors.Is, by status code.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrServer          = errors.New("server error")
+)
+
+// Error is an error response from the API. Message is the server's "error"
+// field; the other fields are only set by the responses that carry them.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	// AttemptsRemaining counts the PIN attempts left before the next lockout.
+	AttemptsRemaining *int `json:"attemptsRemaining,omitempty"`
+	// LockedUntil is set while PIN checks are locked out.
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	// KeyBackupDeleted is set when a wrong PIN destroyed the key backup.
+	KeyBackupDeleted bool `json:"keyBackupDeleted,omitempty"`
+	// SuspendedUntil is set when the account is suspended.
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+	// Remaining is the invite quota left when creating an invite exceeds it.
+	Remaining *int `json:"remaining,omitempty"`
+	// ChatID is the chat a join invite points to when already a member.
+	ChatID string `json:"chatId,omitempty"`
+	// Version is the current key backup version on a version conflict.
+	Version *int `json:"version,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("messaging api: %d %s", e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel error for e's status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusTooManyRequests:
+		return target == ErrTooManyRequests
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// parseError reads an error response. Media routes answer without a body,
+// in which case Message is the status text.
+func parseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("messaging api: %d: reading error response: %w", resp.StatusCode, err)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		json.Unmarshal(b, e)
+	}
+	if e.Message == "" {
+		e.Message = strings.ToLower(http.StatusText(resp.StatusCode))
+	}
+	return e
+}
+
+// TwoFactorRequiredError is returned by Login when the account has a second
+// factor. Finish with LoginTOTP, passing ChallengeToken.
+type TwoFactorRequiredError struct {
+	ChallengeToken string
+	// Methods lists the accepted second factors: totp, recovery, passkey.
+	Methods []string
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "messaging api: second factor required (" + strings.Join(e.Methods, ", ") + ")"
+}
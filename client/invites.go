@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// InviteRequest configures a registration invite. Code is random when
+// empty, MaxUses defaults to 1 and Role, the role in ChatID, to member.
+type InviteRequest struct {
+	Code             string     `json:"code,omitempty"`
+	MaxUses          int        `json:"maxUses,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	ChatID           string     `json:"chatId,omitempty"`
+	Role             string     `json:"role,omitempty"`
+	RequiresApproval bool       `json:"requiresApproval,omitempty"`
+}
+
+// Invite is an invite as listed by Invites.
+type Invite struct {
+	ID      string `json:"id"`
+	Code    string `json:"code"`
+	MaxUses int    `json:"maxUses"`
+	Uses    int    `json:"uses"`
+	Active  bool   `json:"active"`
+	// Kind is register or chat_join.
+	Kind             string     `json:"kind"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	ChatID           string     `json:"chatId,omitempty"`
+	RequiresApproval bool       `json:"requiresApproval,omitempty"`
+}
+
+// InviteQuota is a non-admin's allowance of registration slots.
+type InviteQuota struct {
+	Allowance int `json:"allowance"`
+	Used      int `json:"used"`
+	Remaining int `json:"remaining"`
+}
+
+// InviteList is the result of Invites. Quota is nil for admins.
+type InviteList struct {
+	Invites []Invite     `json:"invites"`
+	Quota   *InviteQuota `json:"quota,omitempty"`
+}
+
+// InviteUpdate changes an invite; nil fields are left alone. NoExpiry
+// clears the expiry.
+type InviteUpdate struct {
+	Active    *bool      `json:"active,omitempty"`
+	MaxUses   *int       `json:"maxUses,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	NoExpiry  bool       `json:"noExpiry,omitempty"`
+}
+
+// InviteUsage lists the users an invite registered.
+type InviteUsage struct {
+	ID      string        `json:"id"`
+	Code    string        `json:"code"`
+	MaxUses int           `json:"maxUses"`
+	Uses    int           `json:"uses"`
+	Users   []InvitedUser `json:"users"`
+}
+
+// InvitedUser is a user registered with an invite.
+type InvitedUser struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"displayName"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// CreateInvite creates a registration invite. Going over the quota of a
+// non-admin fails with ErrForbidden and sets Error.Remaining.
+func (c *Client) CreateInvite(ctx context.Context, req InviteRequest) (*CreatedInvite, error) {
+	var inv CreatedInvite
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/invites/", body: req}, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Invites lists every invite for admins, and the caller's own with their
+// quota for other users.
+func (c *Client) Invites(ctx context.Context) (*InviteList, error) {
+	var list InviteList
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/invites/"}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// UpdateInvite changes an invite. Admins only.
+func (c *Client) UpdateInvite(ctx context.Context, id string, u InviteUpdate) error {
+	return c.call(ctx, request{method: http.MethodPatch, path: invitePath(id), body: u}, nil)
+}
+
+// DeleteInvite deletes an invite; the users it registered stay. Admins
+// only.
+func (c *Client) DeleteInvite(ctx context.Context, id string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: invitePath(id)}, nil)
+}
+
+// InviteUsage returns the users an invite registered. Admins only.
+func (c *Client) InviteUsage(ctx context.Context, id string) (*InviteUsage, error) {
+	var usage InviteUsage
+	if err := c.call(ctx, request{method: http.MethodGet, path: invitePath(id) + "/usage"}, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func invitePath(id string) string {
+	return "/api/v1/invites/" + url.PathEscape(id)
+}
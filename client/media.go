@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+)
+
+// File is an upload. ContentType defaults to application/octet-stream.
+type File struct {
+	Name        string
+	ContentType string
+	Body        io.Reader
+}
+
+// multipartBody streams fields and then files, each file as a part named
+// field. Without files it can be sent again.
+func multipartBody(fields map[string]string, field string, files []File) *payload {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	write := func(w io.Writer) error {
+		mw := multipart.NewWriter(w)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return err
+		}
+		for k, v := range fields {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+		for _, f := range files {
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": field, "filename": f.Name}))
+			ct := f.ContentType
+			if ct == "" {
+				ct = "application/octet-stream"
+			}
+			h.Set("Content-Type", ct)
+			part, err := mw.CreatePart(h)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, f.Body); err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	}
+	return &payload{contentType: "multipart/form-data; boundary=" + boundary, write: write, replayable: len(files) == 0}
+}
+
+// Download is a file being read from the API. Close it when done.
+type Download struct {
+	io.ReadCloser
+	ContentType string
+	// Size is -1 when the server did not send a length.
+	Size int64
+}
+
+// Avatar downloads the signed-in user's avatar.
+func (c *Client) Avatar(ctx context.Context) (*Download, error) {
+	return c.download(ctx, "/api/v1/media/avatar")
+}
+
+// Attachment downloads an attachment of a chat the caller is a member of.
+func (c *Client) Attachment(ctx context.Context, id string) (*Download, error) {
+	return c.download(ctx, "/api/v1/media/attachments/"+url.PathEscape(id))
+}
+
+func (c *Client) download(ctx context.Context, path string) (*Download, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: path})
+	if err != nil {
+		return nil, err
+	}
+	return &Download{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}, nil
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Message is a chat message. Ciphertext is opaque to the server; for
+// fan-out messages it is the envelope of the client's device.
+type Message struct {
+	ID     string `json:"id"`
+	ChatID string `json:"chatId"`
+	// SenderID is empty for sealed messages and deleted accounts.
+	SenderID    string       `json:"senderId,omitempty"`
+	Ciphertext  string       `json:"ciphertext"`
+	Nonce       string       `json:"nonce,omitempty"`
+	ReplyToID   string       `json:"replyToId,omitempty"`
+	IsDeleted   bool         `json:"isDeleted"`
+	Fanout      bool         `json:"fanout"`
+	Sealed      bool         `json:"sealed"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	EditedAt    *time.Time   `json:"editedAt,omitempty"`
+	Attachments []Attachment `json:"attachments"`
+	// Event is set on system messages, e.g. {"type": "member_joined", ...}.
+	Event map[string]any `json:"event,omitempty"`
+	// Cursor is set on messages from Sync: the position just after the
+	// message, to pass back as after.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Attachment describes a file attached to a message; download it with
+// Client.Attachment.
+type Attachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	SizeBytes   int64  `json:"sizeBytes"`
+}
+
+// MessagePage is a page of messages. NextCursor is empty on the last page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// Envelope is a message's ciphertext for one device.
+type Envelope struct {
+	DeviceID   string `json:"deviceId"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// NewMessage is a message to send. Set either Ciphertext or Envelopes.
+type NewMessage struct {
+	ChatID     string
+	Ciphertext string
+	Nonce      string
+	ReplyToID  string
+	// Envelopes address the devices of the chat's members individually,
+	// e.g. to distribute a sender key.
+	Envelopes   []Envelope
+	Attachments []File
+}
+
+// SendMessage sends m, uploading its attachments, and returns its ID.
+func (c *Client) SendMessage(ctx context.Context, m NewMessage) (string, error) {
+	fields := map[string]string{"chatId": m.ChatID}
+	for k, v := range map[string]string{"ciphertext": m.Ciphertext, "nonce": m.Nonce, "replyToId": m.ReplyToID} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	if len(m.Envelopes) > 0 {
+		b, err := json.Marshal(m.Envelopes)
+		if err != nil {
+			return "", err
+		}
+		fields["envelopes"] = string(b)
+	}
+	var resp struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/messages/", body: multipartBody(fields, "files", m.Attachments)}, &resp); err != nil {
+		return "", err
+	}
+	return resp.MessageID, nil
+}
+
+// EditMessage replaces the ciphertext of one of the caller's messages.
+// Fan-out messages cannot be edited.
+func (c *Client) EditMessage(ctx context.Context, id, ciphertext, nonce string) error {
+	body := map[string]string{"ciphertext": ciphertext, "nonce": nonce}
+	return c.call(ctx, request{method: http.MethodPatch, path: "/api/v1/messages/" + url.PathEscape(id), body: body}, nil)
+}
+
+// DeleteMessage deletes one of the caller's messages and its attachments.
+func (c *Client) DeleteMessage(ctx context.Context, id string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: "/api/v1/messages/" + url.PathEscape(id)}, nil)
+}
+
+// Sync returns messages of all the caller's chats in commit order, after
+// the cursor of a previous page or message; "" starts with the oldest.
+func (c *Client) Sync(ctx context.Context, after string, limit int) (*MessagePage, error) {
+	var page MessagePage
+	req := request{method: http.MethodGet, path: "/api/v1/messages/sync", query: pageQuery("after", after, limit), device: true}
+	if err := c.call(ctx, req, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// DefaultPollInterval is how often a Stream polls when it has caught up.
+const DefaultPollInterval = 2 * time.Second
+
+// Stream delivers new messages from all of the caller's chats as they
+// arrive. The API has no push channel, so a Stream polls Sync, waiting
+// between polls once it has caught up. Sync only moves past committed
+// transactions, so a message that commits after a newer one is still
+// delivered and the polls need not overlap.
+type Stream struct {
+	c        *Client
+	cursor   string
+	interval time.Duration
+	pending  []Message
+	caughtUp bool
+}
+
+// Stream starts a Stream after cursor, a value from Stream.Cursor, a
+// page's NextCursor or a synced message's Cursor; "" replays every message
+// first. interval 0 means DefaultPollInterval.
+func (c *Client) Stream(cursor string, interval time.Duration) *Stream {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &Stream{c: c, cursor: cursor, interval: interval}
+}
+
+// Next returns the next message, waiting until one arrives or ctx ends. A
+// failed poll returns its error; calling Next again resumes where it left
+// off. A Stream is not safe for concurrent use.
+func (s *Stream) Next(ctx context.Context) (Message, error) {
+	for len(s.pending) == 0 {
+		if s.caughtUp {
+			t := time.NewTimer(s.interval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return Message{}, ctx.Err()
+			case <-t.C:
+			}
+		}
+		page, err := s.c.Sync(ctx, s.cursor, 0)
+		if err != nil {
+			return Message{}, err
+		}
+		s.pending = page.Messages
+		s.caughtUp = page.NextCursor == ""
+	}
+	m := s.pending[0]
+	s.pending = s.pending[1:]
+	s.cursor = m.Cursor
+	return m, nil
+}
+
+// Cursor is the position after the last message Next returned. Save it to
+// resume the Stream later.
+func (s *Stream) Cursor() string {
+	return s.cursor
+}
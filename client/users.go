@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Me is the signed-in user's profile.
+type Me struct {
+	ID                    string     `json:"id"`
+	Username              string     `json:"username"`
+	DisplayName           string     `json:"displayName"`
+	PublicKey             string     `json:"publicKey"`
+	MustChangeCredentials bool       `json:"mustChangeCredentials"`
+	TwoFactorEnabled      bool       `json:"twoFactorEnabled"`
+	AvatarURL             string     `json:"avatarUrl,omitempty"`
+	LastActiveAt          *time.Time `json:"lastActiveAt,omitempty"`
+	DeletionScheduledAt   *time.Time `json:"deletionScheduledAt,omitempty"`
+}
+
+// Me returns the signed-in user.
+func (c *Client) Me(ctx context.Context) (*Me, error) {
+	var me Me
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/users/me"}, &me); err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// ChangePassword replaces the password and PIN. It reports whether the key
+// backup was deleted because the PIN that encrypts it changed. The server
+// revokes every other session's refresh token; this one continues.
+func (c *Client) ChangePassword(ctx context.Context, oldPassword, oldPIN, newPassword, newPIN string) (keyBackupDeleted bool, err error) {
+	body := map[string]string{"oldPassword": oldPassword, "oldPin": oldPIN, "newPassword": newPassword, "newPin": newPIN}
+	var resp struct {
+		KeyBackupDeleted bool   `json:"keyBackupDeleted"`
+		RefreshToken     string `json:"refreshToken"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPut, path: "/api/v1/users/me/password", body: body}, &resp); err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh = resp.RefreshToken
+	return resp.KeyBackupDeleted, nil
+}
+
+// UploadAvatar replaces the avatar. The server keeps the extension of
+// f.Name.
+func (c *Client) UploadAvatar(ctx context.Context, f File) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/api/v1/users/me/avatar", body: multipartBody(nil, "avatar", []File{f})}, nil)
+}
+
+// PublicKey is a user's identity key as seen by the caller.
+type PublicKey struct {
+	UserID      string `json:"userId"`
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+	// Verified means the caller verified this exact key.
+	Verified   bool       `json:"verified"`
+	ChangedAt  *time.Time `json:"changedAt,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
+
+// PublicKey returns the identity key of userID, who must share a chat with
+// the caller.
+func (c *Client) PublicKey(ctx context.Context, userID string) (*PublicKey, error) {
+	var k PublicKey
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/users/" + url.PathEscape(userID) + "/key"}, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Device is a registered device of the signed-in user.
+type Device struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+// RegisterDevice registers a device and returns its ID, to pass to
+// WithDeviceID.
+func (c *Client) RegisterDevice(ctx context.Context, name string) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/users/me/devices", body: map[string]string{"name": name}}, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// Devices lists the signed-in user's devices.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var resp struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/users/me/devices"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Devices, nil
+}
+
+// DeleteDevice removes a device and the envelopes still addressed to it.
+func (c *Client) DeleteDevice(ctx context.Context, id string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: "/api/v1/users/me/devices/" + url.PathEscape(id)}, nil)
+}
@@ -1,63 +1,211 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
+	"messagingapi/internal/auth"
 	"messagingapi/internal/config"
 	"messagingapi/internal/db"
 	"messagingapi/internal/httpserver"
+	"messagingapi/internal/httpserver/routes"
+	"messagingapi/internal/jobs"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
+	"messagingapi/internal/tracing"
 )
 
 func main() {
-	cfg := config.Load()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			fatal("invalid configuration", "problems", verr.Problems)
+		}
+		fatal("loading configuration failed", "err", err)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		fatal("invalid LOG_LEVEL", "err", err)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	for _, w := range cfg.Warnings() {
+		slog.Warn("configuration", "warning", w)
+	}
 
-	dbConn, err := db.Connect(cfg.DBDSN)
+	if err := auth.SetArgon2Params(auth.Argon2Params{Time: cfg.Argon2Time, Memory: cfg.Argon2MemoryKiB, Threads: cfg.Argon2Threads}); err != nil {
+		fatal("invalid ARGON2_* settings", "err", err)
+	}
+	passkeys, err := routes.NewPasskeys(cfg)
 	if err != nil {
-		log.Fatalf("failed to connect db: %v", err)
+		fatal("invalid WEBAUTHN_* settings", "err", err)
 	}
-	defer dbConn.Close()
 
-	if err := db.RunMigrationsAndSeed(dbConn); err != nil {
-		log.Fatalf("failed to run migrations: %v", err)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		fatal("failed to set up tracing", "err", err)
 	}
 
-	r := httpserver.NewRouter(dbConn, cfg)
+	dbConn, err := db.Connect(cfg.DBDSN, db.Timeouts{Statement: cfg.DBStatementTimeout, IdleInTransaction: cfg.DBIdleInTransactionTimeout})
+	if err != nil {
+		fatal("failed to connect db", "err", err)
+	}
+
+	metrics.RegisterDB(dbConn, "main")
+
+	applied, err := db.Migrate(context.Background(), dbConn)
+	if err != nil {
+		fatal("failed to run migrations", "err", err)
+	}
+	if len(applied) > 0 {
+		slog.Info("migrations applied", "migrations", applied)
+	}
+	if err := db.Seed(context.Background(), dbConn); err != nil {
+		fatal("failed to seed database", "err", err)
+	}
+
+	seeds, err := auth.NewSeedCipher(cfg.JWTKeyEncryptionKey)
+	if err != nil {
+		fatal("invalid JWT_KEY_ENCRYPTION_KEY", "err", err)
+	}
+	if _, err := jobs.RotateSigningKeys(context.Background(), dbConn, cfg.JWTKeyRotation, seeds); err != nil {
+		fatal("failed to prepare signing keys", "err", err)
+	}
+	signingKeys, err := jobs.LoadSigningKeys(context.Background(), dbConn, seeds)
+	if err != nil {
+		fatal("failed to load signing keys", "err", err)
+	}
+	keys := auth.NewKeySet(signingKeys)
+	keys.AcceptLegacy(cfg.JWTSecret, cfg.JWTLegacyUntil)
 
-	// Update last active on each request happens via middleware in router
+	// SIGINT/SIGTERM cancel ctx, which stops the listeners and the jobs.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	addr := fmt.Sprintf(":%d", cfg.HTTPPort)
-	server := &http.Server{
-		Addr:              addr,
-		Handler:           r,
-		ReadTimeout:       30 * time.Second,
-		ReadHeaderTimeout: 15 * time.Second,
-		WriteTimeout:      60 * time.Second,
-		IdleTimeout:       120 * time.Second,
+	var workers sync.WaitGroup
+	runWorker := func(fn func()) {
+		workers.Add(1)
+		go func() { defer workers.Done(); fn() }()
 	}
+	runWorker(func() { jobs.RunAccountPurger(ctx, dbConn, cfg, time.Hour) })
+	runWorker(func() { jobs.RunKeyRotator(ctx, dbConn, keys, seeds, cfg.JWTKeyRotation, 10*time.Minute) })
+
+	r := httpserver.NewRouter(store.NewPostgres(dbConn), cfg, keys, passkeys)
 
+	servers := httpserver.NewServers(cfg)
+	httpHandler := http.Handler(r)
 	if cfg.EnableTLS {
-		certFile := cfg.TLSCertPath
-		keyFile := cfg.TLSKeyPath
-		if _, err := os.Stat(certFile); err == nil {
-			// Serve HTTPS on HTTPS_PORT
-			httpsAddr := fmt.Sprintf(":%d", cfg.HTTPSPort)
-			go func() {
-				log.Printf("HTTPS server listening on %s", httpsAddr)
-				if err := httpserver.ListenAndServeTLS(r, httpsAddr, certFile, keyFile); err != nil && err != http.ErrServerClosed {
-					log.Fatalf("https server error: %v", err)
-				}
-			}()
+		if certs, err := httpserver.NewCertReloader(cfg.TLSCertPath, cfg.TLSKeyPath); err == nil {
+			tc, err := httpserver.TLSConfig(cfg, certs)
+			if err != nil {
+				fatal("invalid TLS settings", "err", err)
+			}
+			servers.AddTLS("https", fmt.Sprintf(":%d", cfg.HTTPSPort), r, tc)
+			runWorker(func() { certs.Watch(ctx, 30*time.Second) })
+			runWorker(func() { reloadOnHangup(ctx, certs) })
+			if cfg.HTTPRedirectToHTTPS {
+				httpHandler = httpserver.RedirectHandler(cfg.HTTPSPort)
+			}
 		} else {
-			log.Printf("ENABLE_TLS=true but cert/key not found at %s / %s; falling back to HTTP", certFile, keyFile)
+			slog.Warn("ENABLE_TLS=true but cert/key not usable; falling back to HTTP", "cert", cfg.TLSCertPath, "key", cfg.TLSKeyPath, "err", err)
 		}
 	}
+	servers.Add("http", fmt.Sprintf(":%d", cfg.HTTPPort), httpHandler)
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(cfg.MetricsToken))
+		servers.Add("metrics", cfg.MetricsAddr, mux)
+	}
+
+	serveErr := servers.Serve(ctx)
+	if serveErr != nil {
+		slog.Error("server failed, shutting down", "err", serveErr)
+	} else {
+		slog.Info("shutting down", "timeout", cfg.ShutdownTimeout.String())
+	}
+	stop()
 
-	log.Printf("HTTP server listening on %s", addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("http server error: %v", err)
+	// One deadline covers draining requests, stopping jobs and flushing spans.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	servers.Shutdown(shutdownCtx)
+	done := make(chan struct{})
+	go func() { workers.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		slog.Warn("background jobs did not stop in time")
+	}
+	if err := dbConn.Close(); err != nil {
+		slog.Warn("closing db failed", "err", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("flushing traces failed", "err", err)
 	}
-}
\ No newline at end of file
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	slog.Info("shutdown complete")
+}
+
+// reloadOnHangup reloads the TLS certificate on every SIGHUP.
+func reloadOnHangup(ctx context.Context, certs *httpserver.CertReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := certs.Reload(); err != nil {
+				slog.Error("reloading TLS certificate failed", "err", err)
+				continue
+			}
+			slog.Info("TLS certificate reloaded on SIGHUP")
+		}
+	}
+}
+
+const usage = `usage: %[1]s                       run the server
+       %[1]s config print          show the effective configuration
+       %[1]s migrate up            apply pending migrations
+       %[1]s migrate down [steps]  revert the last migrations (default 1)
+       %[1]s migrate status        list migrations
+`
+
+// runCommand handles the maintenance subcommands, e.g. "app config print".
+func runCommand(args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		if err := config.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case args[0] == "migrate":
+		runMigrate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]))
+		os.Exit(2)
+	}
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
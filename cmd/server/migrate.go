@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"messagingapi/internal/config"
+	"messagingapi/internal/db"
+)
+
+// runMigrate implements "migrate up|down|status" against the configured
+// database. Unlike server startup, "up" does not seed.
+func runMigrate(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]))
+		os.Exit(2)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+	dbConn, err := db.Connect(cfg.DBDSN, db.Timeouts{Statement: cfg.DBStatementTimeout, IdleInTransaction: cfg.DBIdleInTransactionTimeout})
+	if err != nil {
+		fail(err)
+	}
+	defer dbConn.Close()
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := db.Migrate(ctx, dbConn)
+		for _, name := range applied {
+			fmt.Println("applied", name)
+		}
+		if err != nil {
+			fail(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case args[0] == "down":
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fail(fmt.Errorf("steps must be a positive integer"))
+			}
+		}
+		reverted, err := db.Rollback(ctx, dbConn, steps)
+		for _, name := range reverted {
+			fmt.Println("reverted", name)
+		}
+		if err != nil {
+			fail(err)
+		}
+	case args[0] == "status" && len(args) == 1:
+		states, err := db.MigrationStatus(ctx, dbConn)
+		if err != nil {
+			fail(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED\tDOWN\tNOTE")
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			down, note := "no", ""
+			if s.Down != "" {
+				down = "yes"
+			}
+			if s.Modified {
+				note = "modified after apply"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, applied, down, note)
+		}
+		w.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]))
+		os.Exit(2)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -1,39 +1,233 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AccessTokenTTL is the lifetime of access tokens. Signing keys stay
+// published for at least this long after they stop signing.
+const AccessTokenTTL = 24 * time.Hour
+
+// RefreshTokenTTL is how long a refresh token stays usable. Each use issues
+// the next one, so a session lasts as long as it is renewed within this.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// PurposeTwoFactor marks the short-lived token returned by login when a
+// second factor is still required. It is not accepted as an access token.
+const PurposeTwoFactor = "2fa"
+
+// Challenge tokens also carry their own typ header and audience, so they
+// are told apart from access tokens by more than one claim.
+const (
+	challengeTokenType = "2fa+jwt"
+	challengeAudience  = "2fa"
+)
+
 type Claims struct {
 	UserID string `json:"uid"`
 	Username string `json:"uname"`
+	Purpose string `json:"pur,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(secret string, userID string, username string, ttl time.Duration) (string, error) {
+// SigningKey is an Ed25519 key identified by KID. It signs tokens from
+// ActivatesAt on and verifies them until ExpiresAt (zero means no expiry
+// has been scheduled yet).
+type SigningKey struct {
+	KID         string
+	Private     ed25519.PrivateKey
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	ExpiresAt   time.Time
+}
+
+// KeySet signs tokens with the newest active key and verifies them with any
+// published key, selected by the kid header. It is safe for concurrent use;
+// Replace swaps the keys after a rotation.
+type KeySet struct {
+	mu          sync.RWMutex
+	keys        []SigningKey
+	legacy      []byte
+	legacyUntil time.Time
+}
+
+func NewKeySet(keys []SigningKey) *KeySet {
+	k := &KeySet{}
+	k.Replace(keys)
+	return k
+}
+
+// AcceptLegacy keeps HS256 access tokens issued before the switch to EdDSA
+// verifiable with secret until the until deadline, after which they are all
+// rejected whatever their exp: anyone holding the secret could mint new
+// ones. Nothing is ever signed with it. Call it before serving requests.
+func (k *KeySet) AcceptLegacy(secret string, until time.Time) {
+	if secret == "" {
+		return
+	}
+	k.legacy, k.legacyUntil = []byte(secret), until
+}
+
+func (k *KeySet) Replace(keys []SigningKey) {
+	sorted := append([]SigningKey(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ActivatesAt.After(sorted[j].ActivatesAt) })
+	k.mu.Lock()
+	k.keys = sorted
+	k.mu.Unlock()
+}
+
+func (k *KeySet) signer(now time.Time) (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if !key.ActivatesAt.After(now) && (key.ExpiresAt.IsZero() || key.ExpiresAt.After(now)) {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+func (k *KeySet) verifier(kid string, now time.Time) (ed25519.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.KID == kid && (key.ExpiresAt.IsZero() || key.ExpiresAt.After(now)) {
+			return key.Private.Public().(ed25519.PublicKey), true
+		}
+	}
+	return nil, false
+}
+
+func (k *KeySet) GenerateJWT(userID string, username string, ttl time.Duration) (string, error) {
+	return k.generate(userID, username, "", ttl)
+}
+
+// GenerateChallengeJWT issues a token that only proves the first login
+// factor for userID; it must be exchanged for an access token. It carries
+// a random jti so the exchange can spend it.
+func (k *KeySet) GenerateChallengeJWT(userID string, username string, ttl time.Duration) (string, error) {
+	return k.generate(userID, username, PurposeTwoFactor, ttl)
+}
+
+func (k *KeySet) generate(userID, username, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	key, ok := k.signer(now)
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
 	claims := &Claims{
 		UserID:  userID,
 		Username: username,
+		Purpose: purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(secret))
+	if purpose == PurposeTwoFactor {
+		jti := make([]byte, 16)
+		if _, err := rand.Read(jti); err != nil {
+			return "", err
+		}
+		claims.Audience = jwt.ClaimStrings{challengeAudience}
+		claims.ID = base64.RawURLEncoding.EncodeToString(jti)
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	t.Header["kid"] = key.KID
+	if purpose == PurposeTwoFactor {
+		t.Header["typ"] = challengeTokenType
+	}
+	return t.SignedString(key.Private)
 }
 
-func ParseJWT(secret, token string) (*Claims, error) {
-	parsed, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+// ParseJWT parses an access token. Challenge and other purpose-bound
+// tokens, and any token with an audience, are rejected.
+func (k *KeySet) ParseJWT(token string) (*Claims, error) {
+	claims, typ, err := k.parse(token, true)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" || typ == challengeTokenType || len(claims.Audience) > 0 {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+// ParseChallengeJWT parses a token issued by GenerateChallengeJWT.
+func (k *KeySet) ParseChallengeJWT(token string) (*Claims, error) {
+	claims, typ, err := k.parse(token, false, jwt.WithAudience(challengeAudience))
 	if err != nil {
 		return nil, err
 	}
+	if claims.Purpose != PurposeTwoFactor || typ != challengeTokenType || claims.ID == "" {
+		return nil, errors.New("not a challenge token")
+	}
+	return claims, nil
+}
+
+// parse verifies token and returns its claims and typ header.
+func (k *KeySet) parse(token string, allowLegacy bool, opts ...jwt.ParserOption) (*Claims, string, error) {
+	methods := []string{jwt.SigningMethodEdDSA.Alg()}
+	if allowLegacy && k.legacy != nil && time.Now().Before(k.legacyUntil) {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	parsed, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		// WithValidMethods below has already restricted the algorithm;
+		// the switch ties each algorithm to its key type.
+		switch token.Method.(type) {
+		case *jwt.SigningMethodEd25519:
+			kid, _ := token.Header["kid"].(string)
+			pub, ok := k.verifier(kid, time.Now())
+			if !ok {
+				return nil, errors.New("unknown signing key")
+			}
+			return pub, nil
+		case *jwt.SigningMethodHMAC:
+			return k.legacy, nil
+		}
+		return nil, jwt.ErrTokenSignatureInvalid
+	}, append(opts, jwt.WithValidMethods(methods))...)
+	if err != nil {
+		return nil, "", err
+	}
 	if claims, ok := parsed.Claims.(*Claims); ok && parsed.Valid {
-		return claims, nil
+		typ, _ := parsed.Header["typ"].(string)
+		return claims, typ, nil
 	}
-	return nil, jwt.ErrTokenInvalidClaims
-}
\ No newline at end of file
+	return nil, "", jwt.ErrTokenInvalidClaims
+}
+
+// JWK is the RFC 8037 representation of an Ed25519 public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JWKS lists every key that can still verify tokens, including keys that
+// are published ahead of becoming the signer.
+func (k *KeySet) JWKS() []JWK {
+	now := time.Now()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	out := []JWK{}
+	for _, key := range k.keys {
+		if !key.ExpiresAt.IsZero() && !key.ExpiresAt.After(now) {
+			continue
+		}
+		pub := key.Private.Public().(ed25519.PublicKey)
+		out = append(out, JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub), Kid: key.KID, Alg: "EdDSA", Use: "sig"})
+	}
+	return out
+}
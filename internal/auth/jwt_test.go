@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newSigningKey(t *testing.T, kid string, activates, expires time.Time) SigningKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SigningKey{KID: kid, Private: priv, CreatedAt: activates, ActivatesAt: activates, ExpiresAt: expires}
+}
+
+func kidOf(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func jwksKIDs(k *KeySet) map[string]bool {
+	out := map[string]bool{}
+	for _, j := range k.JWKS() {
+		out[j.Kid] = true
+	}
+	return out
+}
+
+func TestKeySetRotation(t *testing.T) {
+	now := time.Now()
+	a := newSigningKey(t, "a", now.Add(-time.Hour), time.Time{})
+	k := NewKeySet([]SigningKey{a})
+	oldToken, err := k.GenerateJWT("u1", "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A successor is published before it signs.
+	b := newSigningKey(t, "b", now.Add(time.Hour), time.Time{})
+	a.ExpiresAt = b.ActivatesAt.Add(AccessTokenTTL)
+	k.Replace([]SigningKey{a, b})
+	if got := jwksKIDs(k); !got["a"] || !got["b"] {
+		t.Fatalf("JWKS = %v, want a and b", got)
+	}
+	token, _ := k.GenerateJWT("u1", "alice", time.Hour)
+	if kid := kidOf(t, token); kid != "a" {
+		t.Fatalf("signed with %q before the successor activated", kid)
+	}
+
+	// Once it activates it signs, and the old key still verifies.
+	b.ActivatesAt = now.Add(-time.Minute)
+	k.Replace([]SigningKey{a, b})
+	token, _ = k.GenerateJWT("u1", "alice", time.Hour)
+	if kid := kidOf(t, token); kid != "b" {
+		t.Fatalf("signed with %q after rotation, want b", kid)
+	}
+	for _, tok := range []string{oldToken, token} {
+		if c, err := k.ParseJWT(tok); err != nil || c.UserID != "u1" {
+			t.Fatalf("ParseJWT during overlap: %v", err)
+		}
+	}
+
+	// A retired key neither verifies nor is published.
+	a.ExpiresAt = now.Add(-time.Second)
+	k.Replace([]SigningKey{a, b})
+	if _, err := k.ParseJWT(oldToken); err == nil {
+		t.Error("token signed by a retired key verified")
+	}
+	if got := jwksKIDs(k); got["a"] || !got["b"] {
+		t.Errorf("JWKS = %v, want only b", got)
+	}
+
+	// Dropping a key altogether behaves the same.
+	k.Replace([]SigningKey{b})
+	if _, err := k.ParseJWT(oldToken); err == nil {
+		t.Error("token signed by a removed key verified")
+	}
+}
+
+func TestKeySetWithoutActiveKey(t *testing.T) {
+	k := NewKeySet([]SigningKey{newSigningKey(t, "future", time.Now().Add(time.Hour), time.Time{})})
+	if _, err := k.GenerateJWT("u1", "alice", time.Hour); err == nil {
+		t.Fatal("signed without an active key")
+	}
+}
+
+func TestChallengeTokensAreNotAccessTokens(t *testing.T) {
+	k := NewKeySet([]SigningKey{newSigningKey(t, "a", time.Now().Add(-time.Hour), time.Time{})})
+	access, _ := k.GenerateJWT("u1", "alice", time.Hour)
+	challenge, _ := k.GenerateChallengeJWT("u1", "alice", time.Minute)
+
+	if _, err := k.ParseJWT(challenge); err == nil {
+		t.Error("ParseJWT accepted a challenge token")
+	}
+	if _, err := k.ParseChallengeJWT(access); err == nil {
+		t.Error("ParseChallengeJWT accepted an access token")
+	}
+	c, err := k.ParseChallengeJWT(challenge)
+	if err != nil || c.UserID != "u1" || c.ID == "" {
+		t.Fatalf("ParseChallengeJWT = %v, %v", c, err)
+	}
+	other, _ := k.GenerateChallengeJWT("u1", "alice", time.Minute)
+	if o, _ := k.ParseChallengeJWT(other); o == nil || o.ID == c.ID {
+		t.Error("two challenges share a jti")
+	}
+	parsed, _, _ := jwt.NewParser().ParseUnverified(challenge, &Claims{})
+	if parsed.Header["typ"] != challengeTokenType {
+		t.Errorf("challenge typ = %v", parsed.Header["typ"])
+	}
+}
+
+func TestLegacyHS256Tokens(t *testing.T) {
+	k := NewKeySet([]SigningKey{newSigningKey(t, "a", time.Now().Add(-time.Hour), time.Time{})})
+	k.AcceptLegacy("legacy-secret", time.Now().Add(time.Hour))
+	sign := func(c *Claims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("legacy-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	exp := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	if _, err := k.ParseJWT(sign(&Claims{UserID: "u1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: exp}})); err != nil {
+		t.Errorf("legacy access token rejected: %v", err)
+	}
+	challenge := sign(&Claims{UserID: "u1", Purpose: PurposeTwoFactor, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: exp, Audience: jwt.ClaimStrings{challengeAudience}}})
+	if _, err := k.ParseChallengeJWT(challenge); err == nil {
+		t.Error("legacy secret accepted for a challenge token")
+	}
+	if _, err := NewKeySet(nil).ParseJWT(sign(&Claims{UserID: "u1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: exp}})); err == nil {
+		t.Error("HS256 token accepted without a legacy secret")
+	}
+	// Past the deadline even an unexpired token is refused.
+	expired := NewKeySet(nil)
+	expired.AcceptLegacy("legacy-secret", time.Now().Add(-time.Minute))
+	if _, err := expired.ParseJWT(sign(&Claims{UserID: "u1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: exp}})); err == nil {
+		t.Error("HS256 token accepted after JWT_LEGACY_UNTIL")
+	}
+}
+
+func TestSeedCipher(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	c, err := NewSeedCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	rand.Read(seed)
+	sealed, err := c.Seal("kid-1", seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) == len(seed) {
+		t.Fatal("sealed seed has the length of a plain one")
+	}
+	got, err := c.Open("kid-1", sealed)
+	if err != nil || string(got) != string(seed) {
+		t.Fatalf("Open = %x, %v", got, err)
+	}
+	if _, err := c.Open("kid-2", sealed); err == nil {
+		t.Error("seed opened under another key ID")
+	}
+	other := make([]byte, 32)
+	rand.Read(other)
+	c2, _ := NewSeedCipher(other)
+	if _, err := c2.Open("kid-1", sealed); err == nil {
+		t.Error("seed opened with the wrong encryption key")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Open("kid-1", sealed); err == nil {
+		t.Error("tampered seed opened")
+	}
+	if _, err := NewSeedCipher(key[:16]); err == nil {
+		t.Error("16-byte key accepted")
+	}
+}
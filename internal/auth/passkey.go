@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ErrPasskeyCloned is returned when an assertion's signature counter did not
+// advance, which suggests the credential's private key has been copied.
+var ErrPasskeyCloned = errors.New("passkey sign counter regressed")
+
+// PasskeyUser adapts an account to webauthn.User. The user handle is the
+// account UUID, so discoverable logins can be mapped back without a lookup
+// table.
+type PasskeyUser struct {
+	ID          string
+	Name        string
+	DisplayName string
+	Credentials []webauthn.Credential
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte                         { return []byte(u.ID) }
+func (u *PasskeyUser) WebAuthnName() string                       { return u.Name }
+func (u *PasskeyUser) WebAuthnDisplayName() string                { return u.DisplayName }
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
+func (u *PasskeyUser) WebAuthnIcon() string                       { return "" }
+
+// Passkeys runs WebAuthn registration and assertion ceremonies. Session data
+// returned by the Begin methods must be stored by the caller and handed back
+// to the matching Finish method exactly once.
+type Passkeys struct {
+	w *webauthn.WebAuthn
+}
+
+func NewPasskeys(rpID, rpName string, origins []string, timeout time.Duration) (*Passkeys, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Passkeys{w: w}, nil
+}
+
+// BeginRegistration starts enrolling a new credential for u. Credentials u
+// already owns are excluded so the same authenticator is not added twice.
+func (p *Passkeys) BeginRegistration(u *PasskeyUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	exclude := make([]protocol.CredentialDescriptor, len(u.Credentials))
+	for i, c := range u.Credentials {
+		exclude[i] = c.Descriptor()
+	}
+	return p.w.BeginRegistration(u, webauthn.WithExclusions(exclude))
+}
+
+// FinishRegistration verifies the authenticator's attestation response read
+// from body and returns the credential to store.
+func (p *Passkeys) FinishRegistration(u *PasskeyUser, session webauthn.SessionData, body io.Reader) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	return p.w.CreateCredential(u, session, parsed)
+}
+
+// BeginLogin starts an assertion restricted to u's credentials, used when a
+// passkey is the second factor after password and PIN.
+func (p *Passkeys) BeginLogin(u *PasskeyUser) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return p.w.BeginLogin(u)
+}
+
+// BeginDiscoverableLogin starts a passwordless assertion. Because the
+// passkey then replaces both password and PIN, user verification is
+// required rather than preferred.
+func (p *Passkeys) BeginDiscoverableLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return p.w.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishLogin verifies an assertion for u and returns the credential with
+// its updated sign counter.
+func (p *Passkeys) FinishLogin(u *PasskeyUser, session webauthn.SessionData, body io.Reader) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := p.w.ValidateLogin(u, session, parsed)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrPasskeyCloned
+	}
+	return cred, nil
+}
+
+// FinishDiscoverableLogin verifies a passwordless assertion. lookup loads the
+// account for the user handle the authenticator returned.
+func (p *Passkeys) FinishDiscoverableLogin(lookup func(userHandle []byte) (*PasskeyUser, error), session webauthn.SessionData, body io.Reader) (*PasskeyUser, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var user *PasskeyUser
+	cred, err := p.w.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		u, err := lookup(userHandle)
+		user = u
+		return u, err
+	}, session, parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, nil, ErrPasskeyCloned
+	}
+	return user, cred, nil
+}
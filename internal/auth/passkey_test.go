@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"messagingapi/internal/auth/passkeytest"
+)
+
+const (
+	testRPID   = "chat.example.com"
+	testOrigin = "https://chat.example.com"
+)
+
+func newTestPasskeys(t *testing.T) *Passkeys {
+	t.Helper()
+	p, err := NewPasskeys(testRPID, "Test", []string{testOrigin}, time.Minute)
+	if err != nil {
+		t.Fatalf("NewPasskeys: %v", err)
+	}
+	return p
+}
+
+// register enrolls a fresh software authenticator for user and stores the
+// resulting credential on it.
+func register(t *testing.T, p *Passkeys, user *PasskeyUser) *passkeytest.Authenticator {
+	t.Helper()
+	a, err := passkeytest.New(testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, session, err := p.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	body, err := a.Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := p.FinishRegistration(user, *session, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if !bytes.Equal(cred.ID, a.CredentialID()) {
+		t.Fatalf("credential id mismatch")
+	}
+	user.Credentials = append(user.Credentials, *cred)
+	return a
+}
+
+func TestPasskeyDiscoverableLogin(t *testing.T) {
+	p := newTestPasskeys(t)
+	user := &PasskeyUser{ID: "6f1c1f4e-3f0e-4a59-9a57-2a8f8a3f2b10", Name: "alice", DisplayName: "Alice"}
+	a := register(t, p, user)
+
+	for i := 1; i <= 2; i++ {
+		opts, session, err := p.BeginDiscoverableLogin()
+		if err != nil {
+			t.Fatalf("BeginDiscoverableLogin: %v", err)
+		}
+		body, err := a.Get(testRPID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, cred, err := p.FinishDiscoverableLogin(func(handle []byte) (*PasskeyUser, error) {
+			if string(handle) != user.ID {
+				t.Fatalf("user handle = %q, want %q", handle, user.ID)
+			}
+			return user, nil
+		}, *session, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+		if got.ID != user.ID {
+			t.Fatalf("logged in as %q", got.ID)
+		}
+		if cred.Authenticator.SignCount != uint32(i) {
+			t.Fatalf("sign count = %d, want %d", cred.Authenticator.SignCount, i)
+		}
+		user.Credentials[0] = *cred
+	}
+}
+
+func TestPasskeySecondFactorRejectsCounterRegression(t *testing.T) {
+	p := newTestPasskeys(t)
+	user := &PasskeyUser{ID: "a4e0a4d2-6f0c-4a3f-8d57-0f7a2f1f9b21", Name: "bob", DisplayName: "Bob"}
+	a := register(t, p, user)
+
+	login := func() (*webauthn.Credential, error) {
+		opts, session, err := p.BeginLogin(user)
+		if err != nil {
+			t.Fatalf("BeginLogin: %v", err)
+		}
+		body, err := a.Get(testRPID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.FinishLogin(user, *session, bytes.NewReader(body))
+	}
+
+	a.Counter = 10
+	cred, err := login()
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	user.Credentials[0] = *cred
+
+	// A copy of the key that has not seen the last assertion reports an
+	// older counter.
+	a.Counter = 5
+	if _, err := login(); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("err = %v, want ErrPasskeyCloned", err)
+	}
+}
+
+func TestPasskeyRejectsForeignOrigin(t *testing.T) {
+	p := newTestPasskeys(t)
+	user := &PasskeyUser{ID: "0b8a3c51-2d4e-4f6a-8b7c-9d0e1f2a3b4c", Name: "carol", DisplayName: "Carol"}
+	a := register(t, p, user)
+
+	a.Origin = "https://phish.example.net"
+	opts, session, err := p.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := a.Get(testRPID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = p.FinishDiscoverableLogin(func([]byte) (*PasskeyUser, error) { return user, nil }, *session, bytes.NewReader(body))
+	if err == nil {
+		t.Fatal("assertion from a foreign origin was accepted")
+	}
+}
+
+func TestPasskeyRejectsReusedChallenge(t *testing.T) {
+	p := newTestPasskeys(t)
+	user := &PasskeyUser{ID: "5c6d7e8f-9a0b-4c1d-8e2f-3a4b5c6d7e8f", Name: "dave", DisplayName: "Dave"}
+	a := register(t, p, user)
+
+	opts, _, err := p.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := p.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := a.Get(testRPID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FinishLogin(user, *other, bytes.NewReader(body)); err == nil {
+		t.Fatal("assertion for a different challenge was accepted")
+	}
+}
@@ -0,0 +1,134 @@
+// Package passkeytest provides a software FIDO2 authenticator for exercising
+// WebAuthn ceremonies in tests without a browser or hardware key.
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// Authenticator flag bits from the WebAuthn authenticator data layout.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Authenticator holds a single ES256 credential and answers create() and
+// get() requests the way a platform authenticator would, producing the JSON
+// a browser posts back to the relying party.
+type Authenticator struct {
+	Origin string
+	// Counter is the signature counter reported on the next assertion. Tests
+	// may rewind it to simulate a cloned authenticator.
+	Counter uint32
+
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+}
+
+func New(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Authenticator{Origin: origin, key: key, credID: id}, nil
+}
+
+func (a *Authenticator) CredentialID() []byte { return a.credID }
+
+// Create answers navigator.credentials.create() with a "none" attestation.
+func (a *Authenticator) Create(opts *protocol.CredentialCreation) ([]byte, error) {
+	o := opts.Response
+	switch id := o.User.ID.(type) {
+	case protocol.URLEncodedBase64:
+		a.userHandle = id
+	case string:
+		a.userHandle = []byte(id)
+	}
+	clientData, err := a.clientData("webauthn.create", o.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	cose, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	authData := a.authData(o.RelyingParty.ID, flagUserPresent|flagUserVerified|flagAttested)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credID)))
+	authData = append(authData, a.credID...)
+	authData = append(authData, cose...)
+	attObj, err := cbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData),
+			"attestationObject": b64(attObj),
+		},
+	})
+}
+
+// Get answers navigator.credentials.get() for the relying party rpID,
+// incrementing the signature counter first.
+func (a *Authenticator) Get(rpID string, opts *protocol.CredentialAssertion) ([]byte, error) {
+	clientData, err := a.clientData("webauthn.get", opts.Response.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	a.Counter++
+	authData := a.authData(rpID, flagUserPresent|flagUserVerified)
+	digest := sha256.Sum256(clientData)
+	signed := sha256.Sum256(append(append([]byte{}, authData...), digest[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+}
+
+func (a *Authenticator) clientData(typ string, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(map[string]any{"type": typ, "challenge": b64(challenge), "origin": a.Origin})
+}
+
+func (a *Authenticator) authData(rpID string, flags byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	out := append(rpHash[:], flags)
+	return binary.BigEndian.AppendUint32(out, a.Counter)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
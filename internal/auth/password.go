@@ -2,22 +2,76 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 )
 
+// Argon2Params are Argon2id cost parameters. They are plain ints so that
+// out-of-range settings are rejected by validate instead of wrapping when
+// converted to the uint32/uint8 argon2 expects.
 type Argon2Params struct {
-	Time    uint32
-	Memory  uint32
-	Threads uint8
-	KeyLen  uint32
+	Time    int
+	Memory  int // KiB
+	Threads int
+	KeyLen  int
 }
 
-var defaultParams = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+// validate checks p against the ranges argon2.IDKey accepts.
+func (p Argon2Params) validate() error {
+	switch {
+	case p.Time < 1 || uint64(p.Time) > math.MaxUint32:
+		return fmt.Errorf("argon2 time must be between 1 and %d", uint64(math.MaxUint32))
+	case p.Threads < 1 || p.Threads > math.MaxUint8:
+		return fmt.Errorf("argon2 threads must be between 1 and %d", math.MaxUint8)
+	case p.Memory < 8*p.Threads || uint64(p.Memory) > math.MaxUint32:
+		return fmt.Errorf("argon2 memory must be between 8*threads and %d KiB", uint64(math.MaxUint32))
+	case p.KeyLen < 16 || uint64(p.KeyLen) > math.MaxUint32:
+		return fmt.Errorf("argon2 key length must be between 16 and %d", uint64(math.MaxUint32))
+	}
+	return nil
+}
+
+func (p Argon2Params) key(plain string, salt []byte) []byte {
+	return argon2.IDKey([]byte(plain), salt, uint32(p.Time), uint32(p.Memory), uint8(p.Threads), uint32(p.KeyLen))
+}
+
+// legacySeedSalt is the fixed salt the admin seeder used before hashes were
+// salted per user. Hashes carrying it are always rehashed on login.
+var legacySeedSalt = hex.EncodeToString([]byte("static-seed-salt-change-me"))
+
+var (
+	paramsMu      sync.RWMutex
+	defaultParams = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+)
+
+// SetArgon2Params changes the parameters used for new hashes. Existing
+// hashes keep verifying with the parameters encoded in them.
+// A zero KeyLen means 32 bytes.
+func SetArgon2Params(p Argon2Params) error {
+	if p.KeyLen == 0 {
+		p.KeyLen = 32
+	}
+	if err := p.validate(); err != nil {
+		return err
+	}
+	paramsMu.Lock()
+	defaultParams = p
+	paramsMu.Unlock()
+	return nil
+}
+
+func currentParams() Argon2Params {
+	paramsMu.RLock()
+	defer paramsMu.RUnlock()
+	return defaultParams
+}
 
 func generateSalt(n int) ([]byte, error) {
 	b := make([]byte, n)
@@ -30,48 +84,62 @@ func HashPassword(plain string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	key := argon2.IDKey([]byte(plain), salt, defaultParams.Time, defaultParams.Memory, defaultParams.Threads, defaultParams.KeyLen)
-	return fmt.Sprintf("argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", defaultParams.Memory, defaultParams.Time, defaultParams.Threads, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+	p := currentParams()
+	key := p.key(plain, salt)
+	return fmt.Sprintf("argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", p.Memory, p.Time, p.Threads, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
 }
 
-func VerifyPassword(encoded, plain string) (bool, error) {
+type decodedHash struct {
+	params Argon2Params
+	salt   []byte
+	key    []byte
+}
+
+func decodeHash(encoded string) (decodedHash, error) {
+	var d decodedHash
 	parts := strings.Split(encoded, "$")
 	if len(parts) != 5 {
-		return false, errors.New("invalid hash format")
+		return d, errors.New("invalid hash format")
 	}
-	alg := parts[0]
-	if alg != "argon2id" {
-		return false, errors.New("unsupported algorithm")
+	if parts[0] != "argon2id" {
+		return d, errors.New("unsupported algorithm")
 	}
 	// parts[1] is v=19 (ignored)
-	params := parts[2]
-	saltHex := parts[3]
-	keyHex := parts[4]
-
-	var m uint32
-	var t uint32
-	var p uint8
-	_, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &m, &t, &p)
-	if err != nil {
-		return false, err
+	if _, err := fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &d.params.Memory, &d.params.Time, &d.params.Threads); err != nil {
+		return d, err
 	}
-	salt, err := hex.DecodeString(saltHex)
-	if err != nil {
-		return false, err
+	var err error
+	if d.salt, err = hex.DecodeString(parts[3]); err != nil {
+		return d, err
+	}
+	if d.key, err = hex.DecodeString(parts[4]); err != nil {
+		return d, err
 	}
-	expected, err := hex.DecodeString(keyHex)
+	d.params.KeyLen = len(d.key)
+	// A corrupt hash must not reach argon2, which panics on bad parameters.
+	return d, d.params.validate()
+}
+
+func VerifyPassword(encoded, plain string) (bool, error) {
+	d, err := decodeHash(encoded)
 	if err != nil {
 		return false, err
 	}
-	key := argon2.IDKey([]byte(plain), salt, t, m, p, uint32(len(expected)))
-	if len(key) != len(expected) {
-		return false, nil
+	key := d.params.key(plain, d.salt)
+	return subtle.ConstantTimeCompare(key, d.key) == 1, nil
+}
+
+// NeedsRehash reports whether encoded was made with weaker parameters than
+// the current ones, or with the legacy seed salt. Callers rehash it once
+// they hold the plaintext, i.e. right after a successful VerifyPassword.
+func NeedsRehash(encoded string) bool {
+	d, err := decodeHash(encoded)
+	if err != nil {
+		return true
 	}
-	var ok = true
-	for i := range key {
-		if key[i] != expected[i] {
-			ok = false
-		}
+	if hex.EncodeToString(d.salt) == legacySeedSalt {
+		return true
 	}
-	return ok, nil
-}
\ No newline at end of file
+	p := currentParams()
+	return d.params.Time < p.Time || d.params.Memory < p.Memory || d.params.Threads < p.Threads || d.params.KeyLen < p.KeyLen
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+// withParams sets p for the duration of the test.
+func withParams(t *testing.T, p Argon2Params) {
+	t.Helper()
+	old := currentParams()
+	if err := SetArgon2Params(p); err != nil {
+		t.Fatalf("SetArgon2Params(%+v): %v", p, err)
+	}
+	t.Cleanup(func() {
+		paramsMu.Lock()
+		defaultParams = old
+		paramsMu.Unlock()
+	})
+}
+
+func TestSetArgon2ParamsValidates(t *testing.T) {
+	withParams(t, Argon2Params{Time: 1, Memory: 64, Threads: 1})
+	if p := currentParams(); p.KeyLen != 32 {
+		t.Errorf("KeyLen = %d, want default 32", p.KeyLen)
+	}
+	for _, p := range []Argon2Params{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: -1, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+		{Time: 1, Memory: 64 * 1024, Threads: 256}, // would wrap to 0 as uint8
+		{Time: 1, Memory: 7, Threads: 1},
+		{Time: 1, Memory: -1, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 1, KeyLen: 8},
+		{Time: 1, Memory: 64, Threads: 1, KeyLen: -32},
+	} {
+		if err := SetArgon2Params(p); err == nil {
+			t.Errorf("SetArgon2Params(%+v) accepted", p)
+		}
+	}
+	if math.MaxInt > math.MaxUint32 {
+		big := int(uint64(math.MaxInt) >> 1) // past MaxUint32 on 64-bit builds
+		for _, p := range []Argon2Params{{Time: big, Memory: 64, Threads: 1}, {Time: 1, Memory: big, Threads: 1}} {
+			if err := SetArgon2Params(p); err == nil {
+				t.Errorf("SetArgon2Params(%+v) accepted", p)
+			}
+		}
+	}
+	if p := currentParams(); p.Time != 1 || p.Memory != 64 || p.Threads != 1 {
+		t.Errorf("rejected params changed the current ones: %+v", p)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	withParams(t, Argon2Params{Time: 1, Memory: 64, Threads: 1})
+	h, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyPassword(h, "secret"); !ok || err != nil {
+		t.Errorf("VerifyPassword(correct) = %v, %v", ok, err)
+	}
+	if ok, _ := VerifyPassword(h, "wrong"); ok {
+		t.Error("VerifyPassword(wrong) = true")
+	}
+	// Parameters that would panic inside argon2 are rejected when decoding.
+	corrupt := strings.Replace(h, "p=1", "p=0", 1)
+	if _, err := VerifyPassword(corrupt, "secret"); err == nil {
+		t.Error("VerifyPassword accepted a hash with p=0")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	withParams(t, Argon2Params{Time: 1, Memory: 64, Threads: 1})
+	weak, _ := HashPassword("secret")
+	if NeedsRehash(weak) {
+		t.Error("hash made with the current params needs rehash")
+	}
+
+	for _, p := range []Argon2Params{
+		{Time: 2, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 128, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 2},
+		{Time: 1, Memory: 64, Threads: 1, KeyLen: 64},
+	} {
+		withParams(t, p)
+		if !NeedsRehash(weak) {
+			t.Errorf("hash weaker than %+v does not need rehash", p)
+		}
+	}
+
+	// Lowering the params never asks for a rehash of stronger hashes.
+	withParams(t, Argon2Params{Time: 2, Memory: 128, Threads: 2})
+	strong, _ := HashPassword("secret")
+	withParams(t, Argon2Params{Time: 1, Memory: 64, Threads: 1})
+	if NeedsRehash(strong) {
+		t.Error("stronger hash needs rehash")
+	}
+
+	legacy := "argon2id$v=19$m=64,t=1,p=1$" + legacySeedSalt + "$" + hex.EncodeToString(make([]byte, 32))
+	if !NeedsRehash(legacy) {
+		t.Error("hash with the legacy seed salt does not need rehash")
+	}
+	if !NeedsRehash("not-a-hash") {
+		t.Error("malformed hash does not need rehash")
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// sealedSeedVersion prefixes seeds sealed by SeedCipher. Plain 32-byte
+// seeds written before encryption at rest have no prefix.
+const sealedSeedVersion = 1
+
+// SeedCipher encrypts signing key seeds for storage with AES-256-GCM. The
+// key ID is authenticated with each seed, so a sealed seed cannot be moved
+// to another key's row.
+type SeedCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSeedCipher returns a cipher for a 32-byte key.
+func NewSeedCipher(key []byte) (*SeedCipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("seed encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SeedCipher{aead: aead}, nil
+}
+
+// Seal encrypts seed for the key kid.
+func (c *SeedCipher) Seal(kid string, seed []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), 1+c.aead.NonceSize()+len(seed)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{sealedSeedVersion}, nonce...)
+	return c.aead.Seal(out, nonce, seed, []byte(kid)), nil
+}
+
+// Open decrypts a seed sealed for kid.
+func (c *SeedCipher) Open(kid string, sealed []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < 1+n+c.aead.Overhead() || sealed[0] != sealedSeedVersion {
+		return nil, errors.New("not a sealed seed")
+	}
+	seed, err := c.aead.Open(nil, sealed[1:1+n], sealed[1+n:], []byte(kid))
+	if err != nil {
+		return nil, errors.New("cannot decrypt seed: wrong encryption key or corrupted row")
+	}
+	return seed, nil
+}
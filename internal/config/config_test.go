@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey is a valid JWT_KEY_ENCRYPTION_KEY, the one required setting.
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// setenv sets key/value pairs for the test, always including a valid
+// JWT_KEY_ENCRYPTION_KEY unless the pairs override it.
+func setenv(t *testing.T, kv ...string) {
+	t.Helper()
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", testKey)
+	for i := 0; i+1 < len(kv); i += 2 {
+		t.Setenv(kv[i], kv[i+1])
+	}
+}
+
+// writeFile writes content to name in a temp dir and returns the path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustLoad(t *testing.T) Config {
+	t.Helper()
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return c
+}
+
+func problems(t *testing.T) []string {
+	t.Helper()
+	_, err := Load()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, want a ValidationError", err)
+	}
+	return verr.Problems
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setenv(t)
+	c := mustLoad(t)
+	if c.HTTPPort != 8081 || c.LogLevel != "info" || c.PINLockout != 15*time.Minute || c.TLSCertPath != "/data/tls/server.crt" {
+		t.Errorf("defaults = %+v", c)
+	}
+}
+
+func TestEnvironmentOverridesFile(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+http_port: 9000
+LOG_LEVEL: debug
+pin_max_attempts: 7
+`)
+	setenv(t, "CONFIG_FILE", path, "HTTP_PORT", "9100", "PIN_MAX_ATTEMPTS", "")
+	c := mustLoad(t)
+	if c.HTTPPort != 9100 {
+		t.Errorf("HTTPPort = %d, want the environment's 9100", c.HTTPPort)
+	}
+	if c.LogLevel != "debug" {
+		t.Errorf("LogLevel = %q, want the file's debug", c.LogLevel)
+	}
+	// An empty variable counts as unset.
+	if c.PINMaxAttempts != 7 {
+		t.Errorf("PINMaxAttempts = %d, want the file's 7", c.PINMaxAttempts)
+	}
+}
+
+func TestYAMLFile(t *testing.T) {
+	path := writeFile(t, "config.yml", `
+enable_tls: true
+tls_cipher_suites:
+  - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+  - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+tls_client_ca_file: /etc/ca.pem
+mtls_service_accounts:
+  cn:bot: 9a41e2d7-5b3c-4f8e-a1d6-7c2e9b0f3d54
+  uri:spiffe://corp/notifier: 3f0c6a52-8d1e-4c1b-9f7a-2b5d8e4c1a90
+tracing_sample_ratio: 0.25
+`)
+	setenv(t, "CONFIG_FILE", path)
+	c := mustLoad(t)
+	if !c.EnableTLS || c.TracingSampleRatio != 0.25 || c.TLSClientCAPath != "/etc/ca.pem" {
+		t.Errorf("config = %+v", c)
+	}
+	if want := []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}; !slices.Equal(c.TLSCipherSuites, want) {
+		t.Errorf("TLSCipherSuites = %v, want %v", c.TLSCipherSuites, want)
+	}
+	if len(c.ServiceAccounts) != 2 || c.ServiceAccounts["uri:spiffe://corp/notifier"] != "3f0c6a52-8d1e-4c1b-9f7a-2b5d8e4c1a90" {
+		t.Errorf("ServiceAccounts = %v", c.ServiceAccounts)
+	}
+}
+
+func TestTOMLFile(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+http_port = 9000
+webauthn_rp_id = "chat.example.com"
+webauthn_rp_origins = ["https://chat.example.com", "https://app.example.com"]
+tls_client_ca_file = "/etc/ca.pem"
+
+[mtls_service_accounts]
+"cn:bot" = "9a41e2d7-5b3c-4f8e-a1d6-7c2e9b0f3d54"
+`)
+	setenv(t, "CONFIG_FILE", path)
+	c := mustLoad(t)
+	if c.HTTPPort != 9000 || c.WebAuthnRPID != "chat.example.com" {
+		t.Errorf("config = %+v", c)
+	}
+	if want := []string{"https://chat.example.com", "https://app.example.com"}; !slices.Equal(c.WebAuthnRPOrigins, want) {
+		t.Errorf("WebAuthnRPOrigins = %v, want %v", c.WebAuthnRPOrigins, want)
+	}
+	if c.ServiceAccounts["cn:bot"] != "9a41e2d7-5b3c-4f8e-a1d6-7c2e9b0f3d54" {
+		t.Errorf("ServiceAccounts = %v", c.ServiceAccounts)
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	secret := writeFile(t, "jwt_secret", "s3cret-value\n")
+	key := writeFile(t, "key", testKey+"\n")
+	setenv(t, "JWT_SECRET_FILE", secret, "JWT_LEGACY_UNTIL", "2030-01-01T00:00:00Z", "JWT_KEY_ENCRYPTION_KEY", "", "JWT_KEY_ENCRYPTION_KEY_FILE", key)
+	c := mustLoad(t)
+	if c.JWTSecret != "s3cret-value" || string(c.JWTKeyEncryptionKey) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("JWTSecret = %q, JWTKeyEncryptionKey = %q", c.JWTSecret, c.JWTKeyEncryptionKey)
+	}
+
+	// The file may name the secret's file too.
+	path := writeFile(t, "config.yaml", "jwt_secret_file: "+secret+"\n")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("JWT_SECRET_FILE", "")
+	if c := mustLoad(t); c.JWTSecret != "s3cret-value" {
+		t.Errorf("JWTSecret from a file named in CONFIG_FILE = %q", c.JWTSecret)
+	}
+}
+
+func TestUnknownFileKeys(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+http_prot: 9000
+log_level: debug
+unknown_file: /etc/x
+`)
+	setenv(t, "CONFIG_FILE", path)
+	want := []string{"CONFIG_FILE: unknown key HTTP_PROT", "CONFIG_FILE: unknown key UNKNOWN_FILE"}
+	if got := problems(t); !slices.Equal(got, want) {
+		t.Errorf("problems = %q, want %q", got, want)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	ini := writeFile(t, "app.ini", "http_port=9000\n")
+	tests := []struct {
+		name string
+		env  []string
+		want string
+	}{
+		{"not an integer", []string{"HTTP_PORT", "eighty"}, `HTTP_PORT: "eighty" is not an integer`},
+		{"not a number", []string{"TRACING_SAMPLE_RATIO", "half"}, `TRACING_SAMPLE_RATIO: "half" is not a number`},
+		{"not a boolean", []string{"ENABLE_TLS", "maybe"}, `ENABLE_TLS: "maybe" is not a boolean`},
+		{"not key=value", []string{"MTLS_SERVICE_ACCOUNTS", "cn:bot", "TLS_CLIENT_CA_FILE", "/ca.pem"}, `MTLS_SERVICE_ACCOUNTS: "cn:bot" is not key=value`},
+		{"missing key", []string{"JWT_KEY_ENCRYPTION_KEY", ""}, "JWT_KEY_ENCRYPTION_KEY: required; generate one with `openssl rand -base64 32`"},
+		{"short key", []string{"JWT_KEY_ENCRYPTION_KEY", "c2hvcnQ="}, "JWT_KEY_ENCRYPTION_KEY: must be 32 bytes, base64-encoded"},
+		{"secret and file", []string{"JWT_SECRET", "a", "JWT_SECRET_FILE", "/s"}, "JWT_SECRET and JWT_SECRET_FILE are both set"},
+		{"secret file missing", []string{"JWT_SECRET_FILE", missing}, "JWT_SECRET_FILE: open " + missing + ": no such file or directory"},
+		{"config file format", []string{"CONFIG_FILE", ini}, "CONFIG_FILE: " + ini + ": unknown format, use .yaml, .yml or .toml"},
+		{"port range", []string{"HTTPS_PORT", "70000"}, "HTTPS_PORT: 70000 is not a valid port"},
+		{"tls version", []string{"TLS_MIN_VERSION", "1.1"}, `TLS_MIN_VERSION: "1.1" must be one of 1.2, 1.3`},
+		{"log level", []string{"LOG_LEVEL", "verbose"}, `LOG_LEVEL: "verbose" must be one of debug, info, warn, error`},
+		{"tracing exporter", []string{"TRACING_EXPORTER", "jaeger"}, `TRACING_EXPORTER: "jaeger" must be one of none, otlp`},
+		{"sample ratio", []string{"TRACING_SAMPLE_RATIO", "1.5"}, "TRACING_SAMPLE_RATIO: 1.5 must be between 0 and 1"},
+		{"argon2 threads", []string{"ARGON2_THREADS", "256"}, "ARGON2_THREADS: must be at most 255"},
+		{"placeholder secret", []string{"JWT_SECRET", "change-me", "JWT_LEGACY_UNTIL", "2030-01-01T00:00:00Z"}, "JWT_SECRET: is set to a published placeholder; unset it or use a random value"},
+		{"legacy secret without deadline", []string{"JWT_SECRET", "s3cret-value"}, "JWT_LEGACY_UNTIL: required while JWT_SECRET is set; use the time after which no HS256 token may still be accepted"},
+		{"legacy deadline format", []string{"JWT_SECRET", "s3cret-value", "JWT_LEGACY_UNTIL", "tomorrow"}, `JWT_LEGACY_UNTIL: "tomorrow" is not an RFC 3339 time`},
+		{"redirect without tls", []string{"HTTP_REDIRECT_TO_HTTPS", "true"}, "HTTP_REDIRECT_TO_HTTPS: requires ENABLE_TLS"},
+		{"service accounts without ca", []string{"MTLS_SERVICE_ACCOUNTS", "cn:bot=9a41e2d7-5b3c-4f8e-a1d6-7c2e9b0f3d54"}, "MTLS_SERVICE_ACCOUNTS: requires TLS_CLIENT_CA_FILE"},
+		{"service account username", []string{"MTLS_SERVICE_ACCOUNTS", "cn:bot=bot", "TLS_CLIENT_CA_FILE", "/ca.pem"}, `MTLS_SERVICE_ACCOUNTS: cn:bot must map to a user ID, got "bot"`},
+		{"webauthn origins", []string{"WEBAUTHN_RP_ID", "chat.example.com"}, "WEBAUTHN_RP_ORIGINS: required when WEBAUTHN_RP_ID is set"},
+	}
+	for _, key := range []string{"ARGON2_TIME", "ARGON2_MEMORY_KIB", "ARGON2_THREADS", "VAULT_MAX_ATTEMPTS", "PIN_MAX_ATTEMPTS", "PIN_LOCKOUT_MINUTES", "TOTP_MAX_ATTEMPTS", "TOTP_LOCKOUT_MINUTES", "JWT_KEY_ROTATION_HOURS", "USER_INVITE_TTL_HOURS", "SHUTDOWN_TIMEOUT_SECONDS"} {
+		tests = append(tests, struct {
+			name string
+			env  []string
+			want string
+		}{strings.ToLower(key), []string{key, "0"}, key + ": must be at least 1"})
+	}
+	for _, key := range []string{"USER_INVITE_QUOTA", "SEALED_SENDER_DAILY_TOKENS", "ACCOUNT_DELETION_GRACE_HOURS", "HSTS_MAX_AGE_SECONDS", "DB_STATEMENT_TIMEOUT_SECONDS", "DB_IDLE_IN_TRANSACTION_TIMEOUT_SECONDS", "HTTP_READ_TIMEOUT_SECONDS", "HTTP_READ_HEADER_TIMEOUT_SECONDS", "HTTP_WRITE_TIMEOUT_SECONDS", "HTTP_IDLE_TIMEOUT_SECONDS"} {
+		tests = append(tests, struct {
+			name string
+			env  []string
+			want string
+		}{strings.ToLower(key), []string{key, "-1"}, key + ": must not be negative"})
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setenv(t, tc.env...)
+			if got := problems(t); !slices.Equal(got, []string{tc.want}) {
+				t.Errorf("problems = %q, want [%q]", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLegacySecretWarnings(t *testing.T) {
+	setenv(t)
+	if w := mustLoad(t).Warnings(); len(w) != 0 {
+		t.Errorf("warnings without JWT_SECRET = %q", w)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	setenv(t, "JWT_SECRET", "s3cret-value", "JWT_LEGACY_UNTIL", future)
+	c := mustLoad(t)
+	if w := c.Warnings(); len(w) != 1 || !strings.Contains(w[0], "accepted until "+future) {
+		t.Errorf("warnings before the deadline = %q", w)
+	}
+	t.Setenv("JWT_LEGACY_UNTIL", "2020-01-01T00:00:00Z")
+	if w := mustLoad(t).Warnings(); len(w) != 1 || !strings.Contains(w[0], "has passed") {
+		t.Errorf("warnings after the deadline = %q", w)
+	}
+}
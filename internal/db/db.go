@@ -2,11 +2,31 @@ package db
 
 import (
 	"database/sql"
-	_ "github.com/lib/pq"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
-func Connect(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dsn)
+// Timeouts are set on every pooled connection; zero disables one.
+// IdleInTransaction also bounds how long an abandoned transaction can hold
+// back /messages/sync, which waits for every older transaction to end.
+type Timeouts struct {
+	Statement         time.Duration
+	IdleInTransaction time.Duration
+}
+
+func Connect(dsn string, t Timeouts) (*sql.DB, error) {
+	dsn, err := withTimeouts(dsn, t)
+	if err != nil {
+		return nil, err
+	}
+	// Every query, exec and transaction becomes a child span of the request
+	// whose context it was issued with.
+	db, err := otelsql.Open("postgres", dsn, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return nil, err
 	}
@@ -14,4 +34,17 @@ func Connect(dsn string) (*sql.DB, error) {
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(0)
 	return db, db.Ping()
-}
\ No newline at end of file
+}
+
+// withTimeouts appends the timeouts to dsn as run-time parameters, which
+// lib/pq sends in the startup message. Later keys win, so they override any
+// the DSN already sets.
+func withTimeouts(dsn string, t Timeouts) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s statement_timeout=%d idle_in_transaction_session_timeout=%d", dsn, t.Statement.Milliseconds(), t.IdleInTransaction.Milliseconds()), nil
+}
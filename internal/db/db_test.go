@@ -0,0 +1,29 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"messagingapi/internal/db"
+)
+
+func TestWithTimeouts(t *testing.T) {
+	timeouts := db.Timeouts{Statement: time.Minute, IdleInTransaction: 30 * time.Second}
+	for _, dsn := range []string{
+		"postgres://app:secret@db:5432/messaging?sslmode=disable&statement_timeout=5",
+		"host=db port=5432 dbname=messaging sslmode=disable statement_timeout=5",
+	} {
+		got, err := db.WithTimeouts(dsn, timeouts)
+		if err != nil {
+			t.Fatalf("%s: %v", dsn, err)
+		}
+		// The appended keys come last, so they win over the DSN's own.
+		if !strings.HasSuffix(got, " statement_timeout=60000 idle_in_transaction_session_timeout=30000") || !strings.Contains(got, "messaging") {
+			t.Errorf("%s: got %q", dsn, got)
+		}
+	}
+	if _, err := db.WithTimeouts("postgres://%zz", timeouts); err == nil {
+		t.Error("malformed URL accepted")
+	}
+}
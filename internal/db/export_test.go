@@ -0,0 +1,11 @@
+package db
+
+// MigrateList is Migrate with the given migrations instead of the
+// embedded ones.
+var MigrateList = migrate
+
+// LoadMigrations returns the embedded migrations.
+var LoadMigrations = loadMigrations
+
+// WithTimeouts is the DSN rewrite done by Connect.
+var WithTimeouts = withTimeouts
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID keys the advisory lock held while migrating, so replicas
+// starting together apply each migration once.
+const migrationLockID = 7267358147
+
+// A Migration is one NNN_name.sql file, plus NNN_name.down.sql when it can
+// be reverted. Each file runs as a whole inside its own transaction, so it
+// may contain functions, DO blocks and literals with semicolons.
+type Migration struct {
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationState is a migration as reported by MigrationStatus.
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time
+	Applied   bool
+	// Modified means the file changed after it was applied.
+	Modified bool
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	downs := map[string]string{}
+	var list []Migration
+	for _, e := range entries {
+		b, err := migrationsFS.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if base, ok := strings.CutSuffix(e.Name(), ".down.sql"); ok {
+			downs[base+".sql"] = string(b)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		list = append(list, Migration{Name: e.Name(), Up: string(b), Checksum: hex.EncodeToString(sum[:])})
+	}
+	for i := range list {
+		list[i].Down = downs[list[i].Name]
+		delete(downs, list[i].Name)
+	}
+	for name := range downs {
+		return nil, fmt.Errorf("down migration without up migration: %s", name)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, after making sure schema_migrations is current.
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, applied map[string]appliedMigration) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Waiting for another replica's migrations, or a migration rewriting a
+	// large table, may take longer than the pool's statement_timeout.
+	if _, err := conn.ExecContext(ctx, `SET statement_timeout = 0`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `RESET statement_timeout`)
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (id SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`); err != nil {
+		return err
+	}
+	// Rows written before checksums were recorded get the current file's
+	// checksum on the next run.
+	if _, err := conn.ExecContext(ctx, `ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum TEXT`); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+type appliedMigration struct {
+	checksum  sql.NullString
+	appliedAt time.Time
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[string]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]appliedMigration{}
+	for rows.Next() {
+		var name string
+		var a appliedMigration
+		if err := rows.Scan(&name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		out[name] = a
+	}
+	return out, rows.Err()
+}
+
+// Migrate applies every pending migration in order and returns their names.
+// It refuses to run when an applied migration's file has been edited.
+func Migrate(ctx context.Context, db *sql.DB) ([]string, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return migrate(ctx, db, migrations)
+}
+
+func migrate(ctx context.Context, db *sql.DB, migrations []Migration) ([]string, error) {
+	var done []string
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[string]appliedMigration) error {
+		for _, m := range migrations {
+			a, ok := applied[m.Name]
+			if !ok {
+				continue
+			}
+			if !a.checksum.Valid {
+				if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET checksum=$1 WHERE name=$2`, m.Checksum, m.Name); err != nil {
+					return err
+				}
+			} else if a.checksum.String != m.Checksum {
+				return fmt.Errorf("migration %s was modified after it was applied; add a new migration instead", m.Name)
+			}
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Name]; ok {
+				continue
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (name, checksum) VALUES ($1,$2)`, m.Name, m.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s failed: %w", m.Name, err)
+			}
+			done = append(done, m.Name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Rollback reverts the last steps applied migrations, newest first, and
+// returns their names. It stops at a migration without a down file.
+func Rollback(ctx context.Context, db *sql.DB, steps int) ([]string, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []string
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[string]appliedMigration) error {
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Name]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %s has no down migration", m.Name)
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE name=$1`, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting %s failed: %w", m.Name, err)
+			}
+			done = append(done, m.Name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrationStatus lists every known migration with whether and when it was
+// applied.
+func MigrationStatus(ctx context.Context, db *sql.DB) ([]MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationState
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[string]appliedMigration) error {
+		for _, m := range migrations {
+			s := MigrationState{Migration: m}
+			if a, ok := applied[m.Name]; ok {
+				s.Applied, s.AppliedAt = true, a.appliedAt
+				s.Modified = a.checksum.Valid && a.checksum.String != m.Checksum
+			}
+			out = append(out, s)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,161 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+
+	"messagingapi/internal/auth"
+	"messagingapi/internal/db"
+	"messagingapi/internal/db/dbtest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := db.LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range list {
+		if i > 0 && list[i-1].Name >= m.Name {
+			t.Errorf("migrations out of order: %s before %s", list[i-1].Name, m.Name)
+		}
+		if len(m.Checksum) != 64 || m.Up == "" {
+			t.Errorf("%s: checksum %q, %d bytes of SQL", m.Name, m.Checksum, len(m.Up))
+		}
+	}
+}
+
+func tableExists(t *testing.T, conn *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	if err := conn.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestMigrateRejectsModifiedMigration(t *testing.T) {
+	conn := dbtest.Empty(t)
+	ctx := context.Background()
+	m := db.Migration{Name: "001_a.sql", Up: "CREATE TABLE a (id int)", Checksum: "one"}
+	if _, err := db.MigrateList(ctx, conn, []db.Migration{m}); err != nil {
+		t.Fatal(err)
+	}
+	m.Checksum = "two"
+	next := db.Migration{Name: "002_b.sql", Up: "CREATE TABLE b (id int)", Checksum: "three"}
+	_, err := db.MigrateList(ctx, conn, []db.Migration{m, next})
+	if err == nil || !strings.Contains(err.Error(), "001_a.sql was modified") {
+		t.Fatalf("err = %v, want modified migration error", err)
+	}
+	if tableExists(t, conn, "b") {
+		t.Error("pending migration applied despite the checksum mismatch")
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	conn := dbtest.Empty(t)
+	ctx := context.Background()
+	migrations := []db.Migration{
+		{Name: "001_a.sql", Up: "CREATE TABLE a (id int)", Checksum: "a"},
+		{Name: "002_b.sql", Up: "CREATE TABLE b (id int); SELECT 1/0", Checksum: "b"},
+		{Name: "003_c.sql", Up: "CREATE TABLE c (id int)", Checksum: "c"},
+	}
+	done, err := db.MigrateList(ctx, conn, migrations)
+	if err == nil || !strings.Contains(err.Error(), "002_b.sql failed") {
+		t.Fatalf("err = %v, want 002_b.sql failure", err)
+	}
+	if len(done) != 1 || done[0] != "001_a.sql" {
+		t.Errorf("done = %v, want [001_a.sql]", done)
+	}
+	if !tableExists(t, conn, "a") || tableExists(t, conn, "b") || tableExists(t, conn, "c") {
+		t.Error("failed migration was not rolled back, or later ones ran")
+	}
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE name='002_b.sql'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("failed migration recorded: n=%d err=%v", n, err)
+	}
+
+	migrations[1].Up = "CREATE TABLE b (id int)"
+	if done, err = db.MigrateList(ctx, conn, migrations); err != nil || len(done) != 2 {
+		t.Fatalf("retry: done=%v err=%v", done, err)
+	}
+}
+
+// openPools opens n separate pools on one database, so each runs on its
+// own connections.
+func openPools(t *testing.T, n int) []*sql.DB {
+	dsn := dbtest.DSN(t)
+	var pools []*sql.DB
+	for range n {
+		conn, err := db.Connect(dsn, db.Timeouts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		pools = append(pools, conn)
+	}
+	return pools
+}
+
+func TestMigrationLockSerializes(t *testing.T) {
+	pools := openPools(t, 4)
+	// Without the lock the replicas would race to create the same table.
+	migrations := []db.Migration{{Name: "001_slow.sql", Up: "SELECT pg_sleep(0.2); CREATE TABLE slow (id int)", Checksum: "slow"}}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := 0
+	for _, p := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := db.MigrateList(context.Background(), p, migrations)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			applied += len(done)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if applied != 1 {
+		t.Errorf("migration applied %d times, want once", applied)
+	}
+}
+
+func TestSeedRunsOnce(t *testing.T) {
+	if err := auth.SetArgon2Params(auth.Argon2Params{Time: 1, Memory: 64, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	pools := openPools(t, 4)
+	ctx := context.Background()
+	if _, err := db.Migrate(ctx, pools[0]); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, p := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Seed(ctx, p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := db.Seed(ctx, pools[0]); err != nil {
+		t.Fatal(err)
+	}
+	var admins, invites int
+	if err := pools[0].QueryRow(`SELECT (SELECT COUNT(*) FROM users WHERE is_admin), (SELECT COUNT(*) FROM invites)`).Scan(&admins, &invites); err != nil {
+		t.Fatal(err)
+	}
+	if admins != 1 || invites != 1 {
+		t.Errorf("seeded %d admins and %d invites, want one each", admins, invites)
+	}
+}
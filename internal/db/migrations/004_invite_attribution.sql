@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_id UUID NULL REFERENCES invites(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_invite_id ON users(invite_id);
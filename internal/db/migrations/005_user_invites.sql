@@ -0,0 +1,4 @@
+-- NULL means the user gets config.UserInviteQuota.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_quota INT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invites_created_by ON invites(created_by);
@@ -0,0 +1,22 @@
+ALTER TABLE chat_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+UPDATE chat_members cm SET role='owner' FROM chats c WHERE cm.chat_id=c.id AND cm.user_id=c.created_by;
+
+-- kind is 'register' (consumed by POST /auth/register) or 'chat_join'
+-- (redeemed by existing users via POST /chats/join). Either may name a chat.
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'register';
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS chat_id UUID NULL REFERENCES chats(id) ON DELETE CASCADE;
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS member_role TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS requires_approval BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS chat_join_requests (
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invite_id UUID NULL REFERENCES invites(id) ON DELETE SET NULL,
+    role TEXT NOT NULL DEFAULT 'member',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chat_id, user_id)
+);
+
+-- System events (member joined, key changed, ...) are stored as plaintext
+-- JSON alongside an empty ciphertext.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS event JSONB;
@@ -0,0 +1,10 @@
+-- Ed25519 signing keys shared by every replica. seed is the 32-byte private
+-- key seed. A key signs from activates_at and is published in the JWKS until
+-- expires_at, which is set once a newer key takes over.
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    kid TEXT PRIMARY KEY,
+    seed BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    activates_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ
+);
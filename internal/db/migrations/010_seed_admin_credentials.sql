@@ -0,0 +1,4 @@
+-- Accounts still holding a hash made with the old static seed salt are the
+-- seeded admin with its published credentials; force them to be replaced.
+UPDATE users SET must_change_credentials = true
+WHERE password_hash LIKE '%$' || encode('static-seed-salt-change-me'::bytea, 'hex') || '$%';
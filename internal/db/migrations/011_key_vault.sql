@@ -0,0 +1,11 @@
+-- Client-encrypted backups of E2E private keys, released only to a caller
+-- who knows the account PIN. failed_attempts counts wrong PINs since the
+-- last success; the row is deleted when it reaches the configured limit.
+CREATE TABLE IF NOT EXISTS key_vaults (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    ciphertext TEXT NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key_changed_at TIMESTAMPTZ;
+
+-- A verification pins the exact key the user compared safety numbers for;
+-- it stops counting as verified as soon as the contact's key changes.
+CREATE TABLE IF NOT EXISTS contact_verifications (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    contact_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    verified_key TEXT NOT NULL,
+    verified_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, contact_id)
+);
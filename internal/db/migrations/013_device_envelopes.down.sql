@@ -0,0 +1,5 @@
+-- Fan-out messages have no readable content without their envelopes.
+DELETE FROM messages WHERE fanout;
+DROP TABLE IF EXISTS message_envelopes;
+ALTER TABLE messages DROP COLUMN IF EXISTS fanout;
+DROP TABLE IF EXISTS devices;
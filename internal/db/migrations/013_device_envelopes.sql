@@ -0,0 +1,22 @@
+-- Devices let a message carry one encrypted envelope per recipient device,
+-- which is how sender keys are distributed pairwise in groups.
+CREATE TABLE IF NOT EXISTS devices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);
+
+-- fanout messages have an empty ciphertext; their content lives in
+-- message_envelopes and is only visible to the addressed devices.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS fanout BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS message_envelopes (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    ciphertext TEXT NOT NULL,
+    PRIMARY KEY (message_id, device_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_envelopes_device_id ON message_envelopes(device_id);
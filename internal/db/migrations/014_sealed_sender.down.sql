@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS delivery_token_issuance;
+DROP TABLE IF EXISTS delivery_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS sealed_access_key_hash;
+ALTER TABLE messages DROP COLUMN IF EXISTS sealed;
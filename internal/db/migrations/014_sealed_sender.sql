@@ -0,0 +1,21 @@
+-- Sealed-sender messages are stored without sender_id (nullable since
+-- 002_account_deletion); the sender is only named inside the ciphertext.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS sealed BOOLEAN NOT NULL DEFAULT false;
+
+-- sha256 of the access key a user hands to contacts allowed to reach them
+-- by sealed sender. NULL disables sealed delivery to that user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sealed_access_key_hash TEXT;
+
+-- Single-use delivery tokens. They are deliberately not linked to the
+-- account that obtained them; issuance is only counted per user and day.
+CREATE TABLE IF NOT EXISTS delivery_tokens (
+    token_hash TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS delivery_token_issuance (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    issued INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
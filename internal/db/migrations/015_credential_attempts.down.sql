@@ -0,0 +1,3 @@
+ALTER TABLE key_vaults ADD COLUMN IF NOT EXISTS failed_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE users DROP COLUMN IF EXISTS credentials_locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_credential_attempts;
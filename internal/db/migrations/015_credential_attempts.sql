@@ -0,0 +1,7 @@
+-- One failed-attempt counter for every endpoint that checks the PIN, so
+-- guesses cannot be spread across endpoints. credentials_locked_until is
+-- set when the counter reaches a multiple of PIN_MAX_ATTEMPTS. It replaces
+-- the counter that only the key backup had.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_credential_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS credentials_locked_until TIMESTAMPTZ;
+ALTER TABLE key_vaults DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS login_challenges;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_failed_attempts;
@@ -0,0 +1,16 @@
+-- Wrong second-factor codes at login since the last accepted one, and the
+-- lockout they trigger.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failed_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until TIMESTAMPTZ;
+
+-- Challenge tokens from /auth/login that have been presented, by jti. A
+-- token is spent once it is exchanged or after a few wrong codes; rows are
+-- dropped once the token has expired anyway.
+CREATE TABLE IF NOT EXISTS login_challenges (
+    jti TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    failures INT NOT NULL DEFAULT 0,
+    used_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_login_challenges_expires_at ON login_challenges(expires_at);
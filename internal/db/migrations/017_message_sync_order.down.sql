@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_sync;
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE messages DROP COLUMN IF EXISTS tx_id;
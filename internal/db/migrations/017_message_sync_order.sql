@@ -0,0 +1,18 @@
+-- Sync orders messages by the transaction that inserted them (tx_id) and
+-- then by insertion (seq), and only returns messages of transactions older
+-- than every one still running. created_at is the transaction's start time,
+-- so ordering by it let a slow insert commit behind a cursor that had
+-- already moved past it. Existing messages keep their created_at order.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tx_id XID8;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+CREATE SEQUENCE IF NOT EXISTS messages_seq OWNED BY messages.seq;
+UPDATE messages m SET tx_id = '0', seq = o.n
+    FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM messages) o
+    WHERE o.id = m.id AND m.seq IS NULL;
+SELECT setval('messages_seq', COALESCE((SELECT max(seq) FROM messages), 0) + 1, false);
+ALTER TABLE messages
+    ALTER COLUMN tx_id SET DEFAULT pg_current_xact_id(),
+    ALTER COLUMN tx_id SET NOT NULL,
+    ALTER COLUMN seq SET DEFAULT nextval('messages_seq'),
+    ALTER COLUMN seq SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_sync ON messages(tx_id, seq);
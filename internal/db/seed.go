@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"messagingapi/internal/auth"
+)
+
+// Seed creates the first admin and the default invite on an empty
+// database. It is kept apart from the schema migrations and does nothing
+// once either exists. It runs under the migration lock and in one
+// transaction, so replicas starting together seed once.
+func Seed(ctx context.Context, db *sql.DB) error {
+	return withMigrationLock(ctx, db, func(conn *sql.Conn, _ map[string]appliedMigration) error {
+		return inTx(ctx, conn, func(tx *sql.Tx) error { return seed(ctx, tx) })
+	})
+}
+
+func seed(ctx context.Context, tx *sql.Tx) error {
+	var adminCount int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE is_admin=true`).Scan(&adminCount); err != nil {
+		return err
+	}
+	if adminCount == 0 {
+		// The well-known admin/0000 credentials only get the operator through
+		// the first login; they must be replaced before anything else.
+		passwordHash, err := auth.HashPassword("admin")
+		if err != nil {
+			return err
+		}
+		pinHash, err := auth.HashPassword("0000")
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO users (username, display_name, password_hash, pin_hash, public_key, is_admin, must_change_credentials) VALUES ($1,$2,$3,$4,$5,true,true)
+			ON CONFLICT (username) DO NOTHING`,
+			"admin", "Administrator", passwordHash, pinHash, "")
+		if err != nil {
+			return err
+		}
+	}
+	var inviteCount int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM invites`).Scan(&inviteCount); err != nil {
+		return err
+	}
+	if inviteCount == 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO invites (code, created_by, max_uses, uses, active) VALUES ($1, NULL, $2, 0, true) ON CONFLICT (code) DO NOTHING`, "DEFAULT-INVITE-0001", 1000)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
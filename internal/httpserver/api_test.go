@@ -0,0 +1,986 @@
+package httpserver_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/auth/passkeytest"
+	"messagingapi/internal/config"
+	"messagingapi/internal/httpserver"
+	"messagingapi/internal/httpserver/apitest"
+	"messagingapi/internal/httpserver/routes"
+	"messagingapi/internal/store"
+)
+
+func TestRegisterWithInvite(t *testing.T) {
+	s := apitest.New(t)
+	code := s.Invite(store.NewInvite{})
+	register := func(code, username string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/auth/register", "", map[string]any{
+			"inviteCode": code, "username": username, "displayName": username,
+			"password": apitest.Password, "pin": apitest.PIN, "publicKey": "pk",
+		})
+	}
+
+	body := register(code, "alice").Expect(http.StatusOK).JSON()
+	if body["token"] == nil || body["userId"] == nil {
+		t.Fatalf("register response missing token or userId: %v", body)
+	}
+	if got := register(code, "bob").Expect(http.StatusForbidden).Error(); got != "invite not usable" {
+		t.Errorf("reused single-use invite: error = %q", got)
+	}
+	if got := register("no-such-code", "bob").Expect(http.StatusForbidden).Error(); got != "invalid invite" {
+		t.Errorf("unknown invite: error = %q", got)
+	}
+	register(s.Invite(store.NewInvite{}), "alice").Expect(http.StatusBadRequest)
+
+	// A failed registration must not use up the invite.
+	code = s.Invite(store.NewInvite{})
+	register(code, "alice").Expect(http.StatusBadRequest)
+	register(code, "carol").Expect(http.StatusOK)
+}
+
+func TestRegisterWithChatInvite(t *testing.T) {
+	s := apitest.New(t)
+	owner := s.Register("owner")
+	chatID := s.CreateChat(owner)
+	code := s.Invite(store.NewInvite{CreatedBy: owner.ID, ChatID: &chatID})
+
+	body := s.Request(http.MethodPost, "/api/v1/auth/register", "", map[string]any{
+		"inviteCode": code, "username": "dave", "displayName": "Dave",
+		"password": apitest.Password, "pin": apitest.PIN, "publicKey": "pk",
+	}).Expect(http.StatusOK).JSON()
+	if body["chatId"] != chatID || body["chatPending"] != false {
+		t.Fatalf("register response = %v", body)
+	}
+	history := s.History(owner, chatID)
+	if len(history) != 1 || history[0].Event["type"] != "member_joined" || history[0].Event["userId"] != body["userId"] {
+		t.Fatalf("history = %+v, want one member_joined event", history)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := apitest.New(t)
+	alice := s.Register("alice")
+	login := func(username, password, pin string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": username, "password": password, "pin": pin})
+	}
+
+	body := login("alice", apitest.Password, apitest.PIN).Expect(http.StatusOK).JSON()
+	if body["userId"] != alice.ID {
+		t.Fatalf("login userId = %v, want %s", body["userId"], alice.ID)
+	}
+	token, _ := body["token"].(string)
+	me := s.Request(http.MethodGet, "/api/v1/users/me", token, nil).Expect(http.StatusOK).JSON()
+	if me["username"] != "alice" {
+		t.Errorf("GET /users/me username = %v", me["username"])
+	}
+
+	login("alice", "wrong", apitest.PIN).Expect(http.StatusUnauthorized)
+	login("alice", apitest.Password, "0000").Expect(http.StatusUnauthorized)
+	login("nobody", apitest.Password, apitest.PIN).Expect(http.StatusUnauthorized)
+
+	if err := s.Store.Users.Disable(context.Background(), alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	login("alice", apitest.Password, apitest.PIN).Expect(http.StatusForbidden)
+	// Tokens issued before the account was disabled stop working too.
+	s.Request(http.MethodGet, "/api/v1/users/me", token, nil).Expect(http.StatusForbidden)
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := apitest.New(t)
+	admin, alice := s.Admin("admin"), s.Register("alice")
+	login := func() string {
+		body := s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": apitest.Password, "pin": apitest.PIN}).Expect(http.StatusOK).JSON()
+		refresh, _ := body["refreshToken"].(string)
+		return refresh
+	}
+	refresh := func(token string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/auth/refresh", "", map[string]any{"refreshToken": token})
+	}
+
+	first, other := login(), login()
+	body := refresh(first).Expect(http.StatusOK).JSON()
+	if body["userId"] != alice.ID || body["refreshToken"] == first {
+		t.Fatalf("refresh = %v", body)
+	}
+	token, _ := body["token"].(string)
+	s.Request(http.MethodGet, "/api/v1/users/me", token, nil).Expect(http.StatusOK)
+	second, _ := body["refreshToken"].(string)
+	third, _ := refresh(second).Expect(http.StatusOK).JSON()["refreshToken"].(string)
+
+	// A spent token presented again revokes the rest of its session, but
+	// not other sessions.
+	if got := refresh(first).Expect(http.StatusUnauthorized).Error(); got != "invalid refresh token" {
+		t.Errorf("reused token error = %q", got)
+	}
+	refresh(third).Expect(http.StatusUnauthorized)
+	other, _ = refresh(other).Expect(http.StatusOK).JSON()["refreshToken"].(string)
+	refresh("not-a-token").Expect(http.StatusUnauthorized)
+
+	// Refreshing checks the account like a login does, without spending
+	// the token.
+	ctx := context.Background()
+	if err := s.Store.Users.Suspend(ctx, alice.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	refresh(other).Expect(http.StatusForbidden)
+	if err := s.Store.Users.Enable(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	refresh(other).Expect(http.StatusOK)
+
+	// Resetting the credentials ends every session.
+	other = login()
+	s.Request(http.MethodPost, "/api/v1/admin/users/"+alice.ID+"/reset-credentials", admin.Token, map[string]any{"password": "temporary", "pin": "1357"}).Expect(http.StatusOK)
+	refresh(other).Expect(http.StatusUnauthorized)
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+	s := apitest.New(t)
+	for _, path := range []string{"/api/v1/users/me", "/api/v1/messages/sync", "/api/v1/invites/"} {
+		s.Request(http.MethodGet, path, "", nil).Expect(http.StatusUnauthorized)
+		s.Request(http.MethodGet, path, "not-a-token", nil).Expect(http.StatusUnauthorized)
+	}
+}
+
+func TestChatMessages(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob, carol := s.Register("alice"), s.Register("bob"), s.Register("carol")
+	chatID := s.CreateChat(alice, bob)
+
+	first := s.SendMessage(alice, chatID, "c1")
+	second := s.SendMessage(bob, chatID, "c2")
+	history := s.History(bob, chatID)
+	if len(history) != 2 || history[0].ID != second || history[1].ID != first {
+		t.Fatalf("history = %+v, want [%s %s]", history, second, first)
+	}
+	if history[1].SenderID != alice.ID || history[1].Ciphertext != "c1" {
+		t.Errorf("first message = %+v", history[1])
+	}
+
+	// Edits and deletes are limited to the sender.
+	s.Request(http.MethodPatch, "/api/v1/messages/"+first, bob.Token, map[string]any{"ciphertext": "x"}).Expect(http.StatusForbidden)
+	s.Request(http.MethodPatch, "/api/v1/messages/"+first, alice.Token, map[string]any{"ciphertext": "c1'"}).Expect(http.StatusOK)
+	s.Request(http.MethodDelete, "/api/v1/messages/"+second, alice.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodDelete, "/api/v1/messages/"+second, bob.Token, nil).Expect(http.StatusOK)
+	history = s.History(alice, chatID)
+	if len(history) != 1 || history[0].Ciphertext != "c1'" {
+		t.Fatalf("history after edit and delete = %+v", history)
+	}
+
+	// Sync returns the caller's chats in arrival order.
+	var sync struct {
+		Messages []apitest.Message `json:"messages"`
+	}
+	s.Request(http.MethodGet, "/api/v1/messages/sync", bob.Token, nil).Expect(http.StatusOK).Decode(&sync)
+	if len(sync.Messages) != 1 || sync.Messages[0].ID != first {
+		t.Errorf("sync = %+v", sync.Messages)
+	}
+	// Each synced message carries the cursor to resume after it.
+	var resumed struct {
+		Messages []struct {
+			ID     string `json:"id"`
+			Cursor string `json:"cursor"`
+		} `json:"messages"`
+	}
+	s.Request(http.MethodGet, "/api/v1/messages/sync", bob.Token, nil).Expect(http.StatusOK).Decode(&resumed)
+	third := s.SendMessage(alice, chatID, "c3")
+	s.Request(http.MethodGet, "/api/v1/messages/sync?after="+url.QueryEscape(resumed.Messages[0].Cursor), bob.Token, nil).Expect(http.StatusOK).Decode(&resumed)
+	if len(resumed.Messages) != 1 || resumed.Messages[0].ID != third {
+		t.Errorf("sync after cursor = %+v", resumed.Messages)
+	}
+	s.Request(http.MethodGet, "/api/v1/messages/sync?after="+url.QueryEscape(first), bob.Token, nil).Expect(http.StatusBadRequest)
+
+	// Envelopes may only address members' devices, and the error does not
+	// echo the device back.
+	var device struct {
+		ID string `json:"id"`
+	}
+	s.Request(http.MethodPost, "/api/v1/users/me/devices", carol.Token, map[string]any{"name": "phone"}).Expect(http.StatusCreated).Decode(&device)
+	if got := s.Multipart(http.MethodPost, "/api/v1/messages/", alice.Token, map[string]string{
+		"chatId": chatID, "envelopes": `[{"deviceId":"` + device.ID + `","ciphertext":"x"}]`,
+	}).Expect(http.StatusBadRequest).Error(); got != "invalid envelopes" {
+		t.Errorf("envelope to a non-member's device: error = %q", got)
+	}
+
+	// Non-members can neither read nor write.
+	s.Request(http.MethodGet, "/api/v1/chats/"+chatID+"/messages", carol.Token, nil).Expect(http.StatusForbidden)
+	s.Multipart(http.MethodPost, "/api/v1/messages/", carol.Token, map[string]string{"chatId": chatID, "ciphertext": "c"}).Expect(http.StatusForbidden)
+	s.Request(http.MethodDelete, "/api/v1/chats/"+chatID+"/clear", carol.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodGet, "/api/v1/messages/sync", carol.Token, nil).Expect(http.StatusOK).Decode(&sync)
+	if len(sync.Messages) != 0 {
+		t.Errorf("non-member sync = %+v", sync.Messages)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob, carol := s.Register("alice"), s.Register("bob"), s.Register("carol")
+	chatID := s.CreateChat(alice, bob)
+	data := []byte("\x89PNG fake image bytes")
+	s.SendMessage(alice, chatID, "c", apitest.File{Name: "photo.png", ContentType: "image/png", Data: data})
+
+	history := s.History(bob, chatID)
+	if len(history) != 1 || len(history[0].Attachments) != 1 {
+		t.Fatalf("history = %+v, want one message with one attachment", history)
+	}
+	att := history[0].Attachments[0]
+	if att.ContentType != "image/png" || att.SizeBytes != int64(len(data)) {
+		t.Errorf("attachment = %+v", att)
+	}
+
+	resp := s.Request(http.MethodGet, "/api/v1/media/attachments/"+att.ID, bob.Token, nil).Expect(http.StatusOK)
+	if !bytes.Equal(resp.Body, data) {
+		t.Errorf("downloaded %q, want %q", resp.Body, data)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	s.Request(http.MethodGet, "/api/v1/media/attachments/"+att.ID, carol.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodGet, "/api/v1/media/attachments/"+att.ID, "", nil).Expect(http.StatusUnauthorized)
+	s.Request(http.MethodGet, "/api/v1/media/attachments/00000000-0000-0000-0000-000000000000", bob.Token, nil).Expect(http.StatusNotFound)
+
+	// Attachments commit with their message, so Sync sees them at once.
+	var sync struct {
+		Messages []apitest.Message `json:"messages"`
+	}
+	s.Request(http.MethodGet, "/api/v1/messages/sync", bob.Token, nil).Expect(http.StatusOK).Decode(&sync)
+	if len(sync.Messages) != 1 || len(sync.Messages[0].Attachments) != 1 {
+		t.Fatalf("sync = %+v, want the message with its attachment", sync.Messages)
+	}
+
+	// A file that cannot be written fails the send, and no message is left.
+	other := s.CreateChat(alice, carol)
+	if err := os.MkdirAll(filepath.Join(s.Config.DataDir, "uploads"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Config.DataDir, "uploads", other), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Multipart(http.MethodPost, "/api/v1/messages/", alice.Token, map[string]string{"chatId": other, "ciphertext": "c"},
+		apitest.File{Field: "files", Name: "photo.png", ContentType: "image/png", Data: data}).Expect(http.StatusInternalServerError).Error(); got != "save attachment" {
+		t.Errorf("send with an unwritable attachment: error = %q", got)
+	}
+	if history := s.History(carol, other); len(history) != 0 {
+		t.Errorf("history after a failed send = %+v", history)
+	}
+}
+
+func TestChatManagement(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob, carol := s.Register("alice"), s.Register("bob"), s.Register("carol")
+	chatID := s.CreateChat(alice, bob)
+
+	// Only owners and admins hand out chat invites.
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", bob.Token, map[string]any{}).Expect(http.StatusForbidden)
+	var inv struct {
+		Code string `json:"code"`
+	}
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", alice.Token, map[string]any{"requiresApproval": true}).Expect(http.StatusOK).Decode(&inv)
+
+	body := s.Request(http.MethodPost, "/api/v1/chats/join", carol.Token, map[string]any{"code": inv.Code}).Expect(http.StatusOK).JSON()
+	if body["pending"] != true {
+		t.Fatalf("join = %v, want pending", body)
+	}
+	s.Request(http.MethodGet, "/api/v1/chats/"+chatID+"/messages", carol.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/join-requests/"+carol.ID+"/approve", bob.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/join-requests/"+carol.ID+"/approve", alice.Token, nil).Expect(http.StatusOK)
+	s.Request(http.MethodGet, "/api/v1/chats/"+chatID+"/messages", carol.Token, nil).Expect(http.StatusOK)
+	s.Request(http.MethodPost, "/api/v1/chats/join", bob.Token, map[string]any{"code": inv.Code}).Expect(http.StatusConflict)
+	// The single-use invite is spent.
+	dave := s.Register("dave")
+	s.Request(http.MethodPost, "/api/v1/chats/join", dave.Token, map[string]any{"code": inv.Code}).Expect(http.StatusForbidden)
+}
+
+func TestLoginRehashesWeakCredentials(t *testing.T) {
+	s := apitest.New(t)
+	alice := s.Register("alice")
+	before, _ := s.Store.Users.Get(context.Background(), alice.ID)
+
+	// Raise the cost above what apitest registered with, then restore it.
+	if err := auth.SetArgon2Params(auth.Argon2Params{Time: 2, Memory: 64, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auth.SetArgon2Params(auth.Argon2Params{Time: 1, Memory: 64, Threads: 1}) })
+	if !auth.NeedsRehash(before.PasswordHash) {
+		t.Fatal("registered hash does not need rehash under stronger params")
+	}
+	s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": "wrong", "pin": apitest.PIN}).Expect(http.StatusUnauthorized)
+	if u, _ := s.Store.Users.Get(context.Background(), alice.ID); u.PasswordHash != before.PasswordHash {
+		t.Fatal("failed login rehashed the credentials")
+	}
+
+	s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": apitest.Password, "pin": apitest.PIN}).Expect(http.StatusOK)
+	after, _ := s.Store.Users.Get(context.Background(), alice.ID)
+	if after.PasswordHash == before.PasswordHash || after.PINHash == before.PINHash || auth.NeedsRehash(after.PasswordHash) || auth.NeedsRehash(after.PINHash) {
+		t.Fatalf("credentials not rehashed: %s / %s", after.PasswordHash, after.PINHash)
+	}
+	for _, pair := range [][2]string{{after.PasswordHash, apitest.Password}, {after.PINHash, apitest.PIN}} {
+		if ok, err := auth.VerifyPassword(pair[0], pair[1]); !ok || err != nil {
+			t.Fatalf("rehashed credential does not verify: %v", err)
+		}
+	}
+	s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": apitest.Password, "pin": apitest.PIN}).Expect(http.StatusOK)
+}
+
+func TestPINAttemptsAreShared(t *testing.T) {
+	s := apitest.New(t, func(c *config.Config) { c.PINMaxAttempts, c.VaultMaxAttempts = 3, 4 })
+	alice := s.Register("alice")
+	ctx := context.Background()
+	rotateKey := func(pin string) *apitest.Response {
+		return s.Request(http.MethodPut, "/api/v1/users/me/key", alice.Token, map[string]any{"pin": pin, "publicKey": "pk2"})
+	}
+	restore := func(pin string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/users/me/vault/restore", alice.Token, map[string]any{"pin": pin})
+	}
+	s.Request(http.MethodPost, "/api/v1/users/me/vault", alice.Token, map[string]any{"pin": apitest.PIN, "ciphertext": "backup"}).Expect(http.StatusCreated)
+
+	// Wrong PINs on different endpoints count against the same limit.
+	if body := rotateKey("0000").Expect(http.StatusUnauthorized).JSON(); body["attemptsRemaining"] != 2.0 {
+		t.Fatalf("first wrong PIN: %v", body)
+	}
+	if body := restore("0000").Expect(http.StatusUnauthorized).JSON(); body["attemptsRemaining"] != 1.0 {
+		t.Fatalf("second wrong PIN: %v", body)
+	}
+	if body := s.Request(http.MethodGet, "/api/v1/users/me/vault", alice.Token, nil).Expect(http.StatusOK).JSON(); body["attemptsRemaining"] != 2.0 {
+		t.Fatalf("vault status after two wrong PINs: %v", body)
+	}
+	if body := rotateKey("0000").Expect(http.StatusUnauthorized).JSON(); body["lockedUntil"] == nil {
+		t.Fatalf("third wrong PIN did not lock: %v", body)
+	}
+	// Locked out, even the right PIN is refused.
+	rotateKey(apitest.PIN).Expect(http.StatusTooManyRequests)
+	restore(apitest.PIN).Expect(http.StatusTooManyRequests)
+
+	// Let the lockout lapse; the next failure reaches VaultMaxAttempts.
+	if err := s.Store.Users.SetCredentialFailures(ctx, alice.ID, 3, sql.NullTime{}); err != nil {
+		t.Fatal(err)
+	}
+	if body := rotateKey("0000").Expect(http.StatusUnauthorized).JSON(); body["keyBackupDeleted"] != true {
+		t.Fatalf("fourth wrong PIN kept the backup: %v", body)
+	}
+	restore(apitest.PIN).Expect(http.StatusNotFound)
+
+	if body := rotateKey(apitest.PIN).Expect(http.StatusOK).JSON(); body["changed"] != true {
+		t.Fatalf("rotate with the right PIN: %v", body)
+	}
+	if u, _ := s.Store.Users.Get(ctx, alice.ID); u.FailedCredentialAttempts != 0 || u.CredentialsLockedUntil.Valid {
+		t.Fatalf("right PIN did not reset the counter: %d %v", u.FailedCredentialAttempts, u.CredentialsLockedUntil)
+	}
+}
+
+func TestLoginAndReauthShareThePINCounter(t *testing.T) {
+	s := apitest.New(t, func(c *config.Config) { c.PINMaxAttempts = 2 })
+	alice := s.Register("alice")
+	ctx := context.Background()
+	login := func(password, pin string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": password, "pin": pin})
+	}
+	failures := func() int {
+		u, err := s.Store.Users.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.FailedCredentialAttempts
+	}
+
+	// A wrong password is not counted, a wrong PIN is, and both answer alike.
+	login("wrong", apitest.PIN).Expect(http.StatusUnauthorized)
+	if n := failures(); n != 0 {
+		t.Fatalf("wrong password counted: %d", n)
+	}
+	for range 2 {
+		if body := login(apitest.Password, "0000").Expect(http.StatusUnauthorized).JSON(); body["error"] != "invalid credentials" || len(body) != 1 {
+			t.Fatalf("wrong PIN at login: %v", body)
+		}
+	}
+	// Locked out: even the right credentials fail, without saying why.
+	if got := login(apitest.Password, apitest.PIN).Expect(http.StatusUnauthorized).Error(); got != "invalid credentials" {
+		t.Fatalf("locked login: error = %q", got)
+	}
+	s.Request(http.MethodDelete, "/api/v1/users/me", alice.Token, map[string]any{"password": apitest.Password, "pin": apitest.PIN}).Expect(http.StatusTooManyRequests)
+
+	// After the lockout a wrong password on a re-authentication counts too.
+	if err := s.Store.Users.SetCredentialFailures(ctx, alice.ID, 2, sql.NullTime{}); err != nil {
+		t.Fatal(err)
+	}
+	body := s.Request(http.MethodPut, "/api/v1/users/me/password", alice.Token, map[string]any{
+		"oldPassword": "wrong", "oldPin": apitest.PIN, "newPassword": "new password", "newPin": "1357",
+	}).Expect(http.StatusUnauthorized).JSON()
+	if body["error"] != "invalid credentials" || body["attemptsRemaining"] != 1.0 || failures() != 3 {
+		t.Fatalf("wrong password on password change: %v, %d failures", body, failures())
+	}
+	login(apitest.Password, apitest.PIN).Expect(http.StatusOK)
+	if n := failures(); n != 0 {
+		t.Fatalf("successful login left %d failures", n)
+	}
+}
+
+func TestLoginTOTPChallenge(t *testing.T) {
+	s := apitest.New(t, func(c *config.Config) { c.TOTPMaxAttempts = 4 })
+	alice := s.Register("alice")
+	var enroll struct{ Secret string }
+	s.Request(http.MethodPost, "/api/v1/users/me/2fa/totp", alice.Token, nil).Expect(http.StatusOK).Decode(&enroll)
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enroll.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed struct{ RecoveryCodes []string }
+	s.Request(http.MethodPost, "/api/v1/users/me/2fa/totp/confirm", alice.Token, map[string]any{
+		"code": auth.TOTPCode(key, time.Now().Unix()/30),
+	}).Expect(http.StatusOK).Decode(&confirmed)
+
+	challenge := func() string {
+		body := s.Request(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"username": "alice", "password": apitest.Password, "pin": apitest.PIN}).Expect(http.StatusOK).JSON()
+		token, _ := body["challengeToken"].(string)
+		if token == "" {
+			t.Fatalf("login did not ask for a second factor: %v", body)
+		}
+		return token
+	}
+	exchange := func(challenge, code string) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/auth/login/totp", "", map[string]any{"challengeToken": challenge, "code": code})
+	}
+
+	// A challenge is spent by a successful exchange.
+	first := challenge()
+	exchange(first, confirmed.RecoveryCodes[0]).Expect(http.StatusOK)
+	if got := exchange(first, confirmed.RecoveryCodes[1]).Expect(http.StatusUnauthorized).Error(); got != "invalid challenge" {
+		t.Fatalf("replayed challenge: error = %q", got)
+	}
+
+	// And by challengeMaxAttempts wrong codes.
+	second := challenge()
+	for range 3 {
+		if got := exchange(second, "------").Expect(http.StatusUnauthorized).Error(); got != "invalid code" {
+			t.Fatalf("wrong code: error = %q", got)
+		}
+	}
+	if got := exchange(second, confirmed.RecoveryCodes[1]).Expect(http.StatusUnauthorized).Error(); got != "invalid challenge" {
+		t.Fatalf("challenge after three wrong codes: error = %q", got)
+	}
+
+	// Wrong codes add up across challenges and lock the user out.
+	third := challenge()
+	if body := exchange(third, "------").Expect(http.StatusUnauthorized).JSON(); body["lockedUntil"] == nil {
+		t.Fatalf("fourth wrong code did not lock: %v", body)
+	}
+	exchange(third, confirmed.RecoveryCodes[1]).Expect(http.StatusTooManyRequests)
+	if tt, _ := s.Store.TOTP.Get(context.Background(), alice.ID); tt.FailedAttempts != 4 || !tt.LockedUntil.Valid {
+		t.Fatalf("totp failures = %d, locked until %v", tt.FailedAttempts, tt.LockedUntil)
+	}
+}
+
+func TestPasskeyManagementRequiresCredentials(t *testing.T) {
+	s := apitest.New(t, func(c *config.Config) {
+		c.WebAuthnRPID, c.WebAuthnRPName, c.WebAuthnRPOrigins = "chat.example.com", "Messaging API", []string{"https://chat.example.com"}
+	})
+	alice := s.Register("alice")
+	creds := map[string]any{"password": apitest.Password, "pin": apitest.PIN}
+	wrongPIN := map[string]any{"password": apitest.Password, "pin": "0000"}
+
+	s.Request(http.MethodPost, "/api/v1/users/me/passkeys/register/begin", alice.Token, nil).Expect(http.StatusBadRequest)
+	if got := s.Request(http.MethodPost, "/api/v1/users/me/passkeys/register/begin", alice.Token, wrongPIN).Expect(http.StatusUnauthorized).Error(); got != "invalid credentials" {
+		t.Fatalf("begin with wrong pin: error = %q", got)
+	}
+
+	var begin struct {
+		SessionID string
+		Options   protocol.CredentialCreation
+	}
+	s.Request(http.MethodPost, "/api/v1/users/me/passkeys/register/begin", alice.Token, creds).Expect(http.StatusOK).Decode(&begin)
+	authenticator, err := passkeytest.New("https://chat.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := authenticator.Create(&begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct{ ID string }
+	s.Request(http.MethodPost, "/api/v1/users/me/passkeys/register/finish", alice.Token, map[string]any{
+		"sessionId": begin.SessionID, "name": "laptop", "credential": json.RawMessage(credential),
+	}).Expect(http.StatusOK).Decode(&created)
+
+	path := "/api/v1/users/me/passkeys/" + created.ID
+	s.Request(http.MethodDelete, path, alice.Token, nil).Expect(http.StatusBadRequest)
+	s.Request(http.MethodDelete, path, alice.Token, wrongPIN).Expect(http.StatusUnauthorized)
+	if u, _ := s.Store.Users.Get(context.Background(), alice.ID); u.FailedCredentialAttempts != 1 {
+		t.Fatalf("failed attempts = %d, want 1", u.FailedCredentialAttempts)
+	}
+	s.Request(http.MethodDelete, path, alice.Token, creds).Expect(http.StatusOK)
+	var list struct{ Passkeys []any }
+	s.Request(http.MethodGet, "/api/v1/users/me/passkeys", alice.Token, nil).Expect(http.StatusOK).Decode(&list)
+	if len(list.Passkeys) != 0 {
+		t.Fatalf("passkeys after delete = %v", list.Passkeys)
+	}
+}
+
+func TestServiceAccountCertificates(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "clients.pem")
+	ca := newTestCA(t, "clients")
+	writeFile(t, caFile, ca.PEM)
+	accounts := map[string]string{}
+	s := apitest.New(t, func(c *config.Config) { c.TLSClientCAPath, c.ServiceAccounts = caFile, accounts })
+	bot := s.Register("bot")
+	accounts["cn:bot"] = bot.ID
+	srv := startTLS(t, s.Router, s.Config)
+
+	if resp, err := srv.Get(t, "/api/v1/users/me", nil, ca.KeyPair(t, "bot")); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("mapped certificate: %v, %v", resp, err)
+	}
+	if resp, err := srv.Get(t, "/api/v1/users/me", nil, ca.KeyPair(t, "stranger")); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unmapped certificate: %v, %v", resp, err)
+	}
+	if resp, err := srv.Get(t, "/api/v1/users/me", nil); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no certificate: %v, %v", resp, err)
+	}
+
+	// Mappings name accounts by ID; a username, which a later account could
+	// take over, authenticates no one.
+	accounts["cn:legacy"] = "bot"
+	if resp, err := srv.Get(t, "/api/v1/users/me", nil, ca.KeyPair(t, "legacy")); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("certificate mapped to a username: %v, %v", resp, err)
+	}
+}
+
+func TestSealedSender(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob := s.Register("alice"), s.Register("bob")
+	chatID := s.CreateChat(alice, bob)
+	const accessKey = "bob-access-key-0123456789"
+	s.Request(http.MethodPut, "/api/v1/users/me/sealed-sender", bob.Token, map[string]any{"accessKey": accessKey}).Expect(http.StatusOK)
+	var issued struct {
+		Tokens []string `json:"tokens"`
+	}
+	s.Request(http.MethodPost, "/api/v1/users/me/delivery-tokens", alice.Token, map[string]any{"count": 2}).Expect(http.StatusOK).Decode(&issued)
+
+	// The access key travels in the header the README documents, with no
+	// bearer token.
+	send := func(key, token string) *apitest.Response {
+		b, _ := json.Marshal(map[string]any{"chatId": chatID, "recipientId": bob.ID, "ciphertext": "sealed", "deliveryToken": token})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sealed/messages", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Unidentified-Access-Key", key)
+		}
+		return s.Do(req)
+	}
+	send("", issued.Tokens[0]).Expect(http.StatusUnauthorized)
+	send("wrong-access-key-0123456789", issued.Tokens[0]).Expect(http.StatusUnauthorized)
+	send(accessKey, issued.Tokens[0]).Expect(http.StatusOK)
+	send(accessKey, issued.Tokens[0]).Expect(http.StatusTooManyRequests)
+
+	history := s.History(bob, chatID)
+	if len(history) != 1 || history[0].Ciphertext != "sealed" || history[0].SenderID != "" {
+		t.Fatalf("history = %+v, want one sealed message without sender", history)
+	}
+}
+
+func TestChatInviteRoles(t *testing.T) {
+	s := apitest.New(t, func(cfg *config.Config) { cfg.UserInviteQuota, cfg.UserInviteTTL = 5, time.Hour })
+	owner, admin := s.Register("owner"), s.Register("admin")
+	chatID := s.CreateChat(owner)
+	var inv struct {
+		Code string `json:"code"`
+	}
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", owner.Token, map[string]any{"role": "admin"}).Expect(http.StatusOK).Decode(&inv)
+	s.Request(http.MethodPost, "/api/v1/chats/join", admin.Token, map[string]any{"code": inv.Code}).Expect(http.StatusOK)
+
+	// Chat admins may invite members, but only the owner hands out admin.
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", admin.Token, map[string]any{"role": "member"}).Expect(http.StatusOK)
+	if got := s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", admin.Token, map[string]any{"role": "admin"}).Expect(http.StatusForbidden).Error(); got != "only the chat owner can invite admins" {
+		t.Errorf("admin inviting admins: error = %q", got)
+	}
+	s.Request(http.MethodPost, "/api/v1/invites/", admin.Token, map[string]any{"chatId": chatID, "role": "admin"}).Expect(http.StatusForbidden)
+	s.Request(http.MethodPost, "/api/v1/invites/", owner.Token, map[string]any{"chatId": chatID, "role": "admin"}).Expect(http.StatusOK)
+}
+
+func TestJoinRequestConsumesInviteOnApproval(t *testing.T) {
+	s := apitest.New(t)
+	owner, carol, dave, erin := s.Register("owner"), s.Register("carol"), s.Register("dave"), s.Register("erin")
+	chatID := s.CreateChat(owner)
+	var inv struct {
+		Code string `json:"code"`
+	}
+	s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/invites", owner.Token, map[string]any{"requiresApproval": true}).Expect(http.StatusOK).Decode(&inv)
+	join := func(u *apitest.User) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/chats/join", u.Token, map[string]any{"code": inv.Code})
+	}
+	approve := func(u *apitest.User) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/chats/"+chatID+"/join-requests/"+u.ID+"/approve", owner.Token, nil)
+	}
+
+	// Withdrawn and rejected requests leave the single use available.
+	join(carol).Expect(http.StatusOK)
+	s.Request(http.MethodDelete, "/api/v1/chats/"+chatID+"/join-requests/"+carol.ID, carol.Token, nil).Expect(http.StatusOK)
+	join(dave).Expect(http.StatusOK)
+	s.Request(http.MethodDelete, "/api/v1/chats/"+chatID+"/join-requests/"+dave.ID, owner.Token, nil).Expect(http.StatusOK)
+
+	join(carol).Expect(http.StatusOK)
+	join(erin).Expect(http.StatusOK)
+	approve(carol).Expect(http.StatusOK)
+	if got := approve(erin).Expect(http.StatusConflict).Error(); got != "invite not usable" {
+		t.Errorf("approving past max uses: error = %q", got)
+	}
+	s.Request(http.MethodGet, "/api/v1/chats/"+chatID+"/messages", erin.Token, nil).Expect(http.StatusForbidden)
+	join(dave).Expect(http.StatusForbidden)
+}
+
+func TestAdminOnlyRoutes(t *testing.T) {
+	s := apitest.New(t)
+	admin, alice := s.Admin("root"), s.Register("alice")
+
+	s.Request(http.MethodGet, "/api/v1/admin/users", alice.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodGet, "/api/v1/admin/users", admin.Token, nil).Expect(http.StatusOK)
+
+	var inv struct {
+		ID string `json:"id"`
+	}
+	s.Request(http.MethodPost, "/api/v1/invites/", admin.Token, map[string]any{"maxUses": 2}).Expect(http.StatusOK).Decode(&inv)
+	s.Request(http.MethodPatch, "/api/v1/invites/"+inv.ID, alice.Token, map[string]any{"active": false}).Expect(http.StatusForbidden)
+	s.Request(http.MethodDelete, "/api/v1/invites/"+inv.ID, alice.Token, nil).Expect(http.StatusForbidden)
+	s.Request(http.MethodPatch, "/api/v1/invites/"+inv.ID, admin.Token, map[string]any{"active": false}).Expect(http.StatusOK)
+
+	// Without a quota, regular users cannot create invites.
+	s.Request(http.MethodPost, "/api/v1/invites/", alice.Token, map[string]any{}).Expect(http.StatusForbidden)
+}
+
+func TestAdminUserSearch(t *testing.T) {
+	s := apitest.New(t)
+	admin := s.Admin("root")
+	s.Register("ann_lee")
+	s.Register("annxlee")
+	s.Register("50%off")
+
+	search := func(q string) []string {
+		var body struct {
+			Users []struct {
+				Username string `json:"username"`
+			} `json:"users"`
+		}
+		s.Request(http.MethodGet, "/api/v1/admin/users?q="+url.QueryEscape(q), admin.Token, nil).Expect(http.StatusOK).Decode(&body)
+		var names []string
+		for _, u := range body.Users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+	// Wildcards in the query match only themselves.
+	if got := search("n_l"); len(got) != 1 || got[0] != "ann_lee" {
+		t.Errorf(`search "n_l" = %v, want [ann_lee]`, got)
+	}
+	if got := search("0%o"); len(got) != 1 || got[0] != "50%off" {
+		t.Errorf(`search "0%%o" = %v, want [50%%off]`, got)
+	}
+	if got := search(`\`); len(got) != 0 {
+		t.Errorf(`search "\" = %v, want none`, got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob := s.Register("alice"), s.Register(`bób "b"`)
+	chatID := s.CreateChat(alice, bob)
+	var device struct {
+		ID string `json:"id"`
+	}
+	s.Request(http.MethodPost, "/api/v1/users/me/devices", bob.Token, map[string]any{"name": "phone"}).Expect(http.StatusCreated).Decode(&device)
+	plain := s.SendMessage(alice, chatID, "c1", apitest.File{Name: "a.bin", ContentType: "application/octet-stream", Data: []byte("blob")})
+	fanout := s.Multipart(http.MethodPost, "/api/v1/messages/", alice.Token, map[string]string{
+		"chatId": chatID, "nonce": "n", "envelopes": `[{"deviceId":"` + device.ID + `","ciphertext":"for-phone"}]`,
+	}).Expect(http.StatusOK).JSON()["messageId"]
+
+	resp := s.Request(http.MethodGet, "/api/v1/users/me/export", bob.Token, nil).Expect(http.StatusOK)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != `export-bób "b".zip` {
+		t.Fatalf("Content-Disposition %q: params %v, err %v", resp.Header.Get("Content-Disposition"), params, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(resp.Body), int64(len(resp.Body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	var msgs []struct {
+		ID          string `json:"id"`
+		Ciphertext  string `json:"ciphertext"`
+		Fanout      bool   `json:"fanout"`
+		Sealed      bool   `json:"sealed"`
+		Attachments []struct {
+			File string `json:"file"`
+		} `json:"attachments"`
+		Envelopes []struct {
+			DeviceID   string `json:"deviceId"`
+			Ciphertext string `json:"ciphertext"`
+		} `json:"envelopes"`
+	}
+	if err := json.Unmarshal(files["messages/"+chatID+".json"], &msgs); err != nil {
+		t.Fatalf("messages JSON: %v\n%s", err, files["messages/"+chatID+".json"])
+	}
+	if len(msgs) != 2 || msgs[0].ID != plain || msgs[0].Ciphertext != "c1" || msgs[0].Fanout || len(msgs[0].Attachments) != 1 {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	if string(files[msgs[0].Attachments[0].File]) != "blob" {
+		t.Errorf("attachment %s = %q", msgs[0].Attachments[0].File, files[msgs[0].Attachments[0].File])
+	}
+	if m := msgs[1]; m.ID != fanout || !m.Fanout || m.Sealed || len(m.Envelopes) != 1 || m.Envelopes[0].DeviceID != device.ID || m.Envelopes[0].Ciphertext != "for-phone" {
+		t.Fatalf("fan-out message = %+v", m)
+	}
+
+	// A chat without messages still gets a valid, empty array.
+	empty := s.CreateChat(alice, bob)
+	resp = s.Request(http.MethodGet, "/api/v1/users/me/export", bob.Token, nil).Expect(http.StatusOK)
+	zr, _ = zip.NewReader(bytes.NewReader(resp.Body), int64(len(resp.Body)))
+	for _, f := range zr.File {
+		if f.Name == "messages/"+empty+".json" {
+			r, _ := f.Open()
+			b, _ := io.ReadAll(r)
+			if string(b) != "[]\n" {
+				t.Errorf("empty chat export = %q", b)
+			}
+		}
+	}
+}
+
+func TestDownloadsOutliveWriteTimeout(t *testing.T) {
+	s := apitest.New(t)
+	alice, bob := s.Register("alice"), s.Register("bob")
+	chatID := s.CreateChat(alice, bob)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	s.SendMessage(alice, chatID, "c", apitest.File{Name: "big.bin", ContentType: "application/octet-stream", Data: data})
+	att := s.History(bob, chatID)[0].Attachments[0]
+
+	// Served over a real connection, without the buffering validator, with
+	// a write deadline that has passed before any handler runs.
+	passkeys, err := routes.NewPasskeys(s.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(httpserver.NewRouter(s.Store, s.Config, s.Keys, passkeys))
+	srv.Config.WriteTimeout = time.Nanosecond
+	srv.Start()
+	defer srv.Close()
+	get := func(path string) ([]byte, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+bob.Token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+
+	if _, err := get("/api/v1/users/me"); err == nil {
+		t.Fatal("a JSON response was written past WriteTimeout")
+	}
+	if body, err := get("/api/v1/media/attachments/" + att.ID); err != nil || !bytes.Equal(body, data) {
+		t.Errorf("download: %d bytes, err %v; want %d bytes", len(body), err, len(data))
+	}
+	body, err := get("/api/v1/users/me/export")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(body), int64(len(body))); err != nil {
+		t.Errorf("export is not a complete ZIP: %v", err)
+	}
+}
+
+// An id in the path that is not a UUID is a 404 on both stores, not a
+// database error.
+func TestMalformedIDs(t *testing.T) {
+	s := apitest.New(t)
+	admin, alice := s.Admin("admin"), s.Register("alice")
+	s.Request(http.MethodPost, "/api/v1/admin/users/not-a-uuid/disable", admin.Token, nil).Expect(http.StatusNotFound)
+	s.Request(http.MethodGet, "/api/v1/admin/users/not-a-uuid", admin.Token, nil).Expect(http.StatusNotFound)
+	s.Request(http.MethodGet, "/api/v1/media/attachments/not-a-uuid", alice.Token, nil).Expect(http.StatusNotFound)
+}
+
+func TestUserInviteQuota(t *testing.T) {
+	s := apitest.New(t, func(c *config.Config) { c.UserInviteQuota = 3; c.UserInviteTTL = 24 * time.Hour })
+	admin, alice := s.Admin("root"), s.Register("alice")
+	var inv struct {
+		ID        string    `json:"id"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	create := func(token string, body map[string]any) *apitest.Response {
+		return s.Request(http.MethodPost, "/api/v1/invites/", token, body)
+	}
+
+	// Invites without an expiry, or expiring past the TTL, are clamped to it.
+	latest := time.Now().Add(24 * time.Hour)
+	create(alice.Token, map[string]any{"maxUses": 2}).Expect(http.StatusOK).Decode(&inv)
+	if inv.ExpiresAt.IsZero() || inv.ExpiresAt.After(latest.Add(time.Minute)) {
+		t.Errorf("invite without expiry expires at %v, want at most %v", inv.ExpiresAt, latest)
+	}
+	// 2 of 3 slots are held, so 2 more go over by one.
+	body := create(alice.Token, map[string]any{"maxUses": 2}).Expect(http.StatusForbidden).JSON()
+	if body["error"] != "invite quota exceeded" || body["remaining"] != float64(1) {
+		t.Errorf("over quota = %v, want remaining 1", body)
+	}
+	create(alice.Token, map[string]any{"maxUses": 1, "expiresAt": time.Now().Add(30 * 24 * time.Hour)}).Expect(http.StatusOK).Decode(&inv)
+	if inv.ExpiresAt.After(latest.Add(time.Minute)) {
+		t.Errorf("invite asked for 30 days expires at %v, want at most %v", inv.ExpiresAt, latest)
+	}
+	soon := time.Now().Add(time.Hour).Truncate(time.Second)
+	if body := create(alice.Token, map[string]any{"maxUses": 1, "expiresAt": soon}).Expect(http.StatusForbidden).JSON(); body["remaining"] != float64(0) {
+		t.Errorf("exhausted quota = %v, want remaining 0", body)
+	}
+	var list struct {
+		Quota struct{ Allowance, Used, Remaining int } `json:"quota"`
+	}
+	s.Request(http.MethodGet, "/api/v1/invites/", alice.Token, nil).Expect(http.StatusOK).Decode(&list)
+	if list.Quota.Allowance != 3 || list.Quota.Used != 3 || list.Quota.Remaining != 0 {
+		t.Errorf("quota = %+v", list.Quota)
+	}
+
+	// A per-user override raises the allowance; a shorter expiry is kept.
+	s.Request(http.MethodPut, "/api/v1/admin/users/"+alice.ID+"/invite-quota", admin.Token, map[string]any{"quota": 4}).Expect(http.StatusOK)
+	create(alice.Token, map[string]any{"maxUses": 1, "expiresAt": soon}).Expect(http.StatusOK).Decode(&inv)
+	if !inv.ExpiresAt.Equal(soon) {
+		t.Errorf("invite expires at %v, want the requested %v", inv.ExpiresAt, soon)
+	}
+
+	// Admins have no quota and no TTL.
+	far := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	create(admin.Token, map[string]any{"maxUses": 10, "expiresAt": far}).Expect(http.StatusOK).Decode(&inv)
+	if !inv.ExpiresAt.Equal(far) {
+		t.Errorf("admin invite expires at %v, want %v", inv.ExpiresAt, far)
+	}
+}
+
+type inviteTreeNode struct {
+	ID       string           `json:"id"`
+	Username string           `json:"username"`
+	Disabled bool             `json:"disabled"`
+	Invited  []inviteTreeNode `json:"invited"`
+}
+
+func TestInviteTreeAndBranchRevoke(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		name := "keep accounts"
+		if disable {
+			name = "disable accounts"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := apitest.New(t, func(c *config.Config) { c.UserInviteQuota = 2 })
+			ctx := context.Background()
+			admin := s.Admin("root")
+			invite := func(u *apitest.User) (id, code string) {
+				var inv struct{ ID, Code string }
+				s.Request(http.MethodPost, "/api/v1/invites/", u.Token, map[string]any{}).Expect(http.StatusOK).Decode(&inv)
+				return inv.ID, inv.Code
+			}
+			// alice -> bob -> carol, and dave outside the branch.
+			alice := s.Register("alice")
+			_, code := invite(alice)
+			bob := s.RegisterWith(code, "bob")
+			_, code = invite(bob)
+			carol := s.RegisterWith(code, "carol")
+			carolInvite, _ := invite(carol)
+			dave := s.Register("dave")
+			daveInvite, _ := invite(dave)
+
+			var tree struct {
+				Tree []inviteTreeNode `json:"tree"`
+			}
+			s.Request(http.MethodGet, "/api/v1/admin/invite-tree?root="+alice.ID, admin.Token, nil).Expect(http.StatusOK).Decode(&tree)
+			if len(tree.Tree) != 1 || tree.Tree[0].ID != alice.ID || len(tree.Tree[0].Invited) != 1 ||
+				tree.Tree[0].Invited[0].ID != bob.ID || len(tree.Tree[0].Invited[0].Invited) != 1 || tree.Tree[0].Invited[0].Invited[0].ID != carol.ID {
+				t.Fatalf("tree from alice = %+v", tree.Tree)
+			}
+			s.Request(http.MethodGet, "/api/v1/admin/invite-tree", admin.Token, nil).Expect(http.StatusOK).Decode(&tree)
+			var roots []string
+			for _, n := range tree.Tree {
+				roots = append(roots, n.Username)
+			}
+			if !slices.Equal(roots, []string{"root", "alice", "dave"}) {
+				t.Errorf("roots = %v", roots)
+			}
+
+			var revoked struct {
+				InvitesDeactivated int64 `json:"invitesDeactivated"`
+				AccountsDisabled   int64 `json:"accountsDisabled"`
+			}
+			s.Request(http.MethodPost, "/api/v1/admin/users/"+bob.ID+"/revoke-invite-branch", admin.Token, map[string]any{"disableAccounts": disable}).Expect(http.StatusOK).Decode(&revoked)
+			// bob's invite, already used by carol, and carol's.
+			wantAccounts := int64(0)
+			if disable {
+				wantAccounts = 2
+			}
+			if revoked.InvitesDeactivated != 2 || revoked.AccountsDisabled != wantAccounts {
+				t.Errorf("revoke = %+v, want 2 invites and %d accounts", revoked, wantAccounts)
+			}
+			if inv, _ := s.Store.Invites.Get(ctx, carolInvite); inv.Active {
+				t.Error("carol's invite is still active")
+			}
+			if inv, _ := s.Store.Invites.Get(ctx, daveInvite); !inv.Active {
+				t.Error("dave's invite, outside the branch, was deactivated")
+			}
+			for _, u := range []*apitest.User{alice, bob, carol, dave} {
+				got, _ := s.Store.Users.Get(ctx, u.ID)
+				want := disable && (u == bob || u == carol)
+				if got.DisabledAt.Valid != want {
+					t.Errorf("%s disabled = %v, want %v", u.Username, got.DisabledAt.Valid, want)
+				}
+			}
+			s.Request(http.MethodGet, "/api/v1/admin/invite-tree?root="+bob.ID, admin.Token, nil).Expect(http.StatusOK).Decode(&tree)
+			if len(tree.Tree) != 1 || tree.Tree[0].Disabled != disable || len(tree.Tree[0].Invited) != 1 || tree.Tree[0].Invited[0].Disabled != disable {
+				t.Errorf("tree from bob after revoke = %+v", tree.Tree)
+			}
+			s.Request(http.MethodPost, "/api/v1/admin/users/"+admin.ID+"/revoke-invite-branch", admin.Token, map[string]any{"disableAccounts": disable}).Expect(http.StatusBadRequest)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := apitest.New(t)
+	get := func(id string) string {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Request-ID")
+	}
+
+	if got := get("trace-abc-123"); got != "trace-abc-123" {
+		t.Errorf("supplied ID echoed as %q", got)
+	}
+	long := strings.Repeat("a", 128)
+	if got := get(long); got != long {
+		t.Errorf("128-character ID echoed as %q", got)
+	}
+	for name, id := range map[string]string{"missing": "", "too long": long + "a"} {
+		got := get(id)
+		if _, err := uuid.Parse(got); err != nil || got == id {
+			t.Errorf("%s ID replaced with %q, want a new UUID", name, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package apitest runs the HTTP API in-process so integration tests can
+// drive real requests through httptest.
+//
+// With TEST_DATABASE_URL set (see package dbtest) every Server gets its own
+// freshly migrated Postgres database, dropped when the test ends. Without
+// it the Server runs on the in-memory store from package memstore, except
+// under CI=true, where a missing database fails the test instead.
+//
+// Every request is also checked against the OpenAPI document: a response
+// the document does not allow, or a request it rejects but the handler
+// accepts, fails the test.
+package apitest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/db/dbtest"
+	"messagingapi/internal/httpserver"
+	"messagingapi/internal/httpserver/openapi"
+	"messagingapi/internal/httpserver/routes"
+	"messagingapi/internal/store"
+	"messagingapi/internal/store/memstore"
+)
+
+// Server is the API wired to its own store.
+type Server struct {
+	t testing.TB
+	// DB is nil when the Server runs on the in-memory store.
+	DB     *sql.DB
+	Store  *store.Store
+	Config config.Config
+	Keys   *auth.KeySet
+	// Router is the API wrapped in the OpenAPI validator.
+	Router http.Handler
+}
+
+var cheapHashes sync.Once
+
+// New builds the router on a fresh store: a migrated and seeded Postgres
+// database when TEST_DATABASE_URL is set, else an empty in-memory store.
+// configure, when given, adjusts the config before the router is built;
+// DataDir already points at a temporary directory.
+func New(t testing.TB, configure ...func(*config.Config)) *Server {
+	t.Helper()
+	// Production Argon2 parameters would dominate the run time.
+	cheapHashes.Do(func() {
+		if err := auth.SetArgon2Params(auth.Argon2Params{Time: 1, Memory: 64, Threads: 1}); err != nil {
+			t.Fatalf("argon2 params: %v", err)
+		}
+	})
+
+	var conn *sql.DB
+	st := memstore.New()
+	switch {
+	case dbtest.Available():
+		conn = dbtest.Open(t)
+		st = store.NewPostgres(conn)
+	case dbtest.Required():
+		dbtest.Missing(t, "the API on Postgres")
+	}
+
+	cfg := config.Config{
+		DataDir:                 t.TempDir(),
+		AccountDeletionGrace:    7 * 24 * time.Hour,
+		UserInviteTTL:           7 * 24 * time.Hour,
+		VaultMaxAttempts:        10,
+		PINMaxAttempts:          5,
+		PINLockout:              15 * time.Minute,
+		TOTPMaxAttempts:         5,
+		TOTPLockout:             15 * time.Minute,
+		SealedSenderDailyTokens: 100,
+		TOTPIssuer:              "Messaging API",
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	now := time.Now()
+	keys := auth.NewKeySet([]auth.SigningKey{{KID: "apitest", Private: priv, CreatedAt: now, ActivatesAt: now.Add(-time.Minute)}})
+	passkeys, err := routes.NewPasskeys(cfg)
+	if err != nil {
+		t.Fatalf("passkeys: %v", err)
+	}
+	router, err := openapi.Middleware(httpserver.NewRouter(st, cfg, keys, passkeys), func(r *http.Request, err error) {
+		t.Errorf("%s %s does not match openapi.yaml: %v", r.Method, r.URL.Path, err)
+	})
+	if err != nil {
+		t.Fatalf("openapi: %v", err)
+	}
+
+	return &Server{
+		t:      t,
+		DB:     conn,
+		Store:  st,
+		Config: cfg,
+		Keys:   keys,
+		Router: router,
+	}
+}
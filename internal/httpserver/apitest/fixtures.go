@@ -0,0 +1,138 @@
+package apitest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"messagingapi/internal/store"
+)
+
+// Fixture credentials. Every fixture user shares them so tests can log in
+// without keeping track of passwords.
+const (
+	Password = "correct horse"
+	PIN      = "2468"
+)
+
+// User is a registered account with a valid access token.
+type User struct {
+	ID       string
+	Username string
+	Token    string
+}
+
+// Invite stores a registration invite and returns its code. Code defaults
+// to a random UUID and MaxUses to 1.
+func (s *Server) Invite(inv store.NewInvite) string {
+	s.t.Helper()
+	if inv.Code == "" {
+		inv.Code = uuid.NewString()
+	}
+	if inv.MaxUses == 0 {
+		inv.MaxUses = 1
+	}
+	if inv.Role == "" {
+		inv.Role = "member"
+	}
+	if _, err := s.Store.Invites.Create(context.Background(), inv); err != nil {
+		s.t.Fatalf("create invite: %v", err)
+	}
+	return inv.Code
+}
+
+// Register signs a user up through POST /auth/register with a fresh invite.
+func (s *Server) Register(username string) *User {
+	s.t.Helper()
+	return s.RegisterWith(s.Invite(store.NewInvite{}), username)
+}
+
+// RegisterWith registers username with the invite code, placing it below
+// the invite's creator in the invite tree.
+func (s *Server) RegisterWith(code, username string) *User {
+	s.t.Helper()
+	resp := s.Request(http.MethodPost, "/api/v1/auth/register", "", map[string]any{
+		"inviteCode":  code,
+		"username":    username,
+		"displayName": username,
+		"password":    Password,
+		"pin":         PIN,
+		"publicKey":   "pk-" + username,
+	}).Expect(http.StatusOK)
+	var body struct {
+		Token  string `json:"token"`
+		UserID string `json:"userId"`
+	}
+	resp.Decode(&body)
+	return &User{ID: body.UserID, Username: username, Token: body.Token}
+}
+
+// Admin registers a user and grants it admin rights.
+func (s *Server) Admin(username string) *User {
+	s.t.Helper()
+	u := s.Register(username)
+	if err := s.Store.Users.SetAdmin(context.Background(), u.ID, true); err != nil {
+		s.t.Fatalf("grant admin: %v", err)
+	}
+	return u
+}
+
+// CreateChat creates a chat owned by owner with members added.
+func (s *Server) CreateChat(owner *User, members ...*User) string {
+	s.t.Helper()
+	ids := []string{}
+	for _, m := range members {
+		ids = append(ids, m.ID)
+	}
+	resp := s.Request(http.MethodPost, "/api/v1/chats/", owner.Token, map[string]any{
+		"title": "chat", "isGroup": len(members) > 1, "memberIds": ids,
+	}).Expect(http.StatusOK)
+	var body struct {
+		ChatID string `json:"chatId"`
+	}
+	resp.Decode(&body)
+	return body.ChatID
+}
+
+// SendMessage posts ciphertext, and any files as attachments, to chatID.
+func (s *Server) SendMessage(from *User, chatID, ciphertext string, files ...File) string {
+	s.t.Helper()
+	for i := range files {
+		if files[i].Field == "" {
+			files[i].Field = "files"
+		}
+	}
+	resp := s.Multipart(http.MethodPost, "/api/v1/messages/", from.Token, map[string]string{
+		"chatId": chatID, "ciphertext": ciphertext, "nonce": "n",
+	}, files...).Expect(http.StatusOK)
+	var body struct {
+		MessageID string `json:"messageId"`
+	}
+	resp.Decode(&body)
+	return body.MessageID
+}
+
+// Message is a history entry as returned by the API.
+type Message struct {
+	ID          string `json:"id"`
+	ChatID      string `json:"chatId"`
+	SenderID    string `json:"senderId"`
+	Ciphertext  string `json:"ciphertext"`
+	Attachments []struct {
+		ID          string `json:"id"`
+		ContentType string `json:"contentType"`
+		SizeBytes   int64  `json:"sizeBytes"`
+	} `json:"attachments"`
+	Event map[string]any `json:"event"`
+}
+
+// History returns the newest page of chatID as seen by u.
+func (s *Server) History(u *User, chatID string) []Message {
+	s.t.Helper()
+	resp := s.Request(http.MethodGet, "/api/v1/chats/"+chatID+"/messages", u.Token, nil).Expect(http.StatusOK)
+	var body struct {
+		Messages []Message `json:"messages"`
+	}
+	resp.Decode(&body)
+	return body.Messages
+}
@@ -0,0 +1,127 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+// Response is a recorded response with helpers that fail the test on
+// unexpected results.
+type Response struct {
+	t    testing.TB
+	Code int
+	// Header and Body are the raw response.
+	Header http.Header
+	Body   []byte
+}
+
+// Expect fails the test unless the status is code. It returns r so calls
+// can be chained.
+func (r *Response) Expect(code int) *Response {
+	r.t.Helper()
+	if r.Code != code {
+		r.t.Fatalf("status = %d, want %d; body: %s", r.Code, code, r.Body)
+	}
+	return r
+}
+
+// Decode unmarshals the JSON body into v.
+func (r *Response) Decode(v any) {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("decode %s: %v", r.Body, err)
+	}
+}
+
+// JSON decodes the body as a JSON object.
+func (r *Response) JSON() map[string]any {
+	r.t.Helper()
+	var m map[string]any
+	r.Decode(&m)
+	return m
+}
+
+// Error returns the "error" field of a JSON error response.
+func (r *Response) Error() string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(r.Body, &body)
+	return body.Error
+}
+
+// Do serves req and records the response.
+func (s *Server) Do(req *http.Request) *Response {
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	return &Response{t: s.t, Code: w.Code, Header: w.Header(), Body: w.Body.Bytes()}
+}
+
+// Request sends body, if not nil, as JSON. A non-empty token is sent as
+// the bearer token.
+func (s *Server) Request(method, path, token string, body any) *Response {
+	s.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("encode request: %v", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.Do(req)
+}
+
+// File is a part of a multipart upload.
+type File struct {
+	Field       string
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Multipart sends fields and files as multipart/form-data.
+func (s *Server) Multipart(method, path, token string, fields map[string]string, files ...File) *Response {
+	s.t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			s.t.Fatalf("multipart field: %v", err)
+		}
+	}
+	for _, f := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="`+f.Field+`"; filename="`+f.Name+`"`)
+		if f.ContentType != "" {
+			h.Set("Content-Type", f.ContentType)
+		}
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			s.t.Fatalf("multipart file: %v", err)
+		}
+		part.Write(f.Data)
+	}
+	if err := mw.Close(); err != nil {
+		s.t.Fatalf("multipart: %v", err)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.Do(req)
+}
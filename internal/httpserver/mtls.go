@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// serviceAccount returns the user ID mapped to the verified client
+// certificate of r, trying its URI, DNS and email SANs before the subject
+// CN. Unverified or unmapped certificates yield "".
+func serviceAccount(r *http.Request, accounts map[string]string) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(accounts) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, "uri:"+u.String())
+	}
+	for _, d := range cert.DNSNames {
+		ids = append(ids, "dns:"+d)
+	}
+	for _, e := range cert.EmailAddresses {
+		ids = append(ids, "email:"+e)
+	}
+	ids = append(ids, "cn:"+cert.Subject.CommonName)
+	for _, id := range ids {
+		if userID, ok := accounts[id]; ok {
+			return userID
+		}
+	}
+	return ""
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
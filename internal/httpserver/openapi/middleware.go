@@ -0,0 +1,151 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// Middleware wraps next and calls report for every exchange the document
+// does not describe:
+//   - a request to a route missing from the document that next serves;
+//   - a request the document rejects but next answers with a 2xx or 3xx,
+//     meaning the document is stricter than the handler;
+//   - a status, or a JSON body, the document does not allow for the route.
+//
+// Authentication is not checked, and binary bodies (uploads, downloads,
+// the export ZIP) only have their status checked. Responses are buffered,
+// so Middleware is meant for tests.
+func Middleware(next http.Handler, report func(r *http.Request, err error)) (http.Handler, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var body []byte
+		if r.Body != nil {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = b
+		}
+
+		route, params, routeErr := router.FindRoute(r)
+		var reqErr error
+		if routeErr == nil {
+			reqErr = validateRequest(r, body, route, params)
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+
+		switch {
+		case routeErr != nil:
+			// Unknown paths and gin's trailing slash redirects are fine.
+			if rec.Code != http.StatusNotFound && rec.Code/100 != 3 {
+				report(r, fmt.Errorf("route not in the document, served with %d: %w", rec.Code, routeErr))
+			}
+			return
+		case reqErr != nil && rec.Code < 400:
+			report(r, fmt.Errorf("handler answered %d to a request the document rejects: %w", rec.Code, reqErr))
+		}
+		if err := validateResponse(r, route, params, rec); err != nil {
+			report(r, err)
+		}
+	}), nil
+}
+
+func validateRequest(r *http.Request, body []byte, route *routers.Route, params map[string]string) error {
+	body, err := normalizeUploads(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		return err
+	}
+	req := r.Clone(context.Background())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	})
+}
+
+func validateResponse(r *http.Request, route *routers.Route, params map[string]string, rec *httptest.ResponseRecorder) error {
+	opts := &openapi3filter.Options{IncludeResponseStatus: true}
+	if mt, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type")); mt != "application/json" {
+		opts.ExcludeResponseBody = true
+	}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: r, PathParams: params, Route: route},
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Options:                opts,
+	}
+	input.SetBodyBytes(rec.Body.Bytes())
+	return openapi3filter.ValidateResponse(r.Context(), input)
+}
+
+// normalizeUploads marks every file part of a multipart body as
+// application/octet-stream. openapi3filter only decodes parts whose content
+// type it knows, and uploads may be of any type.
+func normalizeUploads(contentType string, body []byte) ([]byte, error) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mt != "multipart/form-data" {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(params["boundary"]); err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		header := part.Header
+		if part.FileName() != "" {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(w, part); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+package openapi
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// serve runs req through Middleware around h and returns what was reported.
+func serve(t *testing.T, h http.HandlerFunc, req *http.Request) []string {
+	t.Helper()
+	var reports []string
+	mw, err := Middleware(h, func(_ *http.Request, err error) { reports = append(reports, err.Error()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw.ServeHTTP(httptest.NewRecorder(), req)
+	return reports
+}
+
+func jsonHandler(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	login := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	const valid = `{"username":"a","password":"p","pin":"1234"}`
+	const token = `{"token":"t","refreshToken":"r","userId":"u","mustChangeCredentials":false}`
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+		report  string
+	}{
+		{"conforming", jsonHandler(200, token), login(valid), ""},
+		{"second factor", jsonHandler(200, `{"twoFactorRequired":true,"challengeToken":"c","methods":["totp"]}`), login(valid), ""},
+		{"documented error", jsonHandler(401, `{"error":"invalid credentials"}`), login(valid), ""},
+		{"rejected invalid request", jsonHandler(400, `{"error":"bad"}`), login(`{"username":"a"}`), ""},
+		{"accepted invalid request", jsonHandler(200, token), login(`{"username":"a"}`), "request the document rejects"},
+		{"missing field", jsonHandler(200, `{"token":"t"}`), login(valid), "userId"},
+		{"error without message", jsonHandler(500, `{}`), login(valid), "error"},
+		{"undocumented route", jsonHandler(200, `{}`), httptest.NewRequest(http.MethodGet, "/api/v1/nope", nil), "route not in the document"},
+		{"unknown path", jsonHandler(404, `{}`), httptest.NewRequest(http.MethodGet, "/api/v1/nope", nil), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reports := serve(t, tt.handler, tt.req)
+			switch {
+			case tt.report == "" && len(reports) > 0:
+				t.Errorf("unexpected reports: %q", reports)
+			case tt.report != "" && (len(reports) != 1 || !strings.Contains(reports[0], tt.report)):
+				t.Errorf("reports = %q, want one mentioning %q", reports, tt.report)
+			}
+		})
+	}
+}
+
+func TestMiddlewareUploads(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("chatId", "c")
+	mw.WriteField("ciphertext", "x")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="files"; filename="a.png"`)
+	h.Set("Content-Type", "image/png")
+	part, _ := mw.CreatePart(h)
+	part.Write([]byte("\x89PNG"))
+	mw.Close()
+
+	var received int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// The handler still sees the original upload.
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			if f := r.MultipartForm.File["files"]; len(f) == 1 && f[0].Header.Get("Content-Type") == "image/png" {
+				received++
+			}
+		}
+		jsonHandler(200, `{"messageId":"m"}`)(w, r)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/messages/", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if reports := serve(t, handler, req); len(reports) > 0 {
+		t.Errorf("unexpected reports: %q", reports)
+	}
+	if received != 1 {
+		t.Error("handler did not receive the attachment unchanged")
+	}
+}
@@ -0,0 +1,46 @@
+// Package openapi holds the OpenAPI 3 description of the HTTP API and a
+// middleware that checks traffic against it.
+//
+// openapi.yaml is maintained by hand next to the routes. The router serves
+// it as JSON at /api/openapi.json, and the integration tests run every
+// request through Middleware so the document and the handlers cannot drift
+// apart.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+// Load parses and validates the document.
+func Load() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+var renderJSON = sync.OnceValues(func() ([]byte, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+})
+
+// JSON returns the document as served at /api/openapi.json. It is rendered
+// once and cached.
+func JSON() ([]byte, error) {
+	return renderJSON()
+}
@@ -1,58 +1,149 @@
 package httpserver
 
 import (
-	"crypto/tls"
-	"database/sql"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/trace"
 	"messagingapi/internal/auth"
 	"messagingapi/internal/config"
+	"messagingapi/internal/httpserver/openapi"
 	"messagingapi/internal/httpserver/routes"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
+	"messagingapi/internal/tracing"
 )
 
 type contextKey string
 
-func NewRouter(db *sql.DB, cfg config.Config) *gin.Engine {
+// NewRouter builds the API. pk is nil when passkeys are not configured.
+func NewRouter(st *store.Store, cfg config.Config, keys *auth.KeySet, pk *auth.Passkeys) *gin.Engine {
 	if gin.Mode() == gin.DebugMode {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(otelgin.Middleware(tracing.ServiceName))
 	r.Use(requestLogger())
-	r.Use(lastActiveMiddleware(db))
+	r.Use(metricsMiddleware())
+	r.Use(lastActiveMiddleware(st.Users))
 	r.Use(corsMiddleware())
+	if cfg.HTTPRedirectToHTTPS {
+		r.Use(hstsMiddleware(cfg.HSTSMaxAge))
+	}
 
 	api := r.Group("/api/v1")
 
-	routes.RegisterAuthRoutes(api.Group("/auth"), db, cfg)
+	routes.RegisterAuthRoutes(api.Group("/auth"), st, cfg, keys, pk)
+	routes.RegisterSealedRoutes(api.Group("/sealed"), st, cfg)
 	authRequired := api.Group("")
-	authRequired.Use(jwtAuthMiddleware(cfg.JWTSecret))
-	routes.RegisterUserRoutes(authRequired.Group("/users"), db, cfg)
-	routes.RegisterChatRoutes(authRequired.Group("/chats"), db, cfg)
-	routes.RegisterMessageRoutes(authRequired.Group("/messages"), db, cfg)
-	routes.RegisterMediaRoutes(authRequired.Group("/media"), db, cfg)
-	routes.RegisterInviteRoutes(authRequired.Group("/invites"), db, cfg)
+	authRequired.Use(jwtAuthMiddleware(st.Users, keys, cfg.ServiceAccounts))
+	routes.RegisterUserRoutes(authRequired.Group("/users"), st, cfg, pk)
+	routes.RegisterChatRoutes(authRequired.Group("/chats"), st, cfg)
+	routes.RegisterMessageRoutes(authRequired.Group("/messages"), st, cfg)
+	routes.RegisterMediaRoutes(authRequired.Group("/media"), st, cfg)
+	routes.RegisterInviteRoutes(authRequired.Group("/invites"), st, cfg)
+	routes.RegisterAdminRoutes(authRequired.Group("/admin"), st, cfg)
 
+	// Other services verify our access tokens against these public keys.
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": keys.JWKS()})
+	})
 	r.GET("/healthz", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	r.GET("/api/openapi.json", func(c *gin.Context) {
+		spec, err := openapi.JSON()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "openapi"})
+			return
+		}
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+	if cfg.MetricsAddr == "" && cfg.MetricsToken != "" {
+		r.GET("/metrics", gin.WrapH(metrics.Handler(cfg.MetricsToken)))
+	}
 
 	return r
 }
 
+// requestIDHeader is echoed back on every response; a client or proxy may
+// supply it to correlate logs across services.
+const requestIDHeader = "X-Request-ID"
+
+// requestLogger assigns the request ID and writes one structured log line per
+// request once the handler chain has finished.
 func requestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		c.Set("requestID", id)
+		c.Header(requestIDHeader, id)
 		c.Next()
-		latency := time.Since(start)
 		status := c.Writer.Status()
-		method := c.Request.Method
-		path := c.Request.URL.Path
-		_ = latency
-		_ = status
-		_ = method
-		_ = path
+		attrs := []any{
+			"request_id", id,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if uid := c.GetString("userID"); uid != "" {
+			attrs = append(attrs, "user_id", uid)
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			attrs = append(attrs, "trace_id", sc.TraceID().String())
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// metricsMiddleware records request counts and latency per route template,
+// so path parameters do not blow up label cardinality.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// hstsMiddleware tells browsers to use HTTPS only. Browsers ignore the
+// header on plain HTTP, so it is only sent over TLS.
+func hstsMiddleware(maxAge time.Duration) gin.HandlerFunc {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int(maxAge.Seconds()))
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil {
+			c.Header("Strict-Transport-Security", value)
+		}
+		c.Next()
 	}
 }
 
@@ -60,7 +151,8 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Device-ID, Unidentified-Access-Key, X-Request-ID, traceparent, tracestate, baggage")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(204)
 			return
@@ -69,36 +161,64 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-func jwtAuthMiddleware(secret string) gin.HandlerFunc {
+// credentialChangeRoutes stay reachable while must_change_credentials is set.
+var credentialChangeRoutes = map[string]bool{
+	"GET /api/v1/users/me":          true,
+	"PUT /api/v1/users/me/password": true,
+}
+
+// jwtAuthMiddleware authenticates with a bearer JWT or, without an
+// Authorization header, with a client certificate mapped in serviceAccounts.
+func jwtAuthMiddleware(users store.UserStore, keys *auth.KeySet, serviceAccounts map[string]string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var subject string
 		authz := c.GetHeader("Authorization")
-		if !strings.HasPrefix(authz, "Bearer ") {
+		switch {
+		case strings.HasPrefix(authz, "Bearer "):
+			claims, err := keys.ParseJWT(strings.TrimPrefix(authz, "Bearer "))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+				return
+			}
+			subject = claims.UserID
+		case authz == "":
+			subject = serviceAccount(c.Request, serviceAccounts)
+		}
+		if subject == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
 			return
 		}
-		t := strings.TrimPrefix(authz, "Bearer ")
-		claims, err := auth.ParseJWT(secret, t)
+		// Tokens outlive admin actions, so account state is checked on every request.
+		u, err := users.Get(c.Request.Context(), subject)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
+		if u.DisabledAt.Valid {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+			return
+		}
+		if u.SuspendedUntil.Valid && time.Now().Before(u.SuspendedUntil.Time) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account suspended", "suspendedUntil": u.SuspendedUntil.Time})
+			return
+		}
+		if u.MustChangeCredentials && !credentialChangeRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "credentials change required"})
+			return
+		}
+		c.Set("userID", u.ID)
+		c.Set("username", u.Username)
 		c.Next()
 	}
 }
 
-func lastActiveMiddleware(db *sql.DB) gin.HandlerFunc {
+func lastActiveMiddleware(users store.UserStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
-		if uid, ok := c.Get("userID"); ok {
-			_, _ = db.Exec(`UPDATE users SET last_active_at=now() WHERE id=$1`, uid)
+		if uid := c.GetString("userID"); uid != "" {
+			if err := users.TouchLastActive(c.Request.Context(), uid); err != nil {
+				slog.Warn("updating last_active_at failed", "request_id", c.GetString("requestID"), "user_id", uid, "err", err)
+			}
 		}
 	}
 }
-
-func ListenAndServeTLS(handler http.Handler, addr, certFile, keyFile string) error {
-	server := &http.Server{Addr: addr, Handler: handler}
-	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
-	return server.ListenAndServeTLS(certFile, keyFile)
-}
\ No newline at end of file
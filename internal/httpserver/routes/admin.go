@@ -0,0 +1,256 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+type suspendUserRequest struct {
+	Until  time.Time `json:"until" binding:"required"`
+	Reason string    `json:"reason"`
+}
+
+type disableUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type resetCredentialsRequest struct {
+	Password string `json:"password"`
+	PIN      string `json:"pin"`
+}
+
+func adminUser(u store.User) gin.H {
+	h := gin.H{"id": u.ID, "username": u.Username, "displayName": u.DisplayName, "isAdmin": u.IsAdmin, "mustChangeCredentials": u.MustChangeCredentials, "createdAt": u.CreatedAt}
+	if u.DisabledAt.Valid { h["disabledAt"] = u.DisabledAt.Time }
+	if u.SuspendedUntil.Valid && u.SuspendedUntil.Time.After(time.Now()) { h["suspendedUntil"] = u.SuspendedUntil.Time }
+	if u.LastActiveAt.Valid { h["lastActiveAt"] = u.LastActiveAt.Time }
+	return h
+}
+
+// RegisterAdminRoutes exposes user management for admins. Every mutating
+// endpoint writes an audit_log entry in the same transaction as the change.
+func RegisterAdminRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	r.Use(requireAdmin(st.Users))
+
+	r.GET("/users", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit <= 0 || limit > 200 { limit = 50 }
+		if offset < 0 { offset = 0 }
+		users, err := st.Users.List(c.Request.Context(), store.UserFilter{Query: c.Query("q"), Status: c.Query("status"), Limit: limit, Offset: offset})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, u := range users { list = append(list, adminUser(u)) }
+		c.JSON(http.StatusOK, gin.H{"users": list, "limit": limit, "offset": offset})
+	})
+
+	r.GET("/users/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		u, err := st.Users.Get(ctx, c.Param("id"))
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		usage, err := st.Users.Usage(ctx, u.ID)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		var avatarBytes int64
+		if u.AvatarPath.Valid && u.AvatarPath.String != "" {
+			if fi, err := os.Stat(u.AvatarPath.String); err == nil { avatarBytes = fi.Size() }
+		}
+		resp := adminUser(u)
+		resp["storage"] = gin.H{"attachmentBytes": usage.AttachmentBytes, "attachmentCount": usage.AttachmentCount, "avatarBytes": avatarBytes, "totalBytes": usage.AttachmentBytes + avatarBytes}
+		resp["messageCount"] = usage.MessageCount
+		c.JSON(http.StatusOK, resp)
+	})
+
+	r.POST("/users/:id/disable", func(c *gin.Context) {
+		var req disableUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if !adminUserAction(c, st, "user.disable", gin.H{"reason": req.Reason}, func(ctx context.Context, id string) error {
+			return st.Users.Disable(ctx, id)
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	r.POST("/users/:id/suspend", func(c *gin.Context) {
+		var req suspendUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if !req.Until.After(time.Now()) { c.JSON(http.StatusBadRequest, gin.H{"error":"until must be in the future"}); return }
+		if !adminUserAction(c, st, "user.suspend", gin.H{"until": req.Until, "reason": req.Reason}, func(ctx context.Context, id string) error {
+			return st.Users.Suspend(ctx, id, req.Until)
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	r.POST("/users/:id/enable", func(c *gin.Context) {
+		if !adminUserAction(c, st, "user.enable", nil, st.Users.Enable) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// Resetting credentials sets a temporary password and PIN (generated if
+	// not supplied) and forces the user to change both on next login.
+	r.POST("/users/:id/reset-credentials", func(c *gin.Context) {
+		var req resetCredentialsRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		resp := gin.H{}
+		if req.Password == "" { req.Password = randomToken(12); resp["temporaryPassword"] = req.Password }
+		if req.PIN == "" { req.PIN = randomDigits(6); resp["temporaryPin"] = req.PIN }
+		if len(req.Password) < 6 || len(req.PIN) < 4 || len(req.PIN) > 10 { c.JSON(http.StatusBadRequest, gin.H{"error":"password must be at least 6 characters and pin 4-10"}); return }
+		pwdHash, err := auth.HashPassword(req.Password)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"hash error"}); return }
+		pinHash, err := auth.HashPassword(req.PIN)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"hash error"}); return }
+		if !adminUserAction(c, st, "user.reset_credentials", nil, func(ctx context.Context, id string) error {
+			// An admin-chosen PIN must not unlock the user's key backup.
+			if err := st.Vaults.Delete(ctx, id); err != nil && !errors.Is(err, store.ErrNotFound) { return err }
+			if err := st.RefreshTokens.RevokeUser(ctx, id); err != nil { return err }
+			return st.Users.ResetCredentials(ctx, id, pwdHash, pinHash)
+		}) { return }
+		// Temporary secrets are only ever returned here, once.
+		resp["ok"] = true
+		c.JSON(http.StatusOK, resp)
+	})
+
+	r.PUT("/users/:id/admin", func(c *gin.Context) {
+		if !adminUserAction(c, st, "user.grant_admin", nil, func(ctx context.Context, id string) error {
+			return st.Users.SetAdmin(ctx, id, true)
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	r.DELETE("/users/:id/admin", func(c *gin.Context) {
+		if !adminUserAction(c, st, "user.revoke_admin", nil, func(ctx context.Context, id string) error {
+			return st.Users.SetAdmin(ctx, id, false)
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// PUT with {"quota": null} drops the override back to config.UserInviteQuota.
+	r.PUT("/users/:id/invite-quota", func(c *gin.Context) {
+		var req struct{ Quota *int `json:"quota"` }
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if req.Quota != nil && *req.Quota < 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"quota must not be negative"}); return }
+		if !adminUserAction(c, st, "user.set_invite_quota", gin.H{"quota": req.Quota}, func(ctx context.Context, id string) error {
+			return st.Users.SetInviteQuota(ctx, id, req.Quota)
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// The invite tree links each user to whoever created the invite they
+	// registered with. Without ?root= it starts from users that registered
+	// with a seeded invite or were created without one.
+	r.GET("/invite-tree", func(c *gin.Context) {
+		nodes, err := st.Invites.Tree(c.Request.Context(), c.Query("root"))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"tree": inviteTree(nodes)})
+	})
+
+	// Revoking a branch deactivates every invite issued by the user and
+	// anyone below them. With disableAccounts the branch's accounts,
+	// including the root, are disabled as well.
+	r.POST("/users/:id/revoke-invite-branch", func(c *gin.Context) {
+		var req struct {
+			DisableAccounts bool   `json:"disableAccounts"`
+			Reason          string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		var invites, accounts int64
+		if !adminUserAction(c, st, "user.revoke_invite_branch", gin.H{"disableAccounts": req.DisableAccounts, "reason": req.Reason}, func(ctx context.Context, id string) error {
+			if _, err := st.Users.Get(ctx, id); err != nil { return err }
+			var err error
+			if invites, err = st.Invites.DeactivateBranch(ctx, id); err != nil { return err }
+			if req.DisableAccounts {
+				accounts, err = st.Users.DisableBranch(ctx, id, c.GetString("userID"))
+			}
+			return err
+		}) { return }
+		c.JSON(http.StatusOK, gin.H{"ok": true, "invitesDeactivated": invites, "accountsDisabled": accounts})
+	})
+
+	r.GET("/audit", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit <= 0 || limit > 500 { limit = 100 }
+		if offset < 0 { offset = 0 }
+		entries, err := st.Audit.List(c.Request.Context(), store.AuditFilter{ActorID: c.Query("actorId"), TargetID: c.Query("targetId"), Action: c.Query("action"), Limit: limit, Offset: offset})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, e := range entries {
+			item := gin.H{"id": e.ID, "action": e.Action, "targetType": e.TargetType, "targetId": e.TargetID, "details": rawJSON(e.Details), "createdAt": e.CreatedAt}
+			if e.ActorID.Valid { item["actorId"] = e.ActorID.String }
+			list = append(list, item)
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": list, "limit": limit, "offset": offset})
+	})
+}
+
+// adminUserAction runs change against the user named by :id inside a
+// transaction together with its audit entry. Admins cannot target themselves,
+// which also guarantees revoking admin never removes the last one. It reports
+// whether the change was committed; on failure the error response has already
+// been written.
+func adminUserAction(c *gin.Context, st *store.Store, action string, details gin.H, change func(ctx context.Context, id string) error) bool {
+	actor := c.GetString("userID")
+	id := c.Param("id")
+	if id == actor { c.JSON(http.StatusBadRequest, gin.H{"error":"cannot target yourself"}); return false }
+	if details == nil { details = gin.H{} }
+	ctx, tx, err := st.Begin(c.Request.Context())
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return false }
+	defer tx.Rollback()
+	err = change(ctx, id)
+	if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return false }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return false }
+	if err := st.Audit.Record(ctx, actor, action, "user", id, details); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"audit"}); return false }
+	if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return false }
+	return true
+}
+
+// inviteTree nests the nodes under their parents; nodes must come in
+// registration order.
+func inviteTree(nodes []store.TreeNode) []gin.H {
+	byID := map[string]gin.H{}
+	children := map[string][]gin.H{}
+	roots := []gin.H{}
+	for _, t := range nodes {
+		n := gin.H{"id": t.ID, "username": t.Username, "displayName": t.DisplayName, "isAdmin": t.IsAdmin, "registeredAt": t.CreatedAt, "disabled": t.DisabledAt.Valid}
+		if t.InviteCode.Valid { n["inviteCode"] = t.InviteCode.String }
+		byID[t.ID] = n
+		if t.ParentID != "" { children[t.ParentID] = append(children[t.ParentID], n) } else { roots = append(roots, n) }
+	}
+	for _, t := range nodes {
+		byID[t.ID]["invited"] = append([]gin.H{}, children[t.ID]...)
+	}
+	return roots
+}
+
+type rawJSON []byte
+
+func (r rawJSON) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 { return []byte("null"), nil }
+	return r, nil
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)[:n]
+}
+
+func randomDigits(n int) string {
+	out := make([]byte, n)
+	for i := range out {
+		d, _ := rand.Int(rand.Reader, big.NewInt(10))
+		out[i] = byte('0' + d.Int64())
+	}
+	return string(out)
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/store"
+)
+
+// requireAdmin aborts with 403 unless the authenticated user is an admin.
+func requireAdmin(users store.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := users.Get(c.Request.Context(), c.GetString("userID"))
+		if err != nil || !u.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error":"admin only"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,14 +1,17 @@
 package routes
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"messagingapi/internal/auth"
 	"messagingapi/internal/config"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
 )
 
 type registerRequest struct {
@@ -20,29 +23,38 @@ type registerRequest struct {
 	PublicKey  string `json:"publicKey" binding:"required"`
 }
 
+// challengeMaxAttempts is how many wrong codes spend a challenge token, so
+// guessing further needs the password and PIN again.
+const challengeMaxAttempts = 3
+
+type loginTOTPRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
 type loginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	PIN      string `json:"pin" binding:"required"`
 }
 
-func RegisterAuthRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
+func RegisterAuthRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config, keys *auth.KeySet, pk *auth.Passkeys) {
 	r.POST("/register", func(c *gin.Context) {
 		var req registerRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var inviteID string
-		var maxUses, uses int
-		var active bool
-		var expiresAt sql.NullTime
-		err := db.QueryRow(`SELECT id, max_uses, uses, active, expires_at FROM invites WHERE code=$1`, req.InviteCode).Scan(&inviteID, &maxUses, &uses, &active, &expiresAt)
+		inv, err := st.Invites.GetByCode(c.Request.Context(), req.InviteCode, "register")
 		if err != nil {
 			c.JSON(http.StatusForbidden, gin.H{"error": "invalid invite"})
 			return
 		}
-		if !active || (expiresAt.Valid && time.Now().After(expiresAt.Time)) || uses >= maxUses {
+		if !inv.Usable(time.Now()) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "invite not usable"})
 			return
 		}
@@ -50,43 +62,252 @@ func RegisterAuthRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"}); return }
 		pinHash, err := auth.HashPassword(req.PIN)
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "hash error"}); return }
-		var userID uuid.UUID
-		err = db.QueryRow(`INSERT INTO users (username, display_name, password_hash, pin_hash, public_key) VALUES ($1,$2,$3,$4,$5) RETURNING id`,
-			req.Username, req.DisplayName, pwdHash, pinHash, req.PublicKey).Scan(&userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "username taken?"})
+		// The check above is only a fast path; the invite is consumed with a
+		// conditional increment in the same transaction as the user insert so
+		// concurrent registrations cannot exceed max_uses.
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		defer tx.Rollback()
+		if err := st.Invites.Consume(ctx, inv.ID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invite not usable"})
 			return
 		}
-		_, _ = db.Exec(`UPDATE invites SET uses = uses + 1 WHERE id=$1`, inviteID)
-		token, err := auth.GenerateJWT(cfg.JWTSecret, userID.String(), req.Username, 24*time.Hour)
+		userID, err := st.Users.Create(ctx, store.NewUser{Username: req.Username, DisplayName: req.DisplayName, PasswordHash: pwdHash, PINHash: pinHash, PublicKey: req.PublicKey, InviteID: inv.ID})
+		if errors.Is(err, store.ErrConflict) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username taken"})
+			return
+		}
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		// Creating the account is the invite's use, so it is consumed above
+		// even when the chat join still awaits approval; approving it later
+		// does not consume again.
+		if inv.ChatID.Valid {
+			if _, err := st.Chats.Join(ctx, inv.ChatID.String, userID, inv.Role, inv.ID, inv.RequiresApproval); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "join chat"}); return }
+		}
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		token, err := keys.GenerateJWT(userID, req.Username, auth.AccessTokenTTL)
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"}); return }
-		c.JSON(http.StatusOK, gin.H{"token": token, "userId": userID.String()})
+		refresh, err := newRefreshToken(c.Request.Context(), st.RefreshTokens, userID, "")
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		resp := gin.H{"token": token, "refreshToken": refresh, "userId": userID}
+		if inv.ChatID.Valid { resp["chatId"] = inv.ChatID.String; resp["chatPending"] = inv.RequiresApproval }
+		c.JSON(http.StatusOK, resp)
 	})
 
 	r.POST("/login", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		var req loginRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var id, username, pwdHash, pinHash string
-		err := db.QueryRow(`SELECT id, username, password_hash, pin_hash FROM users WHERE username=$1`, req.Username).Scan(&id, &username, &pwdHash, &pinHash)
+		u, err := st.Users.GetByUsername(ctx, req.Username)
 		if err != nil {
+			metrics.LoginFailures.WithLabelValues("password", "unknown_user").Inc()
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
-		ok, _ := auth.VerifyPassword(pwdHash, req.Password)
+		ok, _ := auth.VerifyPassword(u.PasswordHash, req.Password)
 		if !ok {
+			metrics.LoginFailures.WithLabelValues("password", "invalid_password").Inc()
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
-		ok, _ = auth.VerifyPassword(pinHash, req.PIN)
-		if !ok {
+		// The PIN shares the failed-attempt counter of every other PIN check.
+		// Password failures are not counted, so nobody can lock an account
+		// by username alone, and a wrong PIN or a lockout answers like a
+		// wrong password so the response does not confirm the password.
+		check, err := verifyCredentials(ctx, st, cfg, u.ID, "", req.PIN)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if !check.OK {
+			reason := "invalid_pin"
+			if check.Refused { reason = "locked_out" }
+			metrics.LoginFailures.WithLabelValues("password", reason).Inc()
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
-		token, err := auth.GenerateJWT(cfg.JWTSecret, id, username, 24*time.Hour)
-		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"}); return }
-		c.JSON(http.StatusOK, gin.H{"token": token, "userId": id})
+		if !accountActive(c, u.DisabledAt, u.SuspendedUntil) { metrics.LoginFailures.WithLabelValues("password", "account_inactive").Inc(); return }
+		if err := rehashCredentials(ctx, st.Users, u, req.Password, req.PIN); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		// A registered passkey or TOTP turns password + PIN into the first of
+		// two steps; the client finishes with /login/totp or /login/passkey.
+		var methods []string
+		if u.TOTPEnabledAt.Valid { methods = append(methods, "totp", "recovery") }
+		if pk != nil {
+			passkeys, err := st.Passkeys.Count(ctx, u.ID)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			if passkeys > 0 { methods = append(methods, "passkey") }
+		}
+		if len(methods) > 0 {
+			challenge, err := keys.GenerateChallengeJWT(u.ID, u.Username, 5*time.Minute)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"}); return }
+			c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "challengeToken": challenge, "methods": methods})
+			return
+		}
+		issueAccessToken(c, st, keys, u.ID, "")
+	})
+
+	// Second login step: exchanges the challenge token from /login and a TOTP
+	// or recovery code for an access token. The exchange spends the
+	// challenge, and so do challengeMaxAttempts wrong codes; wrong codes also
+	// count towards a per-user lockout of this step.
+	r.POST("/login/totp", func(c *gin.Context) {
+		var req loginTOTPRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		claims, err := keys.ParseChallengeJWT(req.ChallengeToken)
+		if err != nil {
+			metrics.LoginFailures.WithLabelValues("totp", "invalid_challenge").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"})
+			return
+		}
+		uid := claims.UserID
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		defer tx.Rollback()
+		// Locking the user row first serialises attempts per user.
+		t, err := st.TOTP.Lock(ctx, uid)
+		if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"}); return }
+		ch, err := st.Challenges.Lock(ctx, claims.ID, uid, claims.ExpiresAt.Time)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if ch.Used {
+			metrics.LoginFailures.WithLabelValues("totp", "spent_challenge").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"})
+			return
+		}
+		if t.LockedUntil.Valid && time.Now().Before(t.LockedUntil.Time) {
+			metrics.LoginFailures.WithLabelValues("totp", "locked_out").Inc()
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts", "lockedUntil": t.LockedUntil.Time})
+			return
+		}
+		ok, err := verifySecondFactor(ctx, st.TOTP, uid, req.Code)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if !ok {
+			failures := t.FailedAttempts + 1
+			lockedUntil := lockout(failures, cfg.TOTPMaxAttempts, cfg.TOTPLockout)
+			ch.Failures++
+			ch.Used = ch.Failures >= challengeMaxAttempts
+			if err := st.TOTP.SetFailures(ctx, uid, failures, lockedUntil); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			if err := st.Challenges.Update(ctx, claims.ID, ch); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			metrics.LoginFailures.WithLabelValues("totp", "invalid_code").Inc()
+			resp := gin.H{"error": "invalid code"}
+			if lockedUntil.Valid { resp["lockedUntil"] = lockedUntil.Time }
+			c.JSON(http.StatusUnauthorized, resp)
+			return
+		}
+		if t.FailedAttempts > 0 || t.LockedUntil.Valid {
+			if err := st.TOTP.SetFailures(ctx, uid, 0, sql.NullTime{}); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		}
+		ch.Used = true
+		if err := st.Challenges.Update(ctx, claims.ID, ch); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		issueAccessToken(c, st, keys, uid, "")
+	})
+
+	// Exchanges a refresh token for a new access token and the next refresh
+	// token. Each refresh token works once: presenting a spent one means it
+	// was copied, so every token of its session is revoked and the holder
+	// has to log in again.
+	r.POST("/refresh", func(c *gin.Context) {
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash := hashSecret(req.RefreshToken)
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		defer tx.Rollback()
+		rt, err := st.RefreshTokens.Lock(ctx, hash)
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if rt.Used {
+			if err := st.RefreshTokens.RevokeFamily(ctx, rt.FamilyID); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+			metrics.LoginFailures.WithLabelValues("refresh", "reused_token").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		// A disabled or suspended account keeps its token for when it is
+		// enabled again.
+		u, err := st.Users.Get(ctx, rt.UserID)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		if !accountActive(c, u.DisabledAt, u.SuspendedUntil) { return }
+		if err := st.RefreshTokens.Spend(ctx, hash); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		// The token is spent before the next one is issued, so a failure in
+		// between ends the session rather than leaving two tokens usable.
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+		issueAccessToken(c, st, keys, rt.UserID, rt.FamilyID)
 	})
-}
\ No newline at end of file
+
+	registerPasskeyLoginRoutes(r, st, keys, pk)
+}
+
+// issueAccessToken is the single place a login flow or a refresh turns into
+// an access token, whichever factors were used to get there. The refresh
+// token that comes with it continues family, or starts a session when "".
+func issueAccessToken(c *gin.Context, st *store.Store, keys *auth.KeySet, uid, family string) {
+	ctx := c.Request.Context()
+	u, err := st.Users.Get(ctx, uid)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	if !accountActive(c, u.DisabledAt, u.SuspendedUntil) { return }
+	token, err := keys.GenerateJWT(uid, u.Username, auth.AccessTokenTTL)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"}); return }
+	refresh, err := newRefreshToken(ctx, st.RefreshTokens, uid, family)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+	c.JSON(http.StatusOK, gin.H{"token": token, "refreshToken": refresh, "userId": uid, "mustChangeCredentials": u.MustChangeCredentials})
+}
+
+// newRefreshToken stores a refresh token for uid in family, or in a new
+// family when "", and returns it. Only its hash is kept.
+func newRefreshToken(ctx context.Context, tokens store.RefreshTokenStore, uid, family string) (string, error) {
+	token := randomSecret()
+	return token, tokens.Create(ctx, uid, family, hashSecret(token), time.Now().Add(auth.RefreshTokenTTL))
+}
+
+// accountActive writes a 403 and returns false for disabled or suspended accounts.
+func accountActive(c *gin.Context, disabledAt, suspendedUntil sql.NullTime) bool {
+	if disabledAt.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return false
+	}
+	if suspendedUntil.Valid && time.Now().Before(suspendedUntil.Time) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account suspended", "suspendedUntil": suspendedUntil.Time})
+		return false
+	}
+	return true
+}
+
+// rehashCredentials upgrades the stored password and PIN hashes when they
+// were made with weaker Argon2 parameters than the configured ones. It runs
+// after both have been verified, while the plaintexts are at hand.
+func rehashCredentials(ctx context.Context, users store.UserStore, u store.User, password, pin string) error {
+	if !auth.NeedsRehash(u.PasswordHash) && !auth.NeedsRehash(u.PINHash) { return nil }
+	newPwd, err := auth.HashPassword(password)
+	if err != nil { return err }
+	newPin, err := auth.HashPassword(pin)
+	if err != nil { return err }
+	// Only replace the hashes that were verified, in case the user changed
+	// them concurrently.
+	return users.ReplaceCredentials(ctx, u.ID, u.PasswordHash, u.PINHash, newPwd, newPin)
+}
+
+// spendChallenge marks a challenge token as used by a successful second
+// factor. It writes 401 and returns false when the token was spent already.
+func spendChallenge(c *gin.Context, st *store.Store, claims *auth.Claims) bool {
+	ctx, tx, err := st.Begin(c.Request.Context())
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return false }
+	defer tx.Rollback()
+	ch, err := st.Challenges.Lock(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time)
+	if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"}); return false }
+	if ch.Used { c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"}); return false }
+	ch.Used = true
+	if err := st.Challenges.Update(ctx, claims.ID, ch); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return false }
+	if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return false }
+	return true
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("messagingapi/internal/httpserver/routes")
+
+// saveUpload writes an uploaded file to path inside a blob.write span, so
+// slow disks show up next to the SQL spans of the same request.
+func saveUpload(c *gin.Context, kind string, file *multipart.FileHeader, path string) error {
+	_, span := tracer.Start(c.Request.Context(), "blob.write", trace.WithAttributes(
+		attribute.String("blob.kind", kind),
+		attribute.String("blob.path", path),
+		attribute.Int64("blob.size", file.Size),
+	))
+	defer span.End()
+	if err := c.SaveUploadedFile(file, path); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write failed")
+		return err
+	}
+	return nil
+}
+
+// clearWriteDeadline lifts the server's WriteTimeout for a response whose
+// length depends on stored data (media, the export ZIP) rather than on the
+// request. Writers that cannot set deadlines, as in tests, are left alone.
+func clearWriteDeadline(c *gin.Context) {
+	err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		_ = c.Error(err)
+	}
+}
@@ -1,13 +1,15 @@
 package routes
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"messagingapi/internal/config"
+	"messagingapi/internal/store"
 )
 
 type createChatRequest struct {
@@ -16,38 +18,173 @@ type createChatRequest struct {
 	MemberIDs []string `json:"memberIds" binding:"required"`
 }
 
-func RegisterChatRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
+type createChatInviteRequest struct {
+	MaxUses          int        `json:"maxUses"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	Role             string     `json:"role"`
+	RequiresApproval bool       `json:"requiresApproval"`
+}
+
+type joinChatRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Chat roles. Owners and admins manage invites and join requests.
+const (
+	roleOwner  = "owner"
+	roleAdmin  = "admin"
+	roleMember = "member"
+)
+
+func RegisterChatRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
 	r.POST("/", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		uid := c.GetString("userID")
 		var req createChatRequest
 		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
-		var chatID uuid.UUID
-		err := db.QueryRow(`INSERT INTO chats (title, is_group, created_by) VALUES ($1,$2,$3) RETURNING id`, req.Title, req.IsGroup, uid).Scan(&chatID)
+		chatID, err := st.Chats.Create(ctx, req.Title, req.IsGroup, uid)
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create chat"}); return }
-		_, _ = db.Exec(`INSERT INTO chat_members (chat_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`, chatID, uid)
+		if err := st.Chats.AddMember(ctx, chatID, uid, roleOwner); err != nil {
+			requestLog(c).Error("adding chat owner failed", "chat_id", chatID, "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error":"create chat"}); return
+		}
 		for _, mid := range req.MemberIDs {
-			_, _ = db.Exec(`INSERT INTO chat_members (chat_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`, chatID, mid)
+			// Unknown member IDs are skipped rather than failing the chat.
+			if err := st.Chats.AddMember(ctx, chatID, mid, roleMember); err != nil {
+				requestLog(c).Warn("adding chat member failed", "chat_id", chatID, "member_id", mid, "err", err)
+			}
 		}
-		c.JSON(http.StatusOK, gin.H{"chatId": chatID.String()})
+		c.JSON(http.StatusOK, gin.H{"chatId": chatID})
 	})
 
+	registerHistoryRoutes(r, st)
+
 	r.DELETE("/:id/clear", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		uid := c.GetString("userID")
 		chatID := c.Param("id")
-		var member int
-		if err := db.QueryRow(`SELECT COUNT(*) FROM chat_members WHERE chat_id=$1 AND user_id=$2`, chatID, uid).Scan(&member); err != nil || member == 0 {
-			c.JSON(http.StatusForbidden, gin.H{"error":"not member"}); return
-		}
+		if !isChatMember(c, st, chatID, uid) { return }
 		// Delete attachments files and messages in this chat
-		rows, err := db.Query(`SELECT a.file_path FROM attachments a JOIN messages m ON a.message_id=m.id WHERE m.chat_id=$1`, chatID)
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var p string
-				if err := rows.Scan(&p); err == nil { _ = os.Remove(p) }
+		paths, err := st.Attachments.PathsByChat(ctx, chatID)
+		if err != nil { requestLog(c).Error("listing attachments failed", "chat_id", chatID, "err", err) }
+		for _, p := range paths { removeFile(c, p) }
+		if err := st.Messages.DeleteByChat(ctx, chatID); err != nil {
+			requestLog(c).Error("clearing chat failed", "chat_id", chatID, "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error":"delete"}); return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// Chat-join invites let existing users join through POST /chats/join.
+	// Only the chat's owner and admins may create them.
+	r.POST("/:id/invites", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		var req createChatInviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if !isChatManager(ctx, st, chatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"chat admin only"}); return }
+		if req.MaxUses <= 0 { req.MaxUses = 1 }
+		if req.Role == "" { req.Role = roleMember }
+		if req.Role != roleMember && req.Role != roleAdmin { c.JSON(http.StatusBadRequest, gin.H{"error":"role must be member or admin"}); return }
+		if req.Role == roleAdmin && !isChatOwner(ctx, st, chatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"only the chat owner can invite admins"}); return }
+		code := uuid.NewString()
+		id, err := st.Invites.Create(ctx, store.NewInvite{Code: code, CreatedBy: uid, MaxUses: req.MaxUses, ExpiresAt: req.ExpiresAt, Kind: "chat_join", ChatID: &chatID, Role: req.Role, RequiresApproval: req.RequiresApproval})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create invite"}); return }
+		c.JSON(http.StatusOK, gin.H{"id": id, "code": code})
+	})
+
+	r.POST("/join", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req joinChatRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		inv, err := st.Invites.GetByCode(ctx, req.Code, "chat_join")
+		if err != nil { c.JSON(http.StatusForbidden, gin.H{"error":"invalid invite"}); return }
+		member, err := st.Chats.IsMember(ctx, inv.ChatID.String, uid)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		if member { c.JSON(http.StatusConflict, gin.H{"error":"already member", "chatId": inv.ChatID.String}); return }
+		// A request awaiting approval only checks the invite; the use is
+		// consumed when it is approved, so rejected or withdrawn requests
+		// do not burn uses.
+		if inv.RequiresApproval {
+			if !inv.Usable(time.Now()) { c.JSON(http.StatusForbidden, gin.H{"error":"invite not usable"}); return }
+		} else if err := st.Invites.Consume(ctx, inv.ID); err != nil { c.JSON(http.StatusForbidden, gin.H{"error":"invite not usable"}); return }
+		pending, err := st.Chats.Join(ctx, inv.ChatID.String, uid, inv.Role, inv.ID, inv.RequiresApproval)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"join"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"chatId": inv.ChatID.String, "pending": pending})
+	})
+
+	r.GET("/:id/join-requests", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		if !isChatManager(ctx, st, chatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"chat admin only"}); return }
+		requests, err := st.Chats.JoinRequests(ctx, chatID)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, jr := range requests {
+			list = append(list, gin.H{"userId": jr.UserID, "username": jr.Username, "displayName": jr.DisplayName, "role": jr.Role, "requestedAt": jr.CreatedAt})
+		}
+		c.JSON(http.StatusOK, gin.H{"requests": list})
+	})
+
+	r.POST("/:id/join-requests/:userId/approve", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		if !isChatManager(c.Request.Context(), st, chatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"chat admin only"}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		jr, err := st.Chats.TakeJoinRequest(ctx, chatID, c.Param("userId"))
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		// Requests made through POST /chats/join consume their invite here.
+		// Registration already used it, and a deleted invite has nothing left
+		// to consume.
+		if jr.InviteID.Valid {
+			inv, err := st.Invites.Get(ctx, jr.InviteID.String)
+			if err != nil && !errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+			if err == nil && inv.Kind == "chat_join" {
+				if err := st.Invites.Consume(ctx, inv.ID); err != nil { c.JSON(http.StatusConflict, gin.H{"error":"invite not usable"}); return }
 			}
 		}
-		_, _ = db.Exec(`DELETE FROM messages WHERE chat_id=$1`, chatID)
+		if _, err := st.Chats.Join(ctx, chatID, jr.UserID, jr.Role, jr.InviteID.String, false); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"join"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
 		c.JSON(http.StatusOK, gin.H{"ok": true})
 	})
-}
\ No newline at end of file
+
+	r.DELETE("/:id/join-requests/:userId", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		// Requesters may withdraw their own request.
+		if c.Param("userId") != uid && !isChatManager(ctx, st, chatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"chat admin only"}); return }
+		err := st.Chats.DeleteJoinRequest(ctx, chatID, c.Param("userId"))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+// isChatManager reports whether uid is an owner or admin of chatID.
+func isChatManager(ctx context.Context, st *store.Store, chatID, uid string) bool {
+	role, err := st.Chats.MemberRole(ctx, chatID, uid)
+	return err == nil && (role == roleOwner || role == roleAdmin)
+}
+
+// isChatOwner reports whether uid owns chatID.
+func isChatOwner(ctx context.Context, st *store.Store, chatID, uid string) bool {
+	role, err := st.Chats.MemberRole(ctx, chatID, uid)
+	return err == nil && role == roleOwner
+}
+
+// isChatMember writes a 403 and returns false unless uid belongs to chatID.
+func isChatMember(c *gin.Context, st *store.Store, chatID, uid string) bool {
+	member, err := st.Chats.IsMember(c.Request.Context(), chatID, uid)
+	if err != nil { requestLog(c).Error("checking membership failed", "chat_id", chatID, "err", err) }
+	if !member { c.JSON(http.StatusForbidden, gin.H{"error":"not member"}); return false }
+	return true
+}
@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+// maxLockout caps the doubling lockout after repeated failures.
+const maxLockout = 24 * time.Hour
+
+// credentialCheck is the outcome of verifyCredentials.
+type credentialCheck struct {
+	User store.User
+	OK   bool
+	// Failures is the count after this check; LockedUntil is set when the
+	// account is locked out, whether by this check or an earlier one.
+	Failures    int
+	LockedUntil sql.NullTime
+	// Refused means a lockout prevented checking at all.
+	Refused bool
+	// BackupDeleted means this failure destroyed the key backup.
+	BackupDeleted bool
+}
+
+// verifyCredentials checks pin, and password unless it is empty, for uid.
+// Every endpoint that checks the PIN goes through it, so guesses share one
+// counter wherever they are made: every cfg.PINMaxAttempts failures in a
+// row lock the checks for cfg.PINLockout, doubling with each lockout, and
+// cfg.VaultMaxAttempts failures destroy the key backup. A success resets
+// the counter.
+//
+// It runs in its own transaction with the user row locked, so concurrent
+// guesses are counted one by one; ctx must not carry a transaction.
+func verifyCredentials(ctx context.Context, st *store.Store, cfg config.Config, uid, password, pin string) (credentialCheck, error) {
+	ctx, tx, err := st.Begin(ctx)
+	if err != nil { return credentialCheck{}, err }
+	defer tx.Rollback()
+	u, err := st.Users.Lock(ctx, uid)
+	if err != nil { return credentialCheck{}, err }
+	check := credentialCheck{User: u, Failures: u.FailedCredentialAttempts}
+	if u.CredentialsLockedUntil.Valid && time.Now().Before(u.CredentialsLockedUntil.Time) {
+		check.LockedUntil, check.Refused = u.CredentialsLockedUntil, true
+		return check, nil
+	}
+	check.OK, _ = auth.VerifyPassword(u.PINHash, pin)
+	if check.OK && password != "" { check.OK, _ = auth.VerifyPassword(u.PasswordHash, password) }
+	if check.OK {
+		check.Failures = 0
+		if u.FailedCredentialAttempts == 0 && !u.CredentialsLockedUntil.Valid { return check, nil }
+		if err := st.Users.SetCredentialFailures(ctx, uid, 0, sql.NullTime{}); err != nil { return credentialCheck{}, err }
+		return check, tx.Commit()
+	}
+
+	check.Failures++
+	check.LockedUntil = lockout(check.Failures, cfg.PINMaxAttempts, cfg.PINLockout)
+	if err := st.Users.SetCredentialFailures(ctx, uid, check.Failures, check.LockedUntil); err != nil { return credentialCheck{}, err }
+	if check.Failures >= cfg.VaultMaxAttempts {
+		err := st.Vaults.Delete(ctx, uid)
+		if err != nil && !errors.Is(err, store.ErrNotFound) { return credentialCheck{}, err }
+		check.BackupDeleted = err == nil
+	}
+	return check, tx.Commit()
+}
+
+// checkCredentials is verifyCredentials for signed-in callers. On failure it
+// writes 401 with the attempts left before the next lockout, or 429 while
+// locked out, and returns false.
+func checkCredentials(c *gin.Context, st *store.Store, cfg config.Config, uid, password, pin string) (store.User, bool) {
+	check, err := verifyCredentials(c.Request.Context(), st, cfg, uid, password, pin)
+	if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return check.User, false }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return check.User, false }
+	if check.OK { return check.User, true }
+	if check.Refused { c.JSON(http.StatusTooManyRequests, gin.H{"error":"too many attempts", "lockedUntil": check.LockedUntil.Time}); return check.User, false }
+	msg := "invalid pin"
+	if password != "" { msg = "invalid credentials" }
+	resp := gin.H{"error": msg, "attemptsRemaining": cfg.PINMaxAttempts - check.Failures%cfg.PINMaxAttempts}
+	if check.LockedUntil.Valid { resp["attemptsRemaining"], resp["lockedUntil"] = 0, check.LockedUntil.Time }
+	if check.BackupDeleted { resp["keyBackupDeleted"] = true }
+	c.JSON(http.StatusUnauthorized, resp)
+	return check.User, false
+}
+
+// lockout returns the end of the lockout triggered by the failures-th
+// failure in a row: every limit failures lock for base, doubling each time
+// up to maxLockout. It is not valid when failures triggers none.
+func lockout(failures, limit int, base time.Duration) sql.NullTime {
+	if failures%limit != 0 { return sql.NullTime{} }
+	d := base
+	for n := failures / limit; n > 1 && d < maxLockout; n-- { d *= 2 }
+	return sql.NullTime{Time: time.Now().Add(min(d, maxLockout)), Valid: true}
+}
@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/store"
+)
+
+// deviceHeader names the caller's registered device. Requests that read
+// messages send it so fan-out messages resolve to that device's envelope.
+const deviceHeader = "X-Device-ID"
+
+type registerDeviceRequest struct {
+	Name string `json:"name"`
+}
+
+// registerDeviceRoutes mounts device registration under /users/me/devices.
+func registerDeviceRoutes(r *gin.RouterGroup, devices store.DeviceStore) {
+	r.GET("", func(c *gin.Context) {
+		list, err := devices.List(c.Request.Context(), c.GetString("userID"))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		items := []gin.H{}
+		for _, d := range list {
+			item := gin.H{"id": d.ID, "name": d.Name, "createdAt": d.CreatedAt}
+			if d.LastSeenAt.Valid { item["lastSeenAt"] = d.LastSeenAt.Time }
+			items = append(items, item)
+		}
+		c.JSON(http.StatusOK, gin.H{"devices": items})
+	})
+
+	r.POST("", func(c *gin.Context) {
+		var req registerDeviceRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		id, err := devices.Create(c.Request.Context(), c.GetString("userID"), req.Name)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusCreated, gin.H{"id": id})
+	})
+
+	// Removing a device drops every envelope still addressed to it.
+	r.DELETE("/:id", func(c *gin.Context) {
+		err := devices.Delete(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+// requestDevice returns the caller's device from the X-Device-ID header,
+// or "" when the header is absent. ok is false, with a response already
+// written, when the header names a device the caller does not own.
+func requestDevice(c *gin.Context, devices store.DeviceStore) (deviceID string, ok bool) {
+	deviceID = c.GetHeader(deviceHeader)
+	if deviceID == "" { return "", true }
+	if err := devices.Touch(c.Request.Context(), c.GetString("userID"), deviceID); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid device"}); return "", false }
+	return deviceID, true
+}
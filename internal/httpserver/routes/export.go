@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/store"
+)
+
+type exportAttachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	File        string `json:"file"`
+}
+
+type exportMessage struct {
+	ID          string             `json:"id"`
+	SenderID    *string            `json:"senderId"`
+	Ciphertext  string             `json:"ciphertext"`
+	Nonce       *string            `json:"nonce,omitempty"`
+	ReplyTo     *string            `json:"replyToId,omitempty"`
+	IsDeleted   bool               `json:"isDeleted"`
+	EditedAt    *time.Time         `json:"editedAt,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Attachments []exportAttachment `json:"attachments,omitempty"`
+	// A fan-out message has no ciphertext of its own, only the envelopes
+	// addressed to the exporting user's devices.
+	Fanout    bool             `json:"fanout"`
+	Sealed    bool             `json:"sealed"`
+	Envelopes []exportEnvelope `json:"envelopes,omitempty"`
+}
+
+type exportEnvelope struct {
+	DeviceID   string `json:"deviceId"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type exportProfile struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	DisplayName  string     `json:"displayName"`
+	PublicKey    string     `json:"publicKey"`
+	IsAdmin      bool       `json:"isAdmin"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastActiveAt *time.Time `json:"lastActiveAt,omitempty"`
+	Avatar       string     `json:"avatar,omitempty"`
+}
+
+type exportChat struct {
+	ID        string    `json:"id"`
+	Title     *string   `json:"title"`
+	IsGroup   bool      `json:"isGroup"`
+	CreatedAt time.Time `json:"createdAt"`
+	MemberIDs []string  `json:"memberIds"`
+}
+
+// exportUserData streams a ZIP with the caller's profile, the chats they
+// belong to, the full ciphertext history of those chats and every attachment
+// blob. Nothing is decrypted: the archive is meant to be opened by the client
+// that holds the keys.
+func exportUserData(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		u, err := st.Users.Get(ctx, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": "not found"}); return }
+		profile := exportProfile{ID: u.ID, Username: u.Username, DisplayName: u.DisplayName, PublicKey: u.PublicKey, IsAdmin: u.IsAdmin, CreatedAt: u.CreatedAt}
+		if u.LastActiveAt.Valid { profile.LastActiveAt = &u.LastActiveAt.Time }
+		if u.AvatarPath.Valid && u.AvatarPath.String != "" { profile.Avatar = "avatar" + filepath.Ext(u.AvatarPath.String) }
+
+		chats, err := exportChats(ctx, st, uid)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "db"}); return }
+
+		c.Header("Content-Type", "application/zip")
+		// Usernames are free text; FormatMediaType quotes or RFC 2231-encodes them.
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": "export-" + profile.Username + ".zip"})
+		if disposition == "" { disposition = `attachment; filename="export.zip"` }
+		c.Header("Content-Disposition", disposition)
+		clearWriteDeadline(c)
+		c.Status(http.StatusOK)
+		zw := zip.NewWriter(c.Writer)
+		defer zw.Close()
+
+		// Headers are already sent, so failures past this point can only
+		// truncate the archive; they are recorded on the context.
+		if err := writeZipJSON(zw, "profile.json", profile); err != nil { _ = c.Error(err); return }
+		if profile.Avatar != "" {
+			if err := writeZipFile(zw, profile.Avatar, u.AvatarPath.String); err != nil { _ = c.Error(err) }
+		}
+		if err := writeZipJSON(zw, "chats.json", chats); err != nil { _ = c.Error(err); return }
+		for _, ch := range chats {
+			if err := exportChatMessages(ctx, st.Messages, zw, ch.ID, uid); err != nil { _ = c.Error(err); return }
+		}
+	}
+}
+
+func exportChats(ctx context.Context, st *store.Store, uid string) ([]exportChat, error) {
+	list, err := st.Chats.List(ctx, uid)
+	if err != nil { return nil, err }
+	chats := []exportChat{}
+	for _, ch := range list {
+		e := exportChat{ID: ch.ID, IsGroup: ch.IsGroup, CreatedAt: ch.CreatedAt}
+		if ch.Title.Valid { e.Title = &ch.Title.String }
+		if e.MemberIDs, err = st.Chats.MemberIDs(ctx, ch.ID); err != nil { return nil, err }
+		chats = append(chats, e)
+	}
+	return chats, nil
+}
+
+// exportChatMessages writes messages/<chatID>.json as a JSON array, one
+// message at a time as the store yields them, then the chat's attachment
+// blobs. Only the blob paths are held in memory.
+func exportChatMessages(ctx context.Context, messages store.MessageStore, zw *zip.Writer, chatID, uid string) error {
+	w, err := zw.Create("messages/" + chatID + ".json")
+	if err != nil { return err }
+	var paths [][2]string
+	n := 0
+	err = messages.Export(ctx, chatID, uid, func(em store.ExportedMessage) error {
+		m := exportMessage{ID: em.ID, Ciphertext: em.Ciphertext, IsDeleted: em.IsDeleted, CreatedAt: em.CreatedAt, Fanout: em.Fanout, Sealed: em.Sealed}
+		if em.SenderID.Valid { m.SenderID = &em.SenderID.String }
+		if em.Nonce.Valid { m.Nonce = &em.Nonce.String }
+		if em.ReplyTo.Valid { m.ReplyTo = &em.ReplyTo.String }
+		if em.EditedAt.Valid { m.EditedAt = &em.EditedAt.Time }
+		for _, f := range em.Files {
+			a := exportAttachment{ID: f.ID, ContentType: f.ContentType, SizeBytes: f.SizeBytes, File: "attachments/" + f.ID + filepath.Ext(f.FilePath)}
+			paths = append(paths, [2]string{a.File, f.FilePath})
+			m.Attachments = append(m.Attachments, a)
+		}
+		for _, e := range em.Envelopes { m.Envelopes = append(m.Envelopes, exportEnvelope(e)) }
+		b, err := json.MarshalIndent(m, "  ", "  ")
+		if err != nil { return err }
+		sep := ",\n  "
+		if n == 0 { sep = "[\n  " }
+		n++
+		if _, err := io.WriteString(w, sep); err != nil { return err }
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil { return err }
+	end := "\n]\n"
+	if n == 0 { end = "[]\n" }
+	if _, err := io.WriteString(w, end); err != nil { return err }
+	for _, p := range paths {
+		// A blob removed from disk should not abort the rest of the export.
+		if err := writeZipFile(zw, p[0], p[1]); err != nil && !os.IsNotExist(err) { return err }
+	}
+	return nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil { return err }
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeZipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil { return err }
+	defer f.Close()
+	// Attachments are client-side encrypted, so compressing them is wasted work.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil { return err }
+	_, err = io.Copy(w, f)
+	return err
+}
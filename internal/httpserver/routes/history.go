@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"messagingapi/internal/store"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// registerHistoryRoutes mounts message history under /chats.
+func registerHistoryRoutes(r *gin.RouterGroup, st *store.Store) {
+	// Newest first; pass nextCursor back as ?before= for older pages.
+	r.GET("/:id/messages", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		if !isChatMember(c, st, chatID, uid) { return }
+		device, ok := requestDevice(c, st.Devices)
+		if !ok { return }
+		limit := pageSize(c)
+		before, err := parseCursor(c.Query("before"), time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC), uuid.Max.String())
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid cursor"}); return }
+		messages, err := st.Messages.History(c.Request.Context(), chatID, device, before.at, before.id, limit)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, m := range messages { list = append(list, messageJSON(m)) }
+		resp := gin.H{"messages": list}
+		if len(messages) == limit { last := messages[len(messages)-1]; resp["nextCursor"] = messageCursor{at: last.CreatedAt, id: last.ID}.String() }
+		c.JSON(http.StatusOK, resp)
+	})
+
+	// Every member's devices, for addressing fan-out envelopes.
+	r.GET("/:id/devices", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		chatID := c.Param("id")
+		if !isChatMember(c, st, chatID, uid) { return }
+		devices, err := st.Chats.MemberDevices(c.Request.Context(), chatID)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, d := range devices { list = append(list, gin.H{"userId": d.UserID, "deviceId": d.DeviceID}) }
+		c.JSON(http.StatusOK, gin.H{"devices": list})
+	})
+}
+
+// registerSyncRoutes mounts GET /messages/sync: messages from all of the
+// caller's chats in commit order. Every message carries the cursor just
+// after it; pass one back as ?after= to resume there.
+func registerSyncRoutes(r *gin.RouterGroup, st *store.Store) {
+	r.GET("/sync", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		device, ok := requestDevice(c, st.Devices)
+		if !ok { return }
+		limit := pageSize(c)
+		after, err := parseSyncCursor(c.Query("after"))
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid cursor"}); return }
+		messages, err := st.Messages.Sync(c.Request.Context(), uid, device, after, limit)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, m := range messages {
+			item := messageJSON(m)
+			item["cursor"] = syncCursor(m.Position)
+			list = append(list, item)
+		}
+		resp := gin.H{"messages": list}
+		if len(messages) == limit { resp["nextCursor"] = syncCursor(messages[len(messages)-1].Position) }
+		c.JSON(http.StatusOK, resp)
+	})
+}
+
+// syncCursor encodes a sync position as "<tx>.<seq>".
+func syncCursor(p store.SyncPosition) string { return fmt.Sprintf("%d.%d", p.Tx, p.Seq) }
+
+func parseSyncCursor(s string) (store.SyncPosition, error) {
+	if s == "" { return store.SyncPosition{}, nil }
+	tx, seq, found := strings.Cut(s, ".")
+	if !found { return store.SyncPosition{}, fmt.Errorf("invalid cursor") }
+	var p store.SyncPosition
+	var err error
+	if p.Tx, err = strconv.ParseInt(tx, 10, 64); err != nil || p.Tx < 0 { return store.SyncPosition{}, fmt.Errorf("invalid cursor") }
+	if p.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil || p.Seq < 0 { return store.SyncPosition{}, fmt.Errorf("invalid cursor") }
+	return p, nil
+}
+
+// messageCursor is a position in history's (created_at, id) order, encoded
+// as "<unix micros>.<id>".
+type messageCursor struct {
+	at time.Time
+	id string
+}
+
+func (m messageCursor) String() string { return fmt.Sprintf("%d.%s", m.at.UnixMicro(), m.id) }
+
+func parseCursor(s string, defAt time.Time, defID string) (messageCursor, error) {
+	if s == "" { return messageCursor{at: defAt, id: defID}, nil }
+	micros, id, found := strings.Cut(s, ".")
+	if !found { return messageCursor{}, fmt.Errorf("invalid cursor") }
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil { return messageCursor{}, err }
+	if _, err := uuid.Parse(id); err != nil { return messageCursor{}, err }
+	return messageCursor{at: time.UnixMicro(n), id: id}, nil
+}
+
+func pageSize(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 { return defaultPageSize }
+	if limit > maxPageSize { return maxPageSize }
+	return limit
+}
+
+func messageJSON(m store.Message) gin.H {
+	item := gin.H{"id": m.ID, "chatId": m.ChatID, "ciphertext": m.Ciphertext, "isDeleted": m.IsDeleted, "fanout": m.Fanout, "sealed": m.Sealed, "createdAt": m.CreatedAt, "attachments": m.Attachments}
+	if m.SenderID.Valid { item["senderId"] = m.SenderID.String }
+	if m.Nonce.Valid { item["nonce"] = m.Nonce.String }
+	if m.ReplyTo.Valid { item["replyToId"] = m.ReplyTo.String }
+	if m.EditedAt.Valid { item["editedAt"] = m.EditedAt.Time }
+	if m.Event != nil { item["event"] = m.Event }
+	return item
+}
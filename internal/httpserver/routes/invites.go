@@ -2,54 +2,147 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"messagingapi/internal/config"
+	"messagingapi/internal/store"
 )
 
 type createInviteRequest struct {
 	Code     string     `json:"code"`
 	MaxUses  int        `json:"maxUses"`
 	ExpiresAt *time.Time `json:"expiresAt"`
+	// ChatID optionally adds the registered user to a chat the creator
+	// manages, as Role and subject to RequiresApproval.
+	ChatID           *string `json:"chatId"`
+	Role             string  `json:"role"`
+	RequiresApproval bool    `json:"requiresApproval"`
 }
 
-func RegisterInviteRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
+type updateInviteRequest struct {
+	Active    *bool      `json:"active"`
+	MaxUses   *int       `json:"maxUses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	NoExpiry  bool       `json:"noExpiry"`
+}
+
+func RegisterInviteRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	// Admins create invites freely. Other users draw from an allowance of
+	// registration slots (config.UserInviteQuota or users.invite_quota) and
+	// their invites expire within config.UserInviteTTL.
 	r.POST("/", func(c *gin.Context) {
 		uid := c.GetString("userID")
-		var isAdmin bool
-		if err := db.QueryRow(`SELECT is_admin FROM users WHERE id=$1`, uid).Scan(&isAdmin); err != nil || !isAdmin { c.JSON(http.StatusForbidden, gin.H{"error":"admin only"}); return }
 		var req createInviteRequest
 		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
 		if req.MaxUses <= 0 { req.MaxUses = 1 }
 		code := req.Code
 		if code == "" { code = uuid.NewString() }
-		_, err := db.Exec(`INSERT INTO invites (code, created_by, max_uses, uses, active, expires_at) VALUES ($1,$2,$3,0,true,$4)`, code, uid, req.MaxUses, req.ExpiresAt)
-		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"duplicate code?"}); return }
-		c.JSON(http.StatusOK, gin.H{"code": code})
+		if req.Role == "" { req.Role = roleMember }
+		if req.Role != roleMember && req.Role != roleAdmin { c.JSON(http.StatusBadRequest, gin.H{"error":"role must be member or admin"}); return }
+		if req.ChatID != nil && !isChatManager(c.Request.Context(), st, *req.ChatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"chat admin only"}); return }
+		if req.ChatID != nil && req.Role == roleAdmin && !isChatOwner(c.Request.Context(), st, *req.ChatID, uid) { c.JSON(http.StatusForbidden, gin.H{"error":"only the chat owner can invite admins"}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		// Locking the creator's row serialises concurrent quota checks.
+		creator, err := st.Users.Lock(ctx, uid)
+		if err != nil { c.JSON(http.StatusForbidden, gin.H{"error":"forbidden"}); return }
+		if !creator.IsAdmin {
+			allowance := inviteAllowance(cfg, creator.InviteQuota)
+			if allowance <= 0 { c.JSON(http.StatusForbidden, gin.H{"error":"invites not allowed"}); return }
+			used, err := st.Invites.SlotsUsed(ctx, uid)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+			if used+req.MaxUses > allowance { c.JSON(http.StatusForbidden, gin.H{"error":"invite quota exceeded", "remaining": max(allowance-used, 0)}); return }
+			maxExpiry := time.Now().Add(cfg.UserInviteTTL)
+			if req.ExpiresAt == nil || req.ExpiresAt.After(maxExpiry) { req.ExpiresAt = &maxExpiry }
+		}
+		id, err := st.Invites.Create(ctx, store.NewInvite{Code: code, CreatedBy: uid, MaxUses: req.MaxUses, ExpiresAt: req.ExpiresAt, ChatID: req.ChatID, Role: req.Role, RequiresApproval: req.RequiresApproval})
+		if errors.Is(err, store.ErrConflict) { c.JSON(http.StatusBadRequest, gin.H{"error":"duplicate code"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create invite"}); return }
+		if err := st.Audit.Record(ctx, uid, "invite.create", "invite", id, gin.H{"code": code, "maxUses": req.MaxUses, "expiresAt": req.ExpiresAt, "chatId": req.ChatID}); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"audit"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		resp := gin.H{"id": id, "code": code}
+		if req.ExpiresAt != nil { resp["expiresAt"] = *req.ExpiresAt }
+		c.JSON(http.StatusOK, resp)
 	})
 
+	// Admins see every invite; other users see their own plus their quota.
 	r.GET("/", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		uid := c.GetString("userID")
-		var isAdmin bool
-		if err := db.QueryRow(`SELECT is_admin FROM users WHERE id=$1`, uid).Scan(&isAdmin); err != nil || !isAdmin { c.JSON(http.StatusForbidden, gin.H{"error":"admin only"}); return }
-		rows, err := db.Query(`SELECT code, max_uses, uses, active, expires_at FROM invites ORDER BY created_at DESC`)
+		u, err := st.Users.Get(ctx, uid)
+		if err != nil { c.JSON(http.StatusForbidden, gin.H{"error":"forbidden"}); return }
+		invites, err := st.Invites.List(ctx, u.IsAdmin, uid)
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
-		defer rows.Close()
 		var list []gin.H
-		for rows.Next() {
-			var code string
-			var maxUses, uses int
-			var active bool
-			var expiresAt sql.NullTime
-			if err := rows.Scan(&code, &maxUses, &uses, &active, &expiresAt); err == nil {
-				item := gin.H{"code": code, "maxUses": maxUses, "uses": uses, "active": active}
-				if expiresAt.Valid { item["expiresAt"] = expiresAt.Time }
-				list = append(list, item)
-			}
+		for _, inv := range invites {
+			item := gin.H{"id": inv.ID, "code": inv.Code, "maxUses": inv.MaxUses, "uses": inv.Uses, "active": inv.Active, "kind": inv.Kind}
+			if inv.ExpiresAt.Valid { item["expiresAt"] = inv.ExpiresAt.Time }
+			if inv.ChatID.Valid { item["chatId"] = inv.ChatID.String; item["requiresApproval"] = inv.RequiresApproval }
+			list = append(list, item)
+		}
+		resp := gin.H{"invites": list}
+		if !u.IsAdmin {
+			allowance := inviteAllowance(cfg, u.InviteQuota)
+			used, err := st.Invites.SlotsUsed(ctx, uid)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+			resp["quota"] = gin.H{"allowance": allowance, "used": used, "remaining": max(allowance-used, 0)}
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+
+	r.PATCH("/:id", requireAdmin(st.Users), func(c *gin.Context) {
+		uid := c.GetString("userID")
+		id := c.Param("id")
+		var req updateInviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if req.MaxUses != nil && *req.MaxUses <= 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"maxUses must be positive"}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		err = st.Invites.Update(ctx, id, store.InviteUpdate{Active: req.Active, MaxUses: req.MaxUses, ExpiresAt: req.ExpiresAt, NoExpiry: req.NoExpiry})
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"update"}); return }
+		if err := st.Audit.Record(ctx, uid, "invite.update", "invite", id, gin.H{"active": req.Active, "maxUses": req.MaxUses, "expiresAt": req.ExpiresAt, "noExpiry": req.NoExpiry}); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"audit"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// Deleting an invite keeps the users it registered; their invite_id is
+	// cleared, so deactivate instead when attribution should be preserved.
+	r.DELETE("/:id", requireAdmin(st.Users), func(c *gin.Context) {
+		uid := c.GetString("userID")
+		id := c.Param("id")
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		inv, err := st.Invites.Delete(ctx, id)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if err := st.Audit.Record(ctx, uid, "invite.delete", "invite", id, gin.H{"code": inv.Code, "uses": inv.Uses}); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"audit"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	r.GET("/:id/usage", requireAdmin(st.Users), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := c.Param("id")
+		inv, err := st.Invites.Get(ctx, id)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		registered, err := st.Invites.Registrations(ctx, id)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		users := []gin.H{}
+		for _, u := range registered {
+			users = append(users, gin.H{"id": u.ID, "username": u.Username, "displayName": u.DisplayName, "registeredAt": u.CreatedAt})
 		}
-		c.JSON(http.StatusOK, gin.H{"invites": list})
+		c.JSON(http.StatusOK, gin.H{"id": id, "code": inv.Code, "maxUses": inv.MaxUses, "uses": inv.Uses, "users": users})
 	})
-}
\ No newline at end of file
+}
+
+func inviteAllowance(cfg config.Config, override sql.NullInt64) int {
+	if override.Valid { return int(override.Int64) }
+	return cfg.UserInviteQuota
+}
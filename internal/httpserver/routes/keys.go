@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+type rotateKeyRequest struct {
+	PIN       string `json:"pin" binding:"required"`
+	PublicKey string `json:"publicKey" binding:"required"`
+}
+
+type verifyContactRequest struct {
+	// PublicKey is the key the client compared safety numbers for. It must
+	// still be the contact's current key.
+	PublicKey string `json:"publicKey" binding:"required"`
+}
+
+// registerKeyRoutes mounts identity key rotation and lookup and the
+// per-contact verification store under /users.
+func registerKeyRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	// Replacing the identity key posts an identity_key_changed event to every
+	// chat the user is in, so peers can warn before trusting the new key. The
+	// PIN check shares the failed-attempt counter of every other PIN check.
+	r.PUT("/me/key", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req rotateKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if _, ok := checkCredentials(c, st, cfg, uid, "", req.PIN); !ok { return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		u, err := st.Users.Lock(ctx, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		current := u.PublicKey
+		if req.PublicKey == current { c.JSON(http.StatusOK, gin.H{"fingerprint": keyFingerprint(current), "changed": false}); return }
+		changedAt, err := st.Users.SetPublicKey(ctx, uid, req.PublicKey)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		chatIDs, err := st.Chats.ChatIDs(ctx, uid)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		event := gin.H{"type": "identity_key_changed", "userId": uid, "fingerprint": keyFingerprint(req.PublicKey)}
+		if current != "" { event["previousFingerprint"] = keyFingerprint(current) }
+		for _, chatID := range chatIDs {
+			if err := st.Chats.PostEvent(ctx, chatID, uid, event); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"event"}); return }
+		}
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"fingerprint": keyFingerprint(req.PublicKey), "changed": true, "changedAt": changedAt, "chatsNotified": len(chatIDs)})
+	})
+
+	// Keys are only visible to the owner and to users sharing a chat.
+	r.GET("/:id/key", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		contact := c.Param("id")
+		if contact != uid && !shareChat(ctx, st, uid, contact) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		k, err := st.Contacts.Key(ctx, uid, contact)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		resp := gin.H{"userId": contact, "publicKey": k.PublicKey, "fingerprint": keyFingerprint(k.PublicKey), "verified": k.VerifiedKey.Valid && k.VerifiedKey.String == k.PublicKey}
+		if k.ChangedAt.Valid { resp["changedAt"] = k.ChangedAt.Time }
+		if k.VerifiedAt.Valid { resp["verifiedAt"] = k.VerifiedAt.Time }
+		c.JSON(http.StatusOK, resp)
+	})
+
+	r.GET("/me/verifications", func(c *gin.Context) {
+		verifications, err := st.Contacts.Verifications(c.Request.Context(), c.GetString("userID"))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, v := range verifications {
+			// A stale entry means the contact changed keys since verification.
+			list = append(list, gin.H{"contactId": v.ContactID, "verified": v.Current, "verifiedAt": v.VerifiedAt})
+		}
+		c.JSON(http.StatusOK, gin.H{"verifications": list})
+	})
+
+	r.PUT("/me/verifications/:contactId", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		contact := c.Param("contactId")
+		var req verifyContactRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if contact == uid || !shareChat(ctx, st, uid, contact) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		err := st.Contacts.Verify(ctx, uid, contact, req.PublicKey)
+		if errors.Is(err, store.ErrConflict) { c.JSON(http.StatusConflict, gin.H{"error":"key changed"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"verified": true, "fingerprint": keyFingerprint(req.PublicKey)})
+	})
+
+	r.DELETE("/me/verifications/:contactId", func(c *gin.Context) {
+		if err := st.Contacts.Unverify(c.Request.Context(), c.GetString("userID"), c.Param("contactId")); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"verified": false})
+	})
+}
+
+// keyFingerprint is a short, stable identifier for a public key, used in
+// key-change events. Safety numbers themselves are computed by clients.
+func keyFingerprint(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// shareChat reports whether uid and other are members of a common chat.
+func shareChat(ctx context.Context, st *store.Store, uid, other string) bool {
+	ok, err := st.Chats.ShareChat(ctx, uid, other)
+	return err == nil && ok
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestLog returns the default logger annotated with the request ID and,
+// once authenticated, the user ID.
+func requestLog(c *gin.Context) *slog.Logger {
+	l := slog.Default().With("request_id", c.GetString("requestID"))
+	if uid := c.GetString("userID"); uid != "" {
+		l = l.With("user_id", uid)
+	}
+	return l
+}
+
+// removeFile deletes a stored upload, logging failures other than the file
+// already being gone.
+func removeFile(c *gin.Context, path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		requestLog(c).Warn("removing file failed", "path", path, "err", err)
+	}
+}
@@ -1,23 +1,36 @@
 package routes
 
 import (
-	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"messagingapi/internal/config"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
 )
 
+// maxEnvelopes bounds the devices a single fan-out message can address.
+const maxEnvelopes = 1000
+
 type sendMessageRequest struct {
 	ChatID   string `form:"chatId" binding:"required"`
-	Cipher   string `form:"ciphertext" binding:"required"`
+	Cipher   string `form:"ciphertext"`
 	Nonce    string `form:"nonce"`
 	ReplyTo  string `form:"replyToId"`
+	// Envelopes is a JSON array of {deviceId, ciphertext}, sent instead of
+	// ciphertext for pairwise messages such as sender-key distribution.
+	Envelopes string `form:"envelopes"`
+}
+
+type messageEnvelope struct {
+	DeviceID   string `json:"deviceId" binding:"required"`
+	Ciphertext string `json:"ciphertext" binding:"required"`
 }
 
 type editMessageRequest struct {
@@ -25,60 +38,104 @@ type editMessageRequest struct {
 	Nonce  string `json:"nonce"`
 }
 
-func RegisterMessageRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
+func RegisterMessageRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
 	r.POST("/", func(c *gin.Context) {
 		uid := c.GetString("userID")
 		var req sendMessageRequest
 		if err := c.ShouldBind(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
-		// Ensure membership
-		var member int
-		if err := db.QueryRow(`SELECT COUNT(*) FROM chat_members WHERE chat_id=$1 AND user_id=$2`, req.ChatID, uid).Scan(&member); err != nil || member == 0 {
-			c.JSON(http.StatusForbidden, gin.H{"error":"not member"}); return
-		}
-		var replyTo *uuid.UUID
-		if req.ReplyTo != "" { if id, err := uuid.Parse(req.ReplyTo); err == nil { replyTo = &id } }
-		var msgID uuid.UUID
-		err := db.QueryRow(`INSERT INTO messages (chat_id, sender_id, ciphertext, nonce, reply_to) VALUES ($1,$2,$3,$4,$5) RETURNING id`, req.ChatID, uid, req.Cipher, req.Nonce, replyTo).Scan(&msgID)
-		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create message"}); return }
-		form, _ := c.MultipartForm()
-		if form != nil {
-			files := form.File["files"]
+		if !isChatMember(c, st, req.ChatID, uid) { return }
+		var envelopes []messageEnvelope
+		if req.Envelopes != "" {
+			if req.Cipher != "" { c.JSON(http.StatusBadRequest, gin.H{"error":"send either ciphertext or envelopes"}); return }
+			if err := json.Unmarshal([]byte(req.Envelopes), &envelopes); err != nil || len(envelopes) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid envelopes"}); return }
+			if len(envelopes) > maxEnvelopes { c.JSON(http.StatusBadRequest, gin.H{"error":"too many envelopes"}); return }
+			for _, e := range envelopes {
+				if _, err := uuid.Parse(e.DeviceID); err != nil || e.Ciphertext == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid envelopes"}); return }
+			}
+		} else if req.Cipher == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"ciphertext required"}); return }
+		var replyTo *string
+		if id, err := uuid.Parse(req.ReplyTo); err == nil { s := id.String(); replyTo = &s }
+		// Files are written before the transaction and recorded inside it, so
+		// Sync never hands out a message whose attachments are missing. Until
+		// the commit, a failure removes whatever was written.
+		var files []*multipart.FileHeader
+		if form, _ := c.MultipartForm(); form != nil { files = form.File["files"] }
+		var paths []string
+		committed := false
+		defer func() {
+			if committed { return }
+			for _, p := range paths { removeFile(c, p) }
+		}()
+		if len(files) > 0 {
+			dir := filepath.Join(cfg.DataDir, "uploads", req.ChatID)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				requestLog(c).Error("creating upload dir failed", "err", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error":"save attachment"}); return
+			}
 			for _, f := range files {
-				dir := filepath.Join(cfg.DataDir, "uploads", req.ChatID)
-				_ = os.MkdirAll(dir, 0755)
-				name := fmt.Sprintf("%s-%d%s", msgID.String(), time.Now().UnixNano(), filepath.Ext(f.Filename))
-				path := filepath.Join(dir, name)
-				if err := c.SaveUploadedFile(f, path); err == nil {
-					_, _ = db.Exec(`INSERT INTO attachments (message_id, file_path, content_type, size_bytes) VALUES ($1,$2,$3,$4)`, msgID, path, f.Header.Get("Content-Type"), f.Size)
+				path := filepath.Join(dir, uuid.NewString()+filepath.Ext(f.Filename))
+				if err := saveUpload(c, "attachment", f, path); err != nil {
+					requestLog(c).Error("saving attachment failed", "err", err)
+					removeFile(c, path)
+					c.JSON(http.StatusInternalServerError, gin.H{"error":"save attachment"}); return
 				}
+				paths = append(paths, path)
 			}
 		}
-		c.JSON(http.StatusOK, gin.H{"messageId": msgID.String()})
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		msgID, err := st.Messages.Create(ctx, store.NewMessage{ChatID: req.ChatID, SenderID: uid, Ciphertext: req.Cipher, Nonce: req.Nonce, ReplyTo: replyTo, Fanout: len(envelopes) > 0})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create message"}); return }
+		for _, e := range envelopes {
+			// Envelopes may only address devices of the chat's members.
+			err := st.Messages.AddEnvelope(ctx, msgID, req.ChatID, e.DeviceID, e.Ciphertext)
+			if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid envelopes"}); return }
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		}
+		for i, f := range files {
+			if _, err := st.Attachments.Create(ctx, msgID, paths[i], f.Header.Get("Content-Type"), f.Size); err != nil {
+				requestLog(c).Error("recording attachment failed", "message_id", msgID, "err", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error":"save attachment"}); return
+			}
+		}
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		committed = true
+		for _, f := range files { metrics.Upload("attachment", f.Size) }
+		c.JSON(http.StatusOK, gin.H{"messageId": msgID})
 	})
 
+	registerSyncRoutes(r, st)
+
 	r.PATCH("/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		uid := c.GetString("userID")
 		id := c.Param("id")
 		var req editMessageRequest
 		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
-		var sender string
-		if err := db.QueryRow(`SELECT sender_id FROM messages WHERE id=$1`, id).Scan(&sender); err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
-		if sender != uid { c.JSON(http.StatusForbidden, gin.H{"error":"not owner"}); return }
-		_, err := db.Exec(`UPDATE messages SET ciphertext=$1, nonce=$2, edited_at=now() WHERE id=$3`, req.Cipher, req.Nonce, id)
-		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		m, err := st.Messages.Get(ctx, id)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if m.SenderID.String != uid { c.JSON(http.StatusForbidden, gin.H{"error":"not owner"}); return }
+		if m.Fanout { c.JSON(http.StatusConflict, gin.H{"error":"fan-out messages cannot be edited"}); return }
+		if err := st.Messages.Edit(ctx, id, req.Cipher, req.Nonce); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
 		c.JSON(http.StatusOK, gin.H{"ok": true})
 	})
 
 	r.DELETE("/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		uid := c.GetString("userID")
 		id := c.Param("id")
-		var sender string
-		if err := db.QueryRow(`SELECT sender_id FROM messages WHERE id=$1`, id).Scan(&sender); err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
-		if sender != uid { c.JSON(http.StatusForbidden, gin.H{"error":"not owner"}); return }
+		m, err := st.Messages.Get(ctx, id)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if m.SenderID.String != uid { c.JSON(http.StatusForbidden, gin.H{"error":"not owner"}); return }
 		// Delete attachments files
-		rows, _ := db.Query(`SELECT file_path FROM attachments WHERE message_id=$1`, id)
-		if rows != nil { defer rows.Close(); for rows.Next() { var p string; if err := rows.Scan(&p); err == nil { _ = os.Remove(p) } } }
-		_, _ = db.Exec(`DELETE FROM messages WHERE id=$1`, id)
+		paths, err := st.Attachments.PathsByMessage(ctx, id)
+		if err != nil { requestLog(c).Error("listing attachments failed", "message_id", id, "err", err) }
+		for _, p := range paths { removeFile(c, p) }
+		if err := st.Messages.Delete(ctx, id); err != nil {
+			requestLog(c).Error("deleting message failed", "message_id", id, "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error":"delete"}); return
+		}
 		c.JSON(http.StatusOK, gin.H{"ok": true})
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,240 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
+)
+
+const passkeyCeremonyTimeout = 5 * time.Minute
+
+// WebAuthn session purposes; a session can only finish the ceremony it began.
+const (
+	passkeyRegister     = "register"
+	passkeyLogin        = "login"
+	passkeySecondFactor = "second_factor"
+)
+
+// passkeyReauthRequest confirms a signed-in caller before a passkey is added
+// or removed, since either changes how the account can be signed in to.
+type passkeyReauthRequest struct {
+	Password string `json:"password" binding:"required"`
+	PIN      string `json:"pin" binding:"required"`
+}
+
+type finishPasskeyRegistrationRequest struct {
+	SessionID  string          `json:"sessionId" binding:"required"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type finishPasskeyLoginRequest struct {
+	SessionID  string          `json:"sessionId" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+	// ChallengeToken is only used when the passkey is the second factor.
+	ChallengeToken string `json:"challengeToken"`
+}
+
+type beginPasskeySecondFactorRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+}
+
+// NewPasskeys builds the WebAuthn relying party from cfg. It returns nil
+// when WEBAUTHN_RP_ID is not configured, which disables the passkey routes.
+func NewPasskeys(cfg config.Config) (*auth.Passkeys, error) {
+	if cfg.WebAuthnRPID == "" { return nil, nil }
+	return auth.NewPasskeys(cfg.WebAuthnRPID, cfg.WebAuthnRPName, cfg.WebAuthnRPOrigins, passkeyCeremonyTimeout)
+}
+
+// requirePasskeys rejects requests while passkeys are not configured.
+func requirePasskeys(pk *auth.Passkeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if pk == nil { c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error":"passkeys not configured"}); return }
+		c.Next()
+	}
+}
+
+// registerPasskeyRoutes mounts passkey management under /users/me/passkeys.
+// Registering and removing a passkey both require the password and PIN;
+// finishing a registration relies on the session begin handed out.
+func registerPasskeyRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config, pk *auth.Passkeys) {
+	r.Use(requirePasskeys(pk))
+
+	r.GET("", func(c *gin.Context) {
+		passkeys, err := st.Passkeys.List(c.Request.Context(), c.GetString("userID"))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		list := []gin.H{}
+		for _, p := range passkeys {
+			item := gin.H{"id": p.ID, "name": p.Name, "userVerified": p.UserVerified, "synced": p.BackupState, "createdAt": p.CreatedAt}
+			if p.LastUsedAt.Valid { item["lastUsedAt"] = p.LastUsedAt.Time }
+			list = append(list, item)
+		}
+		c.JSON(http.StatusOK, gin.H{"passkeys": list})
+	})
+
+	r.POST("/register/begin", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		var req passkeyReauthRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if _, ok := checkCredentials(c, st, cfg, uid, req.Password, req.PIN); !ok { return }
+		user, err := loadPasskeyUser(ctx, st, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		options, session, err := pk.BeginRegistration(user)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"webauthn"}); return }
+		sessionID, err := saveWebAuthnSession(ctx, st.Passkeys, uid, passkeyRegister, session)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"sessionId": sessionID, "options": options})
+	})
+
+	r.POST("/register/finish", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		var req finishPasskeyRegistrationRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		owner, session, err := takeWebAuthnSession(ctx, st.Passkeys, req.SessionID, passkeyRegister)
+		if err != nil || owner != uid { c.JSON(http.StatusBadRequest, gin.H{"error":"invalid session"}); return }
+		user, err := loadPasskeyUser(ctx, st, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		cred, err := pk.FinishRegistration(user, session, bytes.NewReader(req.Credential))
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": webauthnError(err)}); return }
+		transports := make([]string, len(cred.Transport))
+		for i, t := range cred.Transport { transports[i] = string(t) }
+		id, err := st.Passkeys.Create(ctx, store.Passkey{
+			UserID: uid, Name: req.Name, CredentialID: cred.ID, PublicKey: cred.PublicKey, AttestationType: cred.AttestationType, Transports: transports,
+			AAGUID: cred.Authenticator.AAGUID, SignCount: cred.Authenticator.SignCount,
+			UserVerified: cred.Flags.UserVerified, BackupEligible: cred.Flags.BackupEligible, BackupState: cred.Flags.BackupState,
+		})
+		if errors.Is(err, store.ErrConflict) { c.JSON(http.StatusConflict, gin.H{"error":"credential already registered"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"id": id})
+	})
+
+	r.DELETE("/:id", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req passkeyReauthRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if _, ok := checkCredentials(c, st, cfg, uid, req.Password, req.PIN); !ok { return }
+		err := st.Passkeys.Delete(c.Request.Context(), uid, c.Param("id"))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+// registerPasskeyLoginRoutes mounts the unauthenticated passkey ceremonies
+// under /auth: /passkey/* replaces password and PIN entirely, while
+// /login/passkey/* completes a password login as its second factor.
+func registerPasskeyLoginRoutes(r *gin.RouterGroup, st *store.Store, keys *auth.KeySet, pk *auth.Passkeys) {
+	g := r.Group("", requirePasskeys(pk))
+
+	g.POST("/passkey/begin", func(c *gin.Context) {
+		options, session, err := pk.BeginDiscoverableLogin()
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"webauthn"}); return }
+		sessionID, err := saveWebAuthnSession(c.Request.Context(), st.Passkeys, "", passkeyLogin, session)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"sessionId": sessionID, "options": options})
+	})
+
+	g.POST("/passkey/finish", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var req finishPasskeyLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		_, session, err := takeWebAuthnSession(ctx, st.Passkeys, req.SessionID, passkeyLogin)
+		if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid session"}); return }
+		user, cred, err := pk.FinishDiscoverableLogin(func(handle []byte) (*auth.PasskeyUser, error) {
+			return loadPasskeyUser(ctx, st, string(handle))
+		}, session, bytes.NewReader(req.Credential))
+		if err != nil { metrics.LoginFailures.WithLabelValues("passkey", "invalid_assertion").Inc(); c.JSON(http.StatusUnauthorized, gin.H{"error": webauthnError(err)}); return }
+		if err := recordPasskeyUse(ctx, st.Passkeys, cred); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		issueAccessToken(c, st, keys, user.ID, "")
+	})
+
+	g.POST("/login/passkey/begin", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var req beginPasskeySecondFactorRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		claims, err := keys.ParseChallengeJWT(req.ChallengeToken)
+		if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid challenge"}); return }
+		user, err := loadPasskeyUser(ctx, st, claims.UserID)
+		if err != nil || len(user.Credentials) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"no passkeys"}); return }
+		options, session, err := pk.BeginLogin(user)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"webauthn"}); return }
+		sessionID, err := saveWebAuthnSession(ctx, st.Passkeys, claims.UserID, passkeySecondFactor, session)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"sessionId": sessionID, "options": options})
+	})
+
+	g.POST("/login/passkey/finish", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var req finishPasskeyLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		claims, err := keys.ParseChallengeJWT(req.ChallengeToken)
+		if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid challenge"}); return }
+		owner, session, err := takeWebAuthnSession(ctx, st.Passkeys, req.SessionID, passkeySecondFactor)
+		if err != nil || owner != claims.UserID { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid session"}); return }
+		user, err := loadPasskeyUser(ctx, st, claims.UserID)
+		if err != nil { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid challenge"}); return }
+		cred, err := pk.FinishLogin(user, session, bytes.NewReader(req.Credential))
+		if err != nil { metrics.LoginFailures.WithLabelValues("passkey", "invalid_assertion").Inc(); c.JSON(http.StatusUnauthorized, gin.H{"error": webauthnError(err)}); return }
+		if err := recordPasskeyUse(ctx, st.Passkeys, cred); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		if !spendChallenge(c, st, claims) { return }
+		issueAccessToken(c, st, keys, claims.UserID, "")
+	})
+}
+
+func loadPasskeyUser(ctx context.Context, st *store.Store, uid string) (*auth.PasskeyUser, error) {
+	u, err := st.Users.Get(ctx, uid)
+	if err != nil { return nil, err }
+	passkeys, err := st.Passkeys.List(ctx, uid)
+	if err != nil { return nil, err }
+	user := &auth.PasskeyUser{ID: uid, Name: u.Username, DisplayName: u.DisplayName}
+	for _, p := range passkeys {
+		cred := webauthn.Credential{ID: p.CredentialID, PublicKey: p.PublicKey, AttestationType: p.AttestationType}
+		cred.Authenticator.AAGUID = p.AAGUID
+		cred.Authenticator.SignCount = p.SignCount
+		cred.Flags.UserVerified, cred.Flags.BackupEligible, cred.Flags.BackupState = p.UserVerified, p.BackupEligible, p.BackupState
+		for _, t := range p.Transports { cred.Transport = append(cred.Transport, protocol.AuthenticatorTransport(t)) }
+		user.Credentials = append(user.Credentials, cred)
+	}
+	return user, nil
+}
+
+// recordPasskeyUse persists the sign counter and flags from a verified
+// assertion.
+func recordPasskeyUse(ctx context.Context, passkeys store.PasskeyStore, cred *webauthn.Credential) error {
+	return passkeys.RecordUse(ctx, cred.ID, cred.Authenticator.SignCount, cred.Flags.UserVerified, cred.Flags.BackupState)
+}
+
+func saveWebAuthnSession(ctx context.Context, passkeys store.PasskeyStore, uid, purpose string, session *webauthn.SessionData) (string, error) {
+	b, err := json.Marshal(session)
+	if err != nil { return "", err }
+	return passkeys.SaveSession(ctx, uid, purpose, b, time.Now().Add(passkeyCeremonyTimeout))
+}
+
+// takeWebAuthnSession consumes a stored ceremony so a challenge can only be
+// answered once. owner is "" for discoverable logins.
+func takeWebAuthnSession(ctx context.Context, passkeys store.PasskeyStore, id, purpose string) (owner string, session webauthn.SessionData, err error) {
+	w, err := passkeys.TakeSession(ctx, id, purpose)
+	if err != nil { return "", session, err }
+	err = json.Unmarshal(w.Data, &session)
+	return w.UserID.String, session, err
+}
+
+func webauthnError(err error) string {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.Details != "" { return perr.Details }
+	if errors.Is(err, auth.ErrPasskeyCloned) { return "passkey may be cloned" }
+	return "passkey verification failed"
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+// accessKeyHeader carries the recipient's access key on sealed sends, in
+// place of the sender's JWT.
+const accessKeyHeader = "Unidentified-Access-Key"
+
+const (
+	deliveryTokenTTL   = 7 * 24 * time.Hour
+	maxTokensPerRequest = 100
+)
+
+type setAccessKeyRequest struct {
+	// AccessKey is derived by the client and shared with its contacts
+	// inside E2E messages. Empty disables sealed delivery.
+	AccessKey string `json:"accessKey"`
+}
+
+type issueDeliveryTokensRequest struct {
+	Count int `json:"count" binding:"required,min=1"`
+}
+
+type sealedMessageRequest struct {
+	ChatID        string `json:"chatId" binding:"required"`
+	RecipientID   string `json:"recipientId" binding:"required"`
+	Ciphertext    string `json:"ciphertext" binding:"required"`
+	Nonce         string `json:"nonce"`
+	DeliveryToken string `json:"deliveryToken" binding:"required"`
+}
+
+// registerSealedSenderRoutes mounts the authenticated half of sealed sender
+// under /users/me: setting the access key and fetching delivery tokens.
+func registerSealedSenderRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	r.PUT("/sealed-sender", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req setAccessKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		hash := ""
+		if req.AccessKey != "" {
+			if len(req.AccessKey) < 16 { c.JSON(http.StatusBadRequest, gin.H{"error":"access key too short"}); return }
+			hash = hashSecret(req.AccessKey)
+		}
+		if err := st.Sealed.SetAccessKey(c.Request.Context(), uid, hash); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		c.JSON(http.StatusOK, gin.H{"enabled": hash != ""})
+	})
+
+	// Tokens are counted against a daily quota but stored without the
+	// account, so a sealed message cannot be traced back through its token.
+	r.POST("/delivery-tokens", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req issueDeliveryTokensRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if req.Count > maxTokensPerRequest { req.Count = maxTokensPerRequest }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		issued, err := st.Sealed.IssuedToday(ctx, uid)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		remaining := cfg.SealedSenderDailyTokens - issued
+		if remaining <= 0 { c.JSON(http.StatusTooManyRequests, gin.H{"error":"daily delivery token quota reached"}); return }
+		if req.Count > remaining { req.Count = remaining }
+		expiresAt := time.Now().Add(deliveryTokenTTL)
+		tokens := make([]string, req.Count)
+		hashes := make([]string, req.Count)
+		for i := range tokens {
+			tokens[i] = randomSecret()
+			hashes[i] = hashSecret(tokens[i])
+		}
+		if err := st.Sealed.AddTokens(ctx, uid, hashes, expiresAt); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"tokens": tokens, "expiresAt": expiresAt, "remainingToday": remaining - req.Count})
+	})
+}
+
+// RegisterSealedRoutes mounts POST /sealed/messages. It runs outside the
+// JWT middleware: the sender proves it may reach the recipient with the
+// recipient's access key and spends a delivery token, and nothing about
+// the sender is stored.
+func RegisterSealedRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	r.POST("/messages", func(c *gin.Context) {
+		var req sealedMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		accessKey := c.GetHeader(accessKeyHeader)
+		if accessKey == "" { c.JSON(http.StatusUnauthorized, gin.H{"error":"access key required"}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		// Sealed sender is limited to 1:1 chats, where the recipient knows
+		// who the only other member is anyway.
+		keyHash, err := st.Sealed.AccessKey(ctx, req.RecipientID, req.ChatID)
+		// Unknown recipients and wrong keys are indistinguishable.
+		if err != nil || subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashSecret(accessKey))) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid access key"}); return
+		}
+		err = st.Sealed.SpendToken(ctx, hashSecret(req.DeliveryToken))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusTooManyRequests, gin.H{"error":"invalid delivery token"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		msgID, err := st.Messages.Create(ctx, store.NewMessage{ChatID: req.ChatID, Ciphertext: req.Ciphertext, Nonce: req.Nonce, Sealed: true})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"create message"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"messageId": msgID})
+	})
+}
+
+func hashSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/auth"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+const recoveryCodeCount = 10
+
+type confirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	PIN      string `json:"pin" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// registerTOTPRoutes mounts TOTP enrollment under /users/me/2fa/totp.
+func registerTOTPRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	// Starting enrollment (again) replaces any unconfirmed secret; an
+	// enabled secret has to be disabled first.
+	r.POST("", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"secret"}); return }
+		err = st.TOTP.Start(c.Request.Context(), uid, secret)
+		if errors.Is(err, store.ErrConflict) { c.JSON(http.StatusConflict, gin.H{"error":"totp already enabled"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioningUri": auth.TOTPProvisioningURI(cfg.TOTPIssuer, c.GetString("username"), secret)})
+	})
+
+	// Confirming proves the authenticator works, enables TOTP and returns
+	// the recovery codes. They are shown only this once.
+	r.POST("/confirm", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req confirmTOTPRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		t, err := st.TOTP.Lock(ctx, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if t.EnabledAt.Valid { c.JSON(http.StatusConflict, gin.H{"error":"totp already enabled"}); return }
+		if !t.Secret.Valid { c.JSON(http.StatusBadRequest, gin.H{"error":"enrollment not started"}); return }
+		step, ok := auth.ValidateTOTP(t.Secret.String, req.Code, time.Now())
+		if !ok { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid code"}); return }
+		codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"recovery codes"}); return }
+		hashes := make([]string, len(codes))
+		for i, code := range codes { hashes[i] = auth.HashRecoveryCode(code) }
+		if err := st.TOTP.Enable(ctx, uid, step, hashes); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "recoveryCodes": codes})
+	})
+
+	// Disabling requires the full set of credentials again: password, PIN
+	// and a current TOTP or recovery code.
+	r.DELETE("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		var req disableTOTPRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		u, err := st.Users.Get(ctx, uid)
+		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
+		if !u.TOTPEnabledAt.Valid { c.JSON(http.StatusConflict, gin.H{"error":"totp not enabled"}); return }
+		if _, ok := checkCredentials(c, st, cfg, uid, req.Password, req.PIN); !ok { return }
+		ok, err := verifySecondFactor(ctx, st.TOTP, uid, req.Code)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		if !ok { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid code"}); return }
+		ctx, tx, err := st.Begin(ctx)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		if err := st.TOTP.Disable(ctx, uid); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+	})
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code
+// for uid. Both are consumed: the TOTP step is recorded so the same code
+// cannot be replayed, and recovery codes are marked used.
+func verifySecondFactor(ctx context.Context, totp store.TOTPStore, uid, code string) (bool, error) {
+	t, err := totp.Get(ctx, uid)
+	if errors.Is(err, store.ErrNotFound) || err == nil && !t.EnabledAt.Valid { return false, nil }
+	if err != nil { return false, err }
+	if step, ok := auth.ValidateTOTP(t.Secret.String, code, time.Now()); ok {
+		err := totp.AdvanceStep(ctx, uid, step)
+		if errors.Is(err, store.ErrNotFound) { return false, nil }
+		return err == nil, err
+	}
+	err = totp.UseRecoveryCode(ctx, uid, auth.HashRecoveryCode(code))
+	if errors.Is(err, store.ErrNotFound) { return false, nil }
+	return err == nil, err
+}
@@ -1,15 +1,18 @@
 package routes
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"messagingapi/internal/auth"
 	"messagingapi/internal/config"
+	"messagingapi/internal/metrics"
+	"messagingapi/internal/store"
 )
 
 type changePasswordRequest struct {
@@ -19,27 +22,26 @@ type changePasswordRequest struct {
 	NewPIN      string `json:"newPin" binding:"required,min=4,max=10"`
 }
 
-func RegisterUserRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
+type deleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	PIN      string `json:"pin" binding:"required"`
+}
+
+func RegisterUserRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config, pk *auth.Passkeys) {
 	r.GET("/me", func(c *gin.Context) {
-		uid := c.GetString("userID")
-		var u struct{
-			ID string `json:"id"`
-			Username string `json:"username"`
-			DisplayName string `json:"displayName"`
-			AvatarPath sql.NullString `json:"-"`
-			PublicKey string `json:"publicKey"`
-			LastActiveAt sql.NullTime `json:"-"`
-		}
-		err := db.QueryRow(`SELECT id, username, display_name, avatar_path, public_key, last_active_at FROM users WHERE id=$1`, uid).Scan(&u.ID,&u.Username,&u.DisplayName,&u.AvatarPath,&u.PublicKey,&u.LastActiveAt)
+		u, err := st.Users.Get(c.Request.Context(), c.GetString("userID"))
 		if err != nil { c.JSON(http.StatusNotFound, gin.H{"error":"not found"}); return }
 		resp := gin.H{
 			"id": u.ID,
 			"username": u.Username,
 			"displayName": u.DisplayName,
 			"publicKey": u.PublicKey,
+			"mustChangeCredentials": u.MustChangeCredentials,
+			"twoFactorEnabled": u.TOTPEnabledAt.Valid,
 		}
 		if u.AvatarPath.Valid { resp["avatarUrl"] = "/api/v1/media/avatar" }
 		if u.LastActiveAt.Valid { resp["lastActiveAt"] = u.LastActiveAt.Time }
+		if u.DeletionScheduledAt.Valid { resp["deletionScheduledAt"] = u.DeletionScheduledAt.Time }
 		c.JSON(http.StatusOK, resp)
 	})
 
@@ -47,15 +49,31 @@ func RegisterUserRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
 		uid := c.GetString("userID")
 		var req changePasswordRequest
 		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
-		var oldPwdHash, oldPinHash string
-		if err := db.QueryRow(`SELECT password_hash, pin_hash FROM users WHERE id=$1`, uid).Scan(&oldPwdHash, &oldPinHash); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"user"}); return }
-		ok,_ := auth.VerifyPassword(oldPwdHash, req.OldPassword); if !ok { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid"}); return }
-		ok,_ = auth.VerifyPassword(oldPinHash, req.OldPIN); if !ok { c.JSON(http.StatusUnauthorized, gin.H{"error":"invalid"}); return }
-		newPwd, _ := auth.HashPassword(req.NewPassword)
-		newPin, _ := auth.HashPassword(req.NewPIN)
-		_, err := db.Exec(`UPDATE users SET password_hash=$1, pin_hash=$2, updated_at=now() WHERE id=$3`, newPwd, newPin, uid)
+		u, ok := checkCredentials(c, st, cfg, uid, req.OldPassword, req.OldPIN)
+		if !ok { return }
+		newPwd, err := auth.HashPassword(req.NewPassword)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"hash"}); return }
+		newPin, err := auth.HashPassword(req.NewPIN)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"hash"}); return }
+		ctx, tx, err := st.Begin(c.Request.Context())
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		defer tx.Rollback()
+		if err := st.Users.SetCredentials(ctx, uid, newPwd, newPin); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		// The key backup is encrypted under the old PIN; once the PIN changes
+		// it can no longer be unlocked and the client has to upload it again.
+		vaultDropped := false
+		if same, _ := auth.VerifyPassword(u.PINHash, req.NewPIN); !same {
+			err = st.Vaults.Delete(ctx, uid)
+			vaultDropped = err == nil
+			if err != nil && !errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		}
+		// Sessions started with the old credentials cannot be renewed; the
+		// caller's continues with a new refresh token.
+		if err := st.RefreshTokens.RevokeUser(ctx, uid); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		refresh, err := newRefreshToken(ctx, st.RefreshTokens, uid, "")
 		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
-		c.JSON(http.StatusOK, gin.H{"ok": true})
+		if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true, "keyBackupDeleted": vaultDropped, "refreshToken": refresh})
 	})
 
 	r.POST("/me/avatar", func(c *gin.Context) {
@@ -67,8 +85,53 @@ func RegisterUserRoutes(r *gin.RouterGroup, db *sql.DB, cfg config.Config) {
 		ext := filepath.Ext(file.Filename)
 		name := fmt.Sprintf("avatar%s", ext)
 		path := filepath.Join(dir, name)
-		if err := c.SaveUploadedFile(file, path); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"save"}); return }
-		_, _ = db.Exec(`UPDATE users SET avatar_path=$1, updated_at=now() WHERE id=$2`, path, uid)
+		if err := saveUpload(c, "avatar", file, path); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"save"}); return }
+		if err := st.Users.SetAvatar(c.Request.Context(), uid, path); err != nil {
+			requestLog(c).Error("saving avatar path failed", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return
+		}
+		metrics.Upload("avatar", file.Size)
 		c.JSON(http.StatusOK, gin.H{"avatarUrl": "/api/v1/media/avatar"})
 	})
-}
\ No newline at end of file
+
+	// Deleting an account only schedules the purge; jobs.PurgeDeletedAccounts
+	// removes the row, files and message contents once the grace period ends.
+	r.DELETE("/me", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		uid := c.GetString("userID")
+		var req deleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		u, ok := checkCredentials(c, st, cfg, uid, req.Password, req.PIN)
+		if !ok { return }
+		if u.IsAdmin {
+			// Purging the last admin would make the seeder recreate admin/admin on next start.
+			admins, err := st.Users.CountAdmins(ctx)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+			if admins <= 1 { c.JSON(http.StatusConflict, gin.H{"error":"last admin"}); return }
+		}
+		purgeAt := time.Now().Add(cfg.AccountDeletionGrace)
+		if err := st.Users.ScheduleDeletion(ctx, uid, purgeAt); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		c.JSON(http.StatusAccepted, gin.H{"deletionScheduledAt": purgeAt})
+	})
+
+	r.DELETE("/me/deletion", func(c *gin.Context) {
+		err := st.Users.CancelDeletion(c.Request.Context(), c.GetString("userID"))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"no deletion scheduled"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return }
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	r.GET("/me/export", exportUserData(st))
+
+	registerTOTPRoutes(r.Group("/me/2fa/totp"), st, cfg)
+
+	registerPasskeyRoutes(r.Group("/me/passkeys"), st, cfg, pk)
+
+	registerVaultRoutes(r.Group("/me/vault"), st, cfg)
+
+	registerKeyRoutes(r, st, cfg)
+
+	registerDeviceRoutes(r.Group("/me/devices"), st.Devices)
+
+	registerSealedSenderRoutes(r.Group("/me"), st, cfg)
+}
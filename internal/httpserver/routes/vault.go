@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"messagingapi/internal/config"
+	"messagingapi/internal/store"
+)
+
+// maxVaultCiphertext bounds a backup; it holds keys, not message history.
+const maxVaultCiphertext = 64 << 10
+
+type uploadVaultRequest struct {
+	PIN        string `json:"pin" binding:"required"`
+	Ciphertext string `json:"ciphertext" binding:"required"`
+}
+
+type rotateVaultRequest struct {
+	PIN        string `json:"pin" binding:"required"`
+	Ciphertext string `json:"ciphertext" binding:"required"`
+	// Version must match the stored backup, so a device holding stale keys
+	// cannot overwrite a newer backup.
+	Version int `json:"version" binding:"required"`
+}
+
+type restoreVaultRequest struct {
+	PIN string `json:"pin" binding:"required"`
+}
+
+// registerVaultRoutes mounts the key backup vault under /users/me/vault.
+// The client encrypts its private keys with a key derived from the PIN
+// before uploading; the server only stores the ciphertext and gates access
+// to it on the PIN with a limited number of attempts. This matters because
+// a passkey login never asks for the PIN. The limit only binds guesses made
+// through the API: the PIN hash is stored beside the backup, so anyone
+// holding the database can try every PIN offline.
+func registerVaultRoutes(r *gin.RouterGroup, st *store.Store, cfg config.Config) {
+	r.GET("", func(c *gin.Context) {
+		v, err := st.Vaults.Get(c.Request.Context(), c.GetString("userID"))
+		if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusOK, gin.H{"exists": false}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		u, err := st.Users.Get(c.Request.Context(), c.GetString("userID"))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		c.JSON(http.StatusOK, gin.H{"exists": true, "version": v.Version, "attemptsRemaining": cfg.VaultMaxAttempts - u.FailedCredentialAttempts, "createdAt": v.CreatedAt, "updatedAt": v.UpdatedAt})
+	})
+
+	r.POST("", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req uploadVaultRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if len(req.Ciphertext) > maxVaultCiphertext { c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error":"backup too large"}); return }
+		_, err := st.Vaults.Get(c.Request.Context(), uid)
+		if err == nil { c.JSON(http.StatusConflict, gin.H{"error":"backup exists; rotate it instead"}); return }
+		if !errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+		if _, ok := checkCredentials(c, st, cfg, uid, "", req.PIN); !ok { return }
+		if err := st.Vaults.Create(c.Request.Context(), uid, req.Ciphertext); err != nil { c.JSON(http.StatusConflict, gin.H{"error":"backup exists; rotate it instead"}); return }
+		c.JSON(http.StatusCreated, gin.H{"version": 1})
+	})
+
+	r.PUT("", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req rotateVaultRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if len(req.Ciphertext) > maxVaultCiphertext { c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error":"backup too large"}); return }
+		unlockVault(c, st, cfg, uid, req.PIN, func(ctx context.Context, v store.Vault) gin.H {
+			if v.Version != req.Version { c.JSON(http.StatusConflict, gin.H{"error":"version mismatch", "version": v.Version}); return nil }
+			version, err := st.Vaults.Update(ctx, uid, req.Ciphertext)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"update"}); return nil }
+			return gin.H{"version": version}
+		})
+	})
+
+	r.POST("/restore", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req restoreVaultRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		unlockVault(c, st, cfg, uid, req.PIN, func(_ context.Context, v store.Vault) gin.H {
+			return gin.H{"version": v.Version, "ciphertext": v.Ciphertext}
+		})
+	})
+
+	r.DELETE("", func(c *gin.Context) {
+		uid := c.GetString("userID")
+		var req restoreVaultRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		unlockVault(c, st, cfg, uid, req.PIN, func(ctx context.Context, _ store.Vault) gin.H {
+			if err := st.Vaults.Delete(ctx, uid); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"delete"}); return nil }
+			return gin.H{"ok": true}
+		})
+	})
+}
+
+// unlockVault checks pin through the shared failed-attempt counter, which
+// destroys the backup once cfg.VaultMaxAttempts wrong PINs in a row were
+// made anywhere, then runs fn with the vault row locked. fn returns the
+// success response, or writes an error and returns nil to roll back.
+func unlockVault(c *gin.Context, st *store.Store, cfg config.Config, uid, pin string, fn func(ctx context.Context, v store.Vault) gin.H) {
+	_, err := st.Vaults.Get(c.Request.Context(), uid)
+	if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"no backup"}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+	if _, ok := checkCredentials(c, st, cfg, uid, "", pin); !ok { return }
+	ctx, tx, err := st.Begin(c.Request.Context())
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+	defer tx.Rollback()
+	v, err := st.Vaults.Lock(ctx, uid)
+	if errors.Is(err, store.ErrNotFound) { c.JSON(http.StatusNotFound, gin.H{"error":"no backup"}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+	resp := fn(ctx, v)
+	if resp == nil { return }
+	if err := tx.Commit(); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"db"}); return }
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,99 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"messagingapi/internal/config"
+)
+
+// Servers starts every listener of the process with the same timeouts and
+// stops them together, so one failing listener takes the others down
+// cleanly instead of exiting mid-request.
+type Servers struct {
+	cfg       config.Config
+	listeners []listener
+}
+
+type listener struct {
+	name string
+	srv  *http.Server
+}
+
+// NewServers returns an empty set of listeners using cfg's HTTP timeouts.
+func NewServers(cfg config.Config) *Servers {
+	return &Servers{cfg: cfg}
+}
+
+func (s *Servers) newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       s.cfg.HTTPReadTimeout,
+		ReadHeaderTimeout: s.cfg.HTTPReadHeaderTimeout,
+		WriteTimeout:      s.cfg.HTTPWriteTimeout,
+		IdleTimeout:       s.cfg.HTTPIdleTimeout,
+	}
+}
+
+// Add registers a plain HTTP listener.
+func (s *Servers) Add(name, addr string, handler http.Handler) {
+	s.listeners = append(s.listeners, listener{name: name, srv: s.newServer(addr, handler)})
+}
+
+// AddTLS registers an HTTPS listener; tc must provide the certificate,
+// usually through a CertReloader.
+func (s *Servers) AddTLS(name, addr string, handler http.Handler, tc *tls.Config) {
+	srv := s.newServer(addr, handler)
+	srv.TLSConfig = tc
+	s.listeners = append(s.listeners, listener{name: name, srv: srv})
+}
+
+// Serve runs every listener until ctx is cancelled or one of them fails,
+// and returns the first listener error. Call Shutdown afterwards.
+func (s *Servers) Serve(ctx context.Context) error {
+	errc := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		go func(l listener) {
+			slog.Info("server listening", "server", l.name, "addr", l.srv.Addr)
+			var err error
+			if l.srv.TLSConfig != nil {
+				err = l.srv.ListenAndServeTLS("", "")
+			} else {
+				err = l.srv.ListenAndServe()
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				return
+			}
+			errc <- fmt.Errorf("%s server: %w", l.name, err)
+		}(l)
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// Shutdown stops accepting connections on every listener and waits for
+// in-flight requests until ctx expires, then closes whatever is left.
+func (s *Servers) Shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			if err := l.srv.Shutdown(ctx); err != nil {
+				slog.Warn("server did not drain in time", "server", l.name, "err", err)
+				l.srv.Close()
+			}
+		}(l)
+	}
+	wg.Wait()
+}
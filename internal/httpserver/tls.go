@@ -0,0 +1,197 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"messagingapi/internal/config"
+)
+
+// CertReloader serves the certificate in certFile/keyFile and picks up
+// renewals without a restart, either when Watch sees the files change or
+// when Reload is called (on SIGHUP). With a client CA bundle set, that is
+// reloaded along with the certificate.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu        sync.RWMutex
+	caFile    string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetClientCAFile loads the CA bundle that verifies client certificates
+// and reloads it from then on together with the key pair.
+func (r *CertReloader) SetClientCAFile(path string) error {
+	pool, err := loadCertPool(path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.caFile, r.clientCAs = path, pool
+	r.mu.Unlock()
+	return nil
+}
+
+// Reload reads the key pair, and the client CA bundle if set, again. On
+// error the previous ones stay in use, so a half-written renewal does not
+// take HTTPS down.
+func (r *CertReloader) Reload() error {
+	mod, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	r.mu.RLock()
+	caFile, pool := r.caFile, r.clientCAs
+	r.mu.RUnlock()
+	if caFile != "" {
+		if pool, err = loadCertPool(caFile); err != nil {
+			return fmt.Errorf("load client CAs: %w", err)
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.clientCAs, r.modTime = &cert, pool, mod
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ClientCAs returns the current client CA pool, nil when none is set.
+func (r *CertReloader) ClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clientCAs
+}
+
+// Watch polls the files every interval and reloads when either changed,
+// until ctx is cancelled.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		mod, err := r.latestModTime()
+		r.mu.RLock()
+		changed := err == nil && !mod.Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Warn("reloading TLS certificate failed", "cert", r.certFile, "err", err)
+			continue
+		}
+		slog.Info("TLS certificate reloaded", "cert", r.certFile)
+	}
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	r.mu.RLock()
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	r.mu.RUnlock()
+	var latest time.Time
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig builds the HTTPS listener's settings from cfg, taking
+// certificates and client CAs from certs.
+func TLSConfig(cfg config.Config, certs *CertReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("TLS_MIN_VERSION must be 1.2 or 1.3, got %q", cfg.TLSMinVersion)
+	}
+	tc := &tls.Config{MinVersion: minVersion, GetCertificate: certs.GetCertificate}
+	if len(cfg.TLSCipherSuites) > 0 {
+		// Only TLS 1.2 suites are configurable; Go fixes the TLS 1.3 ones.
+		byName := map[string]uint16{}
+		for _, s := range tls.CipherSuites() {
+			byName[s.Name] = s.ID
+		}
+		for _, name := range cfg.TLSCipherSuites {
+			id, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			tc.CipherSuites = append(tc.CipherSuites, id)
+		}
+	}
+	if cfg.TLSClientCAPath != "" {
+		// Client certificates are optional: browsers and apps keep using
+		// JWTs, service clients present one signed by the configured CA.
+		if err := certs.SetClientCAFile(cfg.TLSClientCAPath); err != nil {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE: %w", err)
+		}
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		base := tc.Clone()
+		tc.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			c.ClientCAs = certs.ClientCAs()
+			return c, nil
+		}
+	}
+	return tc, nil
+}
+
+// RedirectHandler answers every request on the plain HTTP port with a
+// permanent redirect to the same URL on the HTTPS port.
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
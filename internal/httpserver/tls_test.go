@@ -0,0 +1,291 @@
+package httpserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"messagingapi/internal/config"
+	"messagingapi/internal/httpserver"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// PEM is the CA certificate, for writing out as a trust bundle.
+	PEM []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, PEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// Issue returns a PEM certificate and key for cn, valid for client
+// authentication and, with a 127.0.0.1 SAN, for serving.
+func (ca *testCA) Issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// KeyPair is Issue as a tls.Certificate, for clients.
+func (ca *testCA) KeyPair(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(ca.Issue(t, cn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeFile writes data to path with a modification time one second after
+// the previous write, so reloads see a change on coarse clocks too.
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Now().Add(time.Second)
+	if st, err := os.Stat(path); err == nil && !st.ModTime().Before(mod) {
+		mod = st.ModTime().Add(time.Second)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsServer runs handler behind the listener TLSConfig builds from cfg,
+// serving a certificate from its own CA out of files in a temp dir.
+type tlsServer struct {
+	*httptest.Server
+	CA       *testCA
+	CertFile string
+	KeyFile  string
+	Certs    *httpserver.CertReloader
+}
+
+func startTLS(t *testing.T, handler http.Handler, cfg config.Config) *tlsServer {
+	t.Helper()
+	dir := t.TempDir()
+	s := &tlsServer{CA: newTestCA(t, "server CA"), CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}
+	certPEM, keyPEM := s.CA.Issue(t, "server")
+	writeFile(t, s.CertFile, certPEM)
+	writeFile(t, s.KeyFile, keyPEM)
+	certs, err := httpserver.NewCertReloader(s.CertFile, s.KeyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	if cfg.TLSMinVersion == "" {
+		cfg.TLSMinVersion = "1.2"
+	}
+	tc, err := httpserver.TLSConfig(cfg, certs)
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	s.Certs = certs
+	// StartTLS would add its own certificate ahead of GetCertificate, so
+	// serve TLS on the listener directly, as the HTTPS server does.
+	s.Server = httptest.NewUnstartedServer(handler)
+	s.Server.Listener = tls.NewListener(s.Server.Listener, tc)
+	s.Server.Start()
+	s.Server.URL = "https://" + s.Server.Listener.Addr().String()
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+// Get fetches path over a fresh connection, presenting certs and trusting
+// roots, or the server's own CA when roots is nil.
+func (s *tlsServer) Get(t *testing.T, path string, roots *x509.CertPool, certs ...tls.Certificate) (*http.Response, error) {
+	t.Helper()
+	if roots == nil {
+		roots = x509.NewCertPool()
+		roots.AddCert(s.CA.cert)
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	defer c.CloseIdleConnections()
+	resp, err := c.Get(s.URL + path)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestClientCAsReload(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "clients.pem")
+	oldCA, newCA := newTestCA(t, "old clients"), newTestCA(t, "new clients")
+	writeFile(t, caFile, oldCA.PEM)
+	srv := startTLS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}), config.Config{TLSClientCAPath: caFile})
+
+	// Clients only present a certificate from a CA the server asks for, so
+	// an untrusted one leaves the request unauthenticated.
+	verified := func(ca *testCA) bool {
+		resp, err := srv.Get(t, "/", nil, ca.KeyPair(t, "bot"))
+		return err == nil && resp.StatusCode == http.StatusOK
+	}
+	if !verified(oldCA) || verified(newCA) {
+		t.Fatalf("before reload: old CA verified %v, new CA verified %v", verified(oldCA), verified(newCA))
+	}
+
+	writeFile(t, caFile, newCA.PEM)
+	if err := srv.Certs.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if verified(oldCA) || !verified(newCA) {
+		t.Fatalf("after reload: old CA verified %v, new CA verified %v", verified(oldCA), verified(newCA))
+	}
+
+	// A broken bundle keeps the previous one.
+	writeFile(t, caFile, []byte("not a certificate"))
+	if err := srv.Certs.Reload(); err == nil {
+		t.Fatal("Reload accepted a bundle without certificates")
+	}
+	if !verified(newCA) {
+		t.Fatal("certificate rejected after a failed reload")
+	}
+}
+
+// servedCert returns the common name of the certificate srv presents.
+func servedCert(t *testing.T, srv *tlsServer) string {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.CA.cert)
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReloaderSwapsCertificate(t *testing.T) {
+	srv := startTLS(t, http.NotFoundHandler(), config.Config{})
+	if got := servedCert(t, srv); got != "server" {
+		t.Fatalf("serving %q, want server", got)
+	}
+
+	certPEM, keyPEM := srv.CA.Issue(t, "renewed")
+	writeFile(t, srv.CertFile, certPEM)
+	writeFile(t, srv.KeyFile, keyPEM)
+	if err := srv.Certs.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := servedCert(t, srv); got != "renewed" {
+		t.Fatalf("after Reload serving %q, want renewed", got)
+	}
+
+	// A certificate that does not match the key, as when only one of the
+	// files has been replaced yet, keeps the previous pair in use.
+	certPEM, _ = srv.CA.Issue(t, "half-written")
+	writeFile(t, srv.CertFile, certPEM)
+	if err := srv.Certs.Reload(); err == nil {
+		t.Fatal("Reload accepted a certificate that does not match the key")
+	}
+	if got := servedCert(t, srv); got != "renewed" {
+		t.Fatalf("after a failed Reload serving %q, want renewed", got)
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	srv := startTLS(t, http.NotFoundHandler(), config.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { srv.Certs.Watch(ctx, 10*time.Millisecond); close(done) }()
+	defer func() { cancel(); <-done }()
+
+	certPEM, keyPEM := srv.CA.Issue(t, "watched")
+	writeFile(t, srv.KeyFile, keyPEM)
+	writeFile(t, srv.CertFile, certPEM)
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCert(t, srv) != "watched" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not pick up the new certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port       int
+		host, path string
+		want       string
+	}{
+		{443, "chat.example.com", "/api/v1/chats?limit=5", "https://chat.example.com/api/v1/chats?limit=5"},
+		{443, "chat.example.com:8080", "/healthz", "https://chat.example.com/healthz"},
+		{8443, "chat.example.com:8080", "/a/b%20c?x=1", "https://chat.example.com:8443/a/b%20c?x=1"},
+		{8443, "[::1]:8080", "/", "https://[::1]:8443/"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tc.host+tc.path, nil)
+		w := httptest.NewRecorder()
+		httpserver.RedirectHandler(tc.port).ServeHTTP(w, req)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tc.want {
+			t.Errorf("%s%s on port %d: %d to %q, want 308 to %q", tc.host, tc.path, tc.port, w.Code, w.Header().Get("Location"), tc.want)
+		}
+	}
+}
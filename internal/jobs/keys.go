@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"messagingapi/internal/auth"
+	"messagingapi/internal/metrics"
+)
+
+// keyPublishLead is how long a new signing key is served from the JWKS
+// endpoint before it signs anything, so verifiers that cache the key set
+// pick it up first.
+const keyPublishLead = time.Hour
+
+// LoadSigningKeys returns every key that can still verify tokens. Seeds
+// are stored sealed by seeds; plain seeds from before encryption at rest
+// are accepted until RotateSigningKeys seals them.
+func LoadSigningKeys(ctx context.Context, db *sql.DB, seeds *auth.SeedCipher) ([]auth.SigningKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT kid, seed, created_at, activates_at, expires_at FROM jwt_signing_keys WHERE expires_at IS NULL OR expires_at > now()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []auth.SigningKey
+	for rows.Next() {
+		var k auth.SigningKey
+		var seed []byte
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&k.KID, &seed, &k.CreatedAt, &k.ActivatesAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			if seed, err = seeds.Open(k.KID, seed); err != nil {
+				return nil, fmt.Errorf("signing key %s: %w", k.KID, err)
+			}
+		}
+		k.Private = ed25519.NewKeyFromSeed(seed)
+		if expiresAt.Valid {
+			k.ExpiresAt = expiresAt.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKeys creates the first signing key, or a successor once the
+// newest key is older than every, and drops keys that can no longer verify
+// anything. It also seals any seed still stored in plain. Replicas
+// serialise on an advisory lock so only one rotates.
+func RotateSigningKeys(ctx context.Context, db *sql.DB, every time.Duration, seeds *auth.SeedCipher) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('jwt_signing_keys'))`); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM jwt_signing_keys WHERE expires_at IS NOT NULL AND expires_at <= now()`); err != nil {
+		return false, err
+	}
+	if err := sealPlainSeeds(ctx, tx, seeds); err != nil {
+		return false, err
+	}
+	var newest time.Time
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM jwt_signing_keys ORDER BY activates_at DESC LIMIT 1`).Scan(&newest)
+	activates := time.Now().Add(keyPublishLead)
+	switch {
+	case err == sql.ErrNoRows:
+		// Nothing can sign yet, so the first key is active immediately.
+		activates = time.Now()
+	case err != nil:
+		return false, err
+	case time.Since(newest) < every:
+		return false, tx.Commit()
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return false, err
+	}
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return false, err
+	}
+	kid := hex.EncodeToString(kidBytes)
+	// Tokens signed by the outgoing keys up to the handover stay valid for
+	// their full lifetime.
+	if _, err := tx.ExecContext(ctx, `UPDATE jwt_signing_keys SET expires_at=$1 WHERE expires_at IS NULL`, activates.Add(auth.AccessTokenTTL)); err != nil {
+		return false, err
+	}
+	sealed, err := seeds.Seal(kid, seed)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO jwt_signing_keys (kid, seed, activates_at) VALUES ($1,$2,$3)`, kid, sealed, activates); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// sealPlainSeeds encrypts seeds written before encryption at rest.
+func sealPlainSeeds(ctx context.Context, tx *sql.Tx, seeds *auth.SeedCipher) error {
+	rows, err := tx.QueryContext(ctx, `SELECT kid, seed FROM jwt_signing_keys WHERE length(seed)=$1`, ed25519.SeedSize)
+	if err != nil {
+		return err
+	}
+	plain := map[string][]byte{}
+	for rows.Next() {
+		var kid string
+		var seed []byte
+		if err := rows.Scan(&kid, &seed); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[kid] = seed
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for kid, seed := range plain {
+		sealed, err := seeds.Seal(kid, seed)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE jwt_signing_keys SET seed=$1 WHERE kid=$2`, sealed, kid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunKeyRotator rotates signing keys on schedule and reloads keys into the
+// key set, picking up rotations performed by other replicas. It returns
+// when ctx is cancelled.
+func RunKeyRotator(ctx context.Context, db *sql.DB, keys *auth.KeySet, seeds *auth.SeedCipher, every, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		rotated, rotateErr := RotateSigningKeys(ctx, db, every, seeds)
+		if rotateErr != nil {
+			slog.Error("signing key rotation failed", "job", "key_rotation", "err", rotateErr)
+		} else if rotated {
+			slog.Info("created new JWT signing key", "job", "key_rotation")
+		}
+		// Reload even after a failed rotation: another replica may have
+		// rotated in the meantime.
+		loaded, err := LoadSigningKeys(ctx, db, seeds)
+		if err != nil {
+			slog.Error("reloading signing keys failed", "job", "key_rotation", "err", err)
+			metrics.JobDone("key_rotation", err)
+			continue
+		}
+		metrics.JobDone("key_rotation", rotateErr)
+		keys.Replace(loaded)
+	}
+}
@@ -0,0 +1,68 @@
+package jobs_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"messagingapi/internal/auth"
+	"messagingapi/internal/db/dbtest"
+	"messagingapi/internal/jobs"
+)
+
+func newSeedCipher(t *testing.T) *auth.SeedCipher {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	c, err := auth.NewSeedCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSigningKeySeedsAreSealed(t *testing.T) {
+	conn := dbtest.Open(t)
+	ctx := context.Background()
+	seeds := newSeedCipher(t)
+	if _, err := jobs.RotateSigningKeys(ctx, conn, time.Hour, seeds); err != nil {
+		t.Fatal(err)
+	}
+	var kid string
+	var stored []byte
+	if err := conn.QueryRow(`SELECT kid, seed FROM jwt_signing_keys`).Scan(&kid, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) == ed25519.SeedSize {
+		t.Fatal("seed stored in plain")
+	}
+	keys, err := jobs.LoadSigningKeys(ctx, conn, seeds)
+	if err != nil || len(keys) != 1 || keys[0].KID != kid {
+		t.Fatalf("LoadSigningKeys = %v, %v", keys, err)
+	}
+	if _, err := jobs.LoadSigningKeys(ctx, conn, newSeedCipher(t)); err == nil {
+		t.Error("keys loaded with the wrong encryption key")
+	}
+
+	// Seeds stored before encryption at rest still load, and the next
+	// rotation seals them.
+	plain := make([]byte, ed25519.SeedSize)
+	rand.Read(plain)
+	if _, err := conn.Exec(`INSERT INTO jwt_signing_keys (kid, seed, activates_at, expires_at) VALUES ('legacy', $1, now(), now() + interval '1 hour')`, plain); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = jobs.LoadSigningKeys(ctx, conn, seeds); err != nil || len(keys) != 2 {
+		t.Fatalf("LoadSigningKeys with a plain seed = %v, %v", keys, err)
+	}
+	if _, err := jobs.RotateSigningKeys(ctx, conn, time.Hour, seeds); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow(`SELECT seed FROM jwt_signing_keys WHERE kid='legacy'`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := seeds.Open("legacy", stored); err != nil || string(opened) != string(plain) {
+		t.Fatalf("legacy seed not sealed: %v", err)
+	}
+}
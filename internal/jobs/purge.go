@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"messagingapi/internal/config"
+	"messagingapi/internal/metrics"
+)
+
+// RunAccountPurger periodically purges accounts whose deletion grace period
+// has elapsed. It returns when ctx is cancelled.
+func RunAccountPurger(ctx context.Context, db *sql.DB, cfg config.Config, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := PurgeDeletedAccounts(ctx, db, cfg)
+		metrics.JobDone("account_purge", err)
+		if err != nil {
+			slog.Error("account purge failed", "job", "account_purge", "err", err)
+		} else if n > 0 {
+			slog.Info("purged deleted accounts", "job", "account_purge", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// PurgeDeletedAccounts removes every account scheduled for deletion before
+// now. Messages the user sent are kept as tombstones (empty ciphertext,
+// is_deleted=true, sender_id NULL) so replies in shared chats stay intact;
+// their attachments, the avatar and the user row are removed.
+func PurgeDeletedAccounts(ctx context.Context, db *sql.DB, cfg config.Config) (int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, id := range ids {
+		if err := purgeAccount(ctx, db, cfg, id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func purgeAccount(ctx context.Context, db *sql.DB, cfg config.Config, uid string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var files []string
+	var avatar sql.NullString
+	// Re-check the schedule under a row lock so a concurrent cancel wins.
+	if err := tx.QueryRowContext(ctx, `SELECT avatar_path FROM users WHERE id=$1 AND deletion_scheduled_at <= now() FOR UPDATE`, uid).Scan(&avatar); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, `DELETE FROM attachments a USING messages m WHERE a.message_id=m.id AND m.sender_id=$1 RETURNING a.file_path`, uid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, p)
+	}
+	rows.Close()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_envelopes e USING messages m WHERE e.message_id=m.id AND m.sender_id=$1`, uid); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET ciphertext='', nonce=NULL, is_deleted=true WHERE sender_id=$1`, uid); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id=$1`, uid); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The account is gone at this point; leftover files are only logged.
+	for _, p := range files {
+		removeFile(uid, p)
+	}
+	if avatar.Valid && avatar.String != "" {
+		removeFile(uid, avatar.String)
+	}
+	if err := os.RemoveAll(filepath.Join(cfg.DataDir, "avatars", uid)); err != nil {
+		slog.Warn("removing avatar dir failed", "job", "account_purge", "user_id", uid, "err", err)
+	}
+	return nil
+}
+
+func removeFile(uid, path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("removing file failed", "job", "account_purge", "user_id", uid, "path", path, "err", err)
+	}
+}
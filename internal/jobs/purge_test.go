@@ -0,0 +1,108 @@
+package jobs_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"messagingapi/internal/config"
+	"messagingapi/internal/db/dbtest"
+	"messagingapi/internal/jobs"
+	"messagingapi/internal/store"
+)
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	conn := dbtest.Open(t)
+	st := store.NewPostgres(conn)
+	ctx := context.Background()
+	cfg := config.Config{DataDir: t.TempDir()}
+	writeFile := func(path string) string {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	newUser := func(name string) string {
+		t.Helper()
+		id, err := st.Users.Create(ctx, store.NewUser{Username: name, DisplayName: name, PasswordHash: "pwd", PINHash: "pin", PublicKey: "pk-" + name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	alice, bob := newUser("alice"), newUser("bob")
+	chatID, err := st.Chats.Create(ctx, "", false, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{alice, bob} {
+		if err := st.Chats.AddMember(ctx, chatID, id, "member"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent, err := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: alice, Ciphertext: "from alice", Nonce: "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment := writeFile(filepath.Join(cfg.DataDir, "uploads", "a.bin"))
+	if _, err := st.Attachments.Create(ctx, sent, attachment, "application/octet-stream", 1); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: bob, Ciphertext: "from bob", ReplyTo: &sent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatar := writeFile(filepath.Join(cfg.DataDir, "avatars", alice, "avatar.png"))
+	if err := st.Users.SetAvatar(ctx, alice, avatar); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Users.ScheduleDeletion(ctx, alice, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// bob's deletion is not due yet.
+	if err := st.Users.ScheduleDeletion(ctx, bob, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := jobs.PurgeDeletedAccounts(ctx, conn, cfg)
+	if err != nil || n != 1 {
+		t.Fatalf("purged %d accounts, err %v; want 1", n, err)
+	}
+	if _, err := st.Users.Get(ctx, alice); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("alice after purge: err = %v, want ErrNotFound", err)
+	}
+	if _, err := st.Users.Get(ctx, bob); err != nil {
+		t.Errorf("bob was purged early: %v", err)
+	}
+	for _, path := range []string{avatar, filepath.Dir(avatar), attachment} {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s survived the purge: %v", path, err)
+		}
+	}
+	if paths, err := st.Attachments.PathsByMessage(ctx, sent); err != nil || len(paths) != 0 {
+		t.Errorf("attachment rows after purge = %q, err %v", paths, err)
+	}
+
+	var sender, nonce sql.NullString
+	var ciphertext string
+	var deleted bool
+	if err := conn.QueryRow(`SELECT sender_id, ciphertext, nonce, is_deleted FROM messages WHERE id=$1`, sent).Scan(&sender, &ciphertext, &nonce, &deleted); err != nil {
+		t.Fatalf("alice's message is gone: %v", err)
+	}
+	if sender.Valid || ciphertext != "" || nonce.Valid || !deleted {
+		t.Errorf("alice's message = sender %v, ciphertext %q, nonce %v, deleted %v; want a tombstone", sender, ciphertext, nonce, deleted)
+	}
+	m, err := st.Messages.Get(ctx, reply)
+	if err != nil || m.Ciphertext != "from bob" || !m.ReplyTo.Valid || m.ReplyTo.String != sent {
+		t.Errorf("bob's reply = %+v, err %v; want it untouched", m, err)
+	}
+}
@@ -0,0 +1,106 @@
+// Package metrics defines the Prometheus collectors exported by the server.
+// Everything is registered on Registry rather than the global default so
+// only our own series (plus Go and process stats) are exposed.
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "messaging"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "http_requests_total",
+		Help: "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	Uploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "uploads_total",
+		Help: "Stored uploads by kind (avatar, attachment).",
+	}, []string{"kind"})
+
+	UploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "upload_bytes_total",
+		Help: "Bytes of stored uploads by kind.",
+	}, []string{"kind"})
+
+	LoginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "login_failures_total",
+		Help: "Rejected login attempts by method (password, totp, passkey, refresh) and reason.",
+	}, []string{"method", "reason"})
+
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "job_runs_total",
+		Help: "Background job runs by job and outcome (success, error).",
+	}, []string{"job", "outcome"})
+
+	JobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "job_last_success_timestamp_seconds",
+		Help: "Unix time of each background job's last successful run.",
+	}, []string{"job"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration, Uploads, UploadBytes, LoginFailures, JobRuns, JobLastSuccess,
+	)
+}
+
+// RegisterDB exports the connection pool stats of db as go_sql_* series
+// labelled db_name=name.
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// JobDone records the outcome of one background job run.
+func JobDone(job string, err error) {
+	if err != nil {
+		JobRuns.WithLabelValues(job, "error").Inc()
+		return
+	}
+	JobRuns.WithLabelValues(job, "success").Inc()
+	JobLastSuccess.WithLabelValues(job).Set(float64(time.Now().Unix()))
+}
+
+// Upload records one stored upload of size bytes.
+func Upload(kind string, size int64) {
+	Uploads.WithLabelValues(kind).Inc()
+	UploadBytes.WithLabelValues(kind).Add(float64(size))
+}
+
+// Handler serves the registry. A non-empty token is required as a bearer
+// token, for when /metrics shares the public listener.
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
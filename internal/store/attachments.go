@@ -0,0 +1,28 @@
+package store
+
+import "context"
+
+type pgAttachments struct{ pg }
+
+func (s pgAttachments) Create(ctx context.Context, messageID, path, contentType string, size int64) (string, error) {
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO attachments (message_id, file_path, content_type, size_bytes) VALUES ($1,$2,$3,$4) RETURNING id`, messageID, path, contentType, size).Scan(&id)
+	return id, err
+}
+
+func (s pgAttachments) Get(ctx context.Context, id string) (Attachment, error) {
+	a := Attachment{ID: id}
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT a.message_id, m.chat_id, a.file_path, a.content_type, a.size_bytes FROM attachments a JOIN messages m ON a.message_id=m.id WHERE a.id=$1`, id).
+		Scan(&a.MessageID, &a.ChatID, &a.FilePath, &a.ContentType, &a.SizeBytes)
+	return a, translate(err)
+}
+
+func (s pgAttachments) PathsByMessage(ctx context.Context, messageID string) ([]string, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT file_path FROM attachments WHERE message_id=$1`, messageID)
+	return collect(rows, err, scanString)
+}
+
+func (s pgAttachments) PathsByChat(ctx context.Context, chatID string) ([]string, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT a.file_path FROM attachments a JOIN messages m ON a.message_id=m.id WHERE m.chat_id=$1`, chatID)
+	return collect(rows, err, scanString)
+}
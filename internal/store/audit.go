@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+type pgAudit struct{ pg }
+
+func (s pgAudit) Record(ctx context.Context, actorID, action, targetType, targetID string, details any) error {
+	if details == nil {
+		details = map[string]any{}
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = s.q(ctx).ExecContext(ctx, `INSERT INTO audit_log (actor_id, action, target_type, target_id, details) VALUES ($1,$2,$3,$4,$5)`, actorID, action, targetType, targetID, b)
+	return err
+}
+
+func (s pgAudit) List(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT id, actor_id, action, target_type, target_id, details, created_at FROM audit_log
+		WHERE ($1 = '' OR actor_id::text = $1) AND ($2 = '' OR target_id = $2) AND ($3 = '' OR action = $3)
+		ORDER BY created_at DESC, id DESC LIMIT $4 OFFSET $5`, f.ActorID, f.TargetID, f.Action, f.Limit, f.Offset)
+	return collect(rows, err, func(rows *sql.Rows) (AuditEntry, error) {
+		var e AuditEntry
+		var details []byte
+		err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &details, &e.CreatedAt)
+		e.Details = details
+		return e, err
+	})
+}
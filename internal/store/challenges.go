@@ -0,0 +1,24 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+type pgChallenges struct{ pg }
+
+func (s pgChallenges) Lock(ctx context.Context, jti, userID string, expiresAt time.Time) (LoginChallenge, error) {
+	if _, err := s.q(ctx).ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at < now()`); err != nil {
+		return LoginChallenge{}, err
+	}
+	if _, err := s.q(ctx).ExecContext(ctx, `INSERT INTO login_challenges (jti, user_id, expires_at) VALUES ($1,$2,$3) ON CONFLICT (jti) DO NOTHING`, jti, userID, expiresAt); err != nil {
+		return LoginChallenge{}, translate(err)
+	}
+	var c LoginChallenge
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT failures, used_at IS NOT NULL FROM login_challenges WHERE jti=$1 AND user_id=$2 FOR UPDATE`, jti, userID).Scan(&c.Failures, &c.Used)
+	return c, translate(err)
+}
+
+func (s pgChallenges) Update(ctx context.Context, jti string, c LoginChallenge) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE login_challenges SET failures=$1, used_at=CASE WHEN $2 THEN coalesce(used_at, now()) END WHERE jti=$3`, c.Failures, c.Used, jti))
+}
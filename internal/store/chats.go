@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+type pgChats struct{ pg }
+
+func (s pgChats) Create(ctx context.Context, title string, isGroup bool, createdBy string) (string, error) {
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO chats (title, is_group, created_by) VALUES ($1,$2,$3) RETURNING id`, title, isGroup, createdBy).Scan(&id)
+	return id, err
+}
+
+func (s pgChats) AddMember(ctx context.Context, chatID, userID, role string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `INSERT INTO chat_members (chat_id, user_id, role) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`, chatID, userID, role)
+	return err
+}
+
+func (s pgChats) MemberRole(ctx context.Context, chatID, userID string) (string, error) {
+	var role string
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT role FROM chat_members WHERE chat_id=$1 AND user_id=$2`, chatID, userID).Scan(&role)
+	return role, translate(err)
+}
+
+func (s pgChats) IsMember(ctx context.Context, chatID, userID string) (bool, error) {
+	var ok bool
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM chat_members WHERE chat_id=$1 AND user_id=$2)`, chatID, userID).Scan(&ok)
+	return ok, err
+}
+
+func (s pgChats) ChatIDs(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT chat_id FROM chat_members WHERE user_id=$1`, userID)
+	return collect(rows, err, scanString)
+}
+
+func (s pgChats) Join(ctx context.Context, chatID, userID, role, inviteID string, needsApproval bool) (bool, error) {
+	var invite *string
+	if inviteID != "" {
+		invite = &inviteID
+	}
+	if needsApproval {
+		_, err := s.q(ctx).ExecContext(ctx, `INSERT INTO chat_join_requests (chat_id, user_id, invite_id, role) VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING`, chatID, userID, invite, role)
+		return true, err
+	}
+	if err := s.AddMember(ctx, chatID, userID, role); err != nil {
+		return false, err
+	}
+	return false, s.PostEvent(ctx, chatID, userID, map[string]any{"type": "member_joined", "userId": userID, "inviteId": invite})
+}
+
+func (s pgChats) PostEvent(ctx context.Context, chatID, userID string, event any) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.q(ctx).ExecContext(ctx, `INSERT INTO messages (chat_id, sender_id, ciphertext, event) VALUES ($1,$2,'',$3)`, chatID, userID, b)
+	return err
+}
+
+func (s pgChats) JoinRequests(ctx context.Context, chatID string) ([]JoinRequest, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT r.user_id, u.username, u.display_name, r.role, r.invite_id, r.created_at FROM chat_join_requests r JOIN users u ON u.id=r.user_id WHERE r.chat_id=$1 ORDER BY r.created_at`, chatID)
+	return collect(rows, err, func(rows *sql.Rows) (JoinRequest, error) {
+		var r JoinRequest
+		err := rows.Scan(&r.UserID, &r.Username, &r.DisplayName, &r.Role, &r.InviteID, &r.CreatedAt)
+		return r, err
+	})
+}
+
+func (s pgChats) TakeJoinRequest(ctx context.Context, chatID, userID string) (JoinRequest, error) {
+	r := JoinRequest{UserID: userID}
+	err := s.q(ctx).QueryRowContext(ctx, `DELETE FROM chat_join_requests WHERE chat_id=$1 AND user_id=$2 RETURNING role, invite_id, created_at`, chatID, userID).Scan(&r.Role, &r.InviteID, &r.CreatedAt)
+	return r, translate(err)
+}
+
+func (s pgChats) DeleteJoinRequest(ctx context.Context, chatID, userID string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM chat_join_requests WHERE chat_id=$1 AND user_id=$2`, chatID, userID))
+}
+
+func (s pgChats) MemberDevices(ctx context.Context, chatID string) ([]MemberDevice, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT d.user_id, d.id FROM devices d JOIN chat_members cm ON cm.user_id=d.user_id WHERE cm.chat_id=$1 ORDER BY d.user_id, d.created_at`, chatID)
+	return collect(rows, err, func(rows *sql.Rows) (MemberDevice, error) {
+		var d MemberDevice
+		err := rows.Scan(&d.UserID, &d.DeviceID)
+		return d, err
+	})
+}
+
+func (s pgChats) List(ctx context.Context, userID string) ([]Chat, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT c.id, c.title, c.is_group, c.created_at FROM chats c JOIN chat_members cm ON cm.chat_id=c.id WHERE cm.user_id=$1 ORDER BY c.created_at, c.id`, userID)
+	return collect(rows, err, func(rows *sql.Rows) (Chat, error) {
+		var ch Chat
+		err := rows.Scan(&ch.ID, &ch.Title, &ch.IsGroup, &ch.CreatedAt)
+		return ch, err
+	})
+}
+
+func (s pgChats) MemberIDs(ctx context.Context, chatID string) ([]string, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT user_id FROM chat_members WHERE chat_id=$1 ORDER BY joined_at, user_id`, chatID)
+	return collect(rows, err, scanString)
+}
+
+func (s pgChats) ShareChat(ctx context.Context, userID, otherID string) (bool, error) {
+	var ok bool
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM chat_members a JOIN chat_members b ON a.chat_id=b.chat_id WHERE a.user_id=$1 AND b.user_id=$2)`, userID, otherID).Scan(&ok)
+	return ok, err
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type pgContacts struct{ pg }
+
+func (s pgContacts) Key(ctx context.Context, userID, contactID string) (ContactKey, error) {
+	var k ContactKey
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT u.public_key, u.public_key_changed_at, v.verified_key, v.verified_at FROM users u
+		LEFT JOIN contact_verifications v ON v.contact_id=u.id AND v.user_id=$2 WHERE u.id=$1`, contactID, userID).Scan(&k.PublicKey, &k.ChangedAt, &k.VerifiedKey, &k.VerifiedAt)
+	return k, translate(err)
+}
+
+func (s pgContacts) Verifications(ctx context.Context, userID string) ([]Verification, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT v.contact_id, v.verified_key = u.public_key, v.verified_at FROM contact_verifications v JOIN users u ON u.id=v.contact_id WHERE v.user_id=$1 ORDER BY v.verified_at, v.contact_id`, userID)
+	return collect(rows, err, func(rows *sql.Rows) (Verification, error) {
+		var v Verification
+		err := rows.Scan(&v.ContactID, &v.Current, &v.VerifiedAt)
+		return v, err
+	})
+}
+
+func (s pgContacts) Verify(ctx context.Context, userID, contactID, publicKey string) error {
+	// The key is compared in the INSERT itself so a concurrent rotation
+	// cannot slip in between the check and the write.
+	err := affected(s.q(ctx).ExecContext(ctx, `INSERT INTO contact_verifications (user_id, contact_id, verified_key)
+		SELECT $1, id, public_key FROM users WHERE id=$2 AND public_key=$3
+		ON CONFLICT (user_id, contact_id) DO UPDATE SET verified_key=EXCLUDED.verified_key, verified_at=now()`, userID, contactID, publicKey))
+	if err == ErrNotFound {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s pgContacts) Unverify(ctx context.Context, userID, contactID string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM contact_verifications WHERE user_id=$1 AND contact_id=$2`, userID, contactID)
+	return err
+}
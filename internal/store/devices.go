@@ -0,0 +1,31 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type pgDevices struct{ pg }
+
+func (s pgDevices) List(ctx context.Context, userID string) ([]Device, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT id, user_id, name, created_at, last_seen_at FROM devices WHERE user_id=$1 ORDER BY created_at, id`, userID)
+	return collect(rows, err, func(rows *sql.Rows) (Device, error) {
+		var d Device
+		err := rows.Scan(&d.ID, &d.UserID, &d.Name, &d.CreatedAt, &d.LastSeenAt)
+		return d, err
+	})
+}
+
+func (s pgDevices) Create(ctx context.Context, userID, name string) (string, error) {
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO devices (user_id, name) VALUES ($1,$2) RETURNING id`, userID, name).Scan(&id)
+	return id, err
+}
+
+func (s pgDevices) Delete(ctx context.Context, userID, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM devices WHERE id=$1 AND user_id=$2`, id, userID))
+}
+
+func (s pgDevices) Touch(ctx context.Context, userID, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE devices SET last_seen_at=now() WHERE id=$1 AND user_id=$2`, id, userID))
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type pgInvites struct{ pg }
+
+const inviteColumns = `id, code, created_by, max_uses, uses, active, expires_at, kind, chat_id, member_role, requires_approval`
+
+func scanInvite(row interface{ Scan(...any) error }) (Invite, error) {
+	var i Invite
+	err := row.Scan(&i.ID, &i.Code, &i.CreatedBy, &i.MaxUses, &i.Uses, &i.Active, &i.ExpiresAt, &i.Kind, &i.ChatID, &i.Role, &i.RequiresApproval)
+	return i, translate(err)
+}
+
+func (s pgInvites) Create(ctx context.Context, inv NewInvite) (string, error) {
+	if inv.Kind == "" {
+		inv.Kind = "register"
+	}
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO invites (code, created_by, max_uses, uses, active, expires_at, kind, chat_id, member_role, requires_approval) VALUES ($1,$2,$3,0,true,$4,$5,$6,$7,$8) RETURNING id`,
+		inv.Code, nullable(inv.CreatedBy), inv.MaxUses, inv.ExpiresAt, inv.Kind, inv.ChatID, inv.Role, inv.RequiresApproval).Scan(&id)
+	return id, translate(err)
+}
+
+func (s pgInvites) Get(ctx context.Context, id string) (Invite, error) {
+	return scanInvite(s.q(ctx).QueryRowContext(ctx, `SELECT `+inviteColumns+` FROM invites WHERE id=$1`, id))
+}
+
+func (s pgInvites) GetByCode(ctx context.Context, code, kind string) (Invite, error) {
+	return scanInvite(s.q(ctx).QueryRowContext(ctx, `SELECT `+inviteColumns+` FROM invites WHERE code=$1 AND kind=$2`, code, kind))
+}
+
+func (s pgInvites) Consume(ctx context.Context, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE invites SET uses = uses + 1 WHERE id=$1 AND active AND (expires_at IS NULL OR expires_at > now()) AND uses < max_uses`, id))
+}
+
+func (s pgInvites) List(ctx context.Context, all bool, createdBy string) ([]Invite, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+inviteColumns+` FROM invites WHERE $1 OR created_by=$2 ORDER BY created_at DESC`, all, createdBy)
+	return collect(rows, err, func(rows *sql.Rows) (Invite, error) { return scanInvite(rows) })
+}
+
+func (s pgInvites) Update(ctx context.Context, id string, u InviteUpdate) error {
+	// COALESCE keeps fields the update left out.
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE invites SET active=COALESCE($1, active), max_uses=COALESCE($2, max_uses),
+		expires_at=CASE WHEN $3 THEN NULL ELSE COALESCE($4, expires_at) END WHERE id=$5`,
+		u.Active, u.MaxUses, u.NoExpiry, u.ExpiresAt, id))
+}
+
+func (s pgInvites) Delete(ctx context.Context, id string) (Invite, error) {
+	return scanInvite(s.q(ctx).QueryRowContext(ctx, `DELETE FROM invites WHERE id=$1 RETURNING `+inviteColumns, id))
+}
+
+func (s pgInvites) SlotsUsed(ctx context.Context, userID string) (int, error) {
+	var used int
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT COALESCE(SUM(CASE WHEN active AND (expires_at IS NULL OR expires_at > now()) THEN GREATEST(max_uses, uses) ELSE uses END),0) FROM invites WHERE created_by=$1 AND kind='register'`, userID).Scan(&used)
+	return used, err
+}
+
+func (s pgInvites) Registrations(ctx context.Context, id string) ([]User, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE invite_id=$1 ORDER BY created_at`, id)
+	return collect(rows, err, func(rows *sql.Rows) (User, error) { return scanUser(rows) })
+}
+
+func (s pgInvites) Tree(ctx context.Context, rootID string) ([]TreeNode, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `WITH RECURSIVE tree AS (
+			SELECT u.id, NULL::uuid AS parent_id FROM users u LEFT JOIN invites i ON u.invite_id=i.id
+			WHERE CASE WHEN $1 = '' THEN i.created_by IS NULL ELSE u.id::text = $1 END
+			UNION
+			SELECT u.id, t.id FROM users u JOIN invites i ON u.invite_id=i.id JOIN tree t ON i.created_by=t.id
+		)
+		SELECT `+prefixed("u", userColumns)+`, COALESCE(t.parent_id::text, ''), i.code
+		FROM tree t JOIN users u ON u.id=t.id LEFT JOIN invites i ON u.invite_id=i.id
+		ORDER BY u.created_at, u.id`, rootID)
+	return collect(rows, err, func(rows *sql.Rows) (TreeNode, error) {
+		var n TreeNode
+		err := rows.Scan(append(userDest(&n.User), &n.ParentID, &n.InviteCode)...)
+		return n, err
+	})
+}
+
+func (s pgInvites) DeactivateBranch(ctx context.Context, rootID string) (int64, error) {
+	res, err := s.q(ctx).ExecContext(ctx, inviteBranchCTE+`
+		UPDATE invites SET active=false WHERE active AND created_by IN (SELECT id FROM branch)`, rootID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
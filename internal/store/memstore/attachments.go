@@ -0,0 +1,54 @@
+package memstore
+
+import (
+	"context"
+
+	"messagingapi/internal/store"
+)
+
+type attachments struct{ d *db }
+
+func (s attachments) Create(ctx context.Context, messageID, path, contentType string, size int64) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	m, ok := st.messages[messageID]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	id := newID()
+	st.attachments[id] = attachment{
+		Attachment: store.Attachment{ID: id, MessageID: messageID, ChatID: m.ChatID, FilePath: path, ContentType: contentType, SizeBytes: size},
+		CreatedAt:  s.d.now(),
+	}
+	return id, nil
+}
+
+func (s attachments) Get(ctx context.Context, id string) (store.Attachment, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	a, ok := st.attachments[id]
+	if !ok {
+		return store.Attachment{}, store.ErrNotFound
+	}
+	return a.Attachment, nil
+}
+
+func (s attachments) PathsByMessage(ctx context.Context, messageID string) ([]string, error) {
+	return s.paths(func(a attachment) bool { return a.MessageID == messageID })
+}
+
+func (s attachments) PathsByChat(ctx context.Context, chatID string) ([]string, error) {
+	return s.paths(func(a attachment) bool { return a.ChatID == chatID })
+}
+
+func (s attachments) paths(keep func(attachment) bool) ([]string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []string
+	for _, a := range st.attachments {
+		if keep(a) {
+			out = append(out, a.FilePath)
+		}
+	}
+	return out, nil
+}
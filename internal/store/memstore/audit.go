@@ -0,0 +1,36 @@
+package memstore
+
+import (
+	"context"
+
+	"messagingapi/internal/store"
+)
+
+type audit struct{ d *db }
+
+func (s audit) Record(ctx context.Context, actorID, action, targetType, targetID string, details any) error {
+	b, err := marshal(details)
+	if err != nil {
+		return err
+	}
+	st, unlock := s.d.lock()
+	defer unlock()
+	st.audit = append(st.audit, store.AuditEntry{
+		ID: newID(), ActorID: nullString(actorID), Action: action, TargetType: targetType, TargetID: targetID, Details: b, CreatedAt: s.d.now(),
+	})
+	return nil
+}
+
+func (s audit) List(ctx context.Context, f store.AuditFilter) ([]store.AuditEntry, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.AuditEntry
+	// Entries are appended in creation order; List is newest first.
+	for i := len(st.audit) - 1; i >= 0; i-- {
+		e := st.audit[i]
+		if (f.ActorID == "" || e.ActorID.String == f.ActorID) && (f.TargetID == "" || e.TargetID == f.TargetID) && (f.Action == "" || e.Action == f.Action) {
+			out = append(out, e)
+		}
+	}
+	return page(out, f.Limit, f.Offset), nil
+}
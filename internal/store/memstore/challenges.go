@@ -0,0 +1,45 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type challenges struct{ d *db }
+
+func (s challenges) Lock(ctx context.Context, jti, userID string, expiresAt time.Time) (store.LoginChallenge, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	now := time.Now()
+	for k, c := range st.challenges {
+		if c.ExpiresAt.Before(now) {
+			delete(st.challenges, k)
+		}
+	}
+	c, ok := st.challenges[jti]
+	if !ok {
+		if _, ok := st.users[userID]; !ok {
+			return store.LoginChallenge{}, store.ErrNotFound
+		}
+		c = challenge{UserID: userID, ExpiresAt: expiresAt}
+		st.challenges[jti] = c
+	}
+	if c.UserID != userID {
+		return store.LoginChallenge{}, store.ErrNotFound
+	}
+	return c.LoginChallenge, nil
+}
+
+func (s challenges) Update(ctx context.Context, jti string, lc store.LoginChallenge) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	c, ok := st.challenges[jti]
+	if !ok {
+		return store.ErrNotFound
+	}
+	c.LoginChallenge = lc
+	st.challenges[jti] = c
+	return nil
+}
@@ -0,0 +1,182 @@
+package memstore
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"messagingapi/internal/store"
+)
+
+type chats struct{ d *db }
+
+func (s chats) Create(ctx context.Context, title string, isGroup bool, createdBy string) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	id := newID()
+	st.chats[id] = chat{Chat: store.Chat{ID: id, Title: nullString(title), IsGroup: isGroup, CreatedAt: s.d.now()}, CreatedBy: createdBy}
+	return id, nil
+}
+
+func (s chats) AddMember(ctx context.Context, chatID, userID, role string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	st.addMember(s.d, chatID, userID, role)
+	return nil
+}
+
+func (st *state) addMember(d *db, chatID, userID, role string) {
+	if st.members[chatID] == nil {
+		st.members[chatID] = map[string]member{}
+	}
+	if _, ok := st.members[chatID][userID]; !ok {
+		st.members[chatID][userID] = member{Role: role, JoinedAt: d.now()}
+	}
+}
+
+func (s chats) MemberRole(ctx context.Context, chatID, userID string) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	m, ok := st.members[chatID][userID]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return m.Role, nil
+}
+
+func (s chats) IsMember(ctx context.Context, chatID, userID string) (bool, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	_, ok := st.members[chatID][userID]
+	return ok, nil
+}
+
+func (s chats) ChatIDs(ctx context.Context, userID string) ([]string, error) {
+	list, err := s.List(ctx, userID)
+	var ids []string
+	for _, c := range list {
+		ids = append(ids, c.ID)
+	}
+	return ids, err
+}
+
+func (s chats) List(ctx context.Context, userID string) ([]store.Chat, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.Chat
+	for _, c := range sorted(st.chats, func(c chat) bool { _, ok := st.members[c.ID][userID]; return ok }, func(a, b chat) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}) {
+		out = append(out, c.Chat)
+	}
+	return out, nil
+}
+
+func (s chats) MemberIDs(ctx context.Context, chatID string) ([]string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	return st.memberIDs(chatID), nil
+}
+
+// memberIDs lists a chat's members in the order they joined.
+func (st *state) memberIDs(chatID string) []string {
+	var ids []string
+	for id := range st.members[chatID] {
+		ids = append(ids, id)
+	}
+	ms := st.members[chatID]
+	slices.SortFunc(ids, func(a, b string) int { return byTime(ms[a].JoinedAt, ms[b].JoinedAt, a, b) })
+	return ids
+}
+
+func (s chats) ShareChat(ctx context.Context, userID, otherID string) (bool, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, ms := range st.members {
+		_, a := ms[userID]
+		_, b := ms[otherID]
+		if a && b {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s chats) Join(ctx context.Context, chatID, userID, role, inviteID string, needsApproval bool) (bool, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if needsApproval {
+		if st.joinRequests[chatID] == nil {
+			st.joinRequests[chatID] = map[string]joinRequest{}
+		}
+		if _, ok := st.joinRequests[chatID][userID]; !ok {
+			st.joinRequests[chatID][userID] = joinRequest{Role: role, InviteID: nullString(inviteID), CreatedAt: s.d.now()}
+		}
+		return true, nil
+	}
+	st.addMember(s.d, chatID, userID, role)
+	var invite *string
+	if inviteID != "" {
+		invite = &inviteID
+	}
+	return false, st.postEvent(s.d, chatID, userID, map[string]any{"type": "member_joined", "userId": userID, "inviteId": invite})
+}
+
+func (s chats) PostEvent(ctx context.Context, chatID, userID string, event any) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	return st.postEvent(s.d, chatID, userID, event)
+}
+
+func (st *state) postEvent(d *db, chatID, userID string, event any) error {
+	b, err := marshal(event)
+	if err != nil {
+		return err
+	}
+	id := newID()
+	st.addMessage(store.Message{ID: id, ChatID: chatID, SenderID: nullString(userID), CreatedAt: d.now(), Event: b})
+	return nil
+}
+
+func (s chats) JoinRequests(ctx context.Context, chatID string) ([]store.JoinRequest, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.JoinRequest
+	for userID, r := range st.joinRequests[chatID] {
+		u := st.users[userID]
+		out = append(out, store.JoinRequest{UserID: userID, Username: u.Username, DisplayName: u.DisplayName, Role: r.Role, InviteID: r.InviteID, CreatedAt: r.CreatedAt})
+	}
+	slices.SortFunc(out, func(a, b store.JoinRequest) int { return byTime(a.CreatedAt, b.CreatedAt, a.UserID, b.UserID) })
+	return out, nil
+}
+
+func (s chats) TakeJoinRequest(ctx context.Context, chatID, userID string) (store.JoinRequest, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	r, ok := st.joinRequests[chatID][userID]
+	if !ok {
+		return store.JoinRequest{}, store.ErrNotFound
+	}
+	delete(st.joinRequests[chatID], userID)
+	return store.JoinRequest{UserID: userID, Role: r.Role, InviteID: r.InviteID, CreatedAt: r.CreatedAt}, nil
+}
+
+func (s chats) DeleteJoinRequest(ctx context.Context, chatID, userID string) error {
+	_, err := s.TakeJoinRequest(ctx, chatID, userID)
+	return err
+}
+
+func (s chats) MemberDevices(ctx context.Context, chatID string) ([]store.MemberDevice, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.MemberDevice
+	for _, d := range sorted(st.devices, func(d store.Device) bool { _, ok := st.members[chatID][d.UserID]; return ok }, func(a, b store.Device) int {
+		if c := strings.Compare(a.UserID, b.UserID); c != 0 {
+			return c
+		}
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}) {
+		out = append(out, store.MemberDevice{UserID: d.UserID, DeviceID: d.ID})
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package memstore
+
+import (
+	"context"
+	"slices"
+
+	"messagingapi/internal/store"
+)
+
+type contacts struct{ d *db }
+
+func (s contacts) Key(ctx context.Context, userID, contactID string) (store.ContactKey, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	u, ok := st.users[contactID]
+	if !ok {
+		return store.ContactKey{}, store.ErrNotFound
+	}
+	k := store.ContactKey{PublicKey: u.PublicKey, ChangedAt: u.PublicKeyChangedAt}
+	if v, ok := st.verifications[[2]string{userID, contactID}]; ok {
+		k.VerifiedKey, k.VerifiedAt = nullString(v.Key), nullTime(v.At)
+	}
+	return k, nil
+}
+
+func (s contacts) Verifications(ctx context.Context, userID string) ([]store.Verification, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.Verification
+	for k, v := range st.verifications {
+		if k[0] != userID {
+			continue
+		}
+		if u, ok := st.users[k[1]]; ok {
+			out = append(out, store.Verification{ContactID: k[1], Current: v.Key == u.PublicKey, VerifiedAt: v.At})
+		}
+	}
+	slices.SortFunc(out, func(a, b store.Verification) int { return byTime(a.VerifiedAt, b.VerifiedAt, a.ContactID, b.ContactID) })
+	return out, nil
+}
+
+func (s contacts) Verify(ctx context.Context, userID, contactID, publicKey string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if u, ok := st.users[contactID]; !ok || u.PublicKey != publicKey {
+		return store.ErrConflict
+	}
+	st.verifications[[2]string{userID, contactID}] = verification{Key: publicKey, At: s.d.now()}
+	return nil
+}
+
+func (s contacts) Unverify(ctx context.Context, userID, contactID string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	delete(st.verifications, [2]string{userID, contactID})
+	return nil
+}
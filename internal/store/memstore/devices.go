@@ -0,0 +1,54 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type devices struct{ d *db }
+
+func (s devices) List(ctx context.Context, userID string) ([]store.Device, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	return sorted(st.devices, func(d store.Device) bool { return d.UserID == userID }, func(a, b store.Device) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s devices) Create(ctx context.Context, userID, name string) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if _, ok := st.users[userID]; !ok {
+		return "", store.ErrNotFound
+	}
+	id := newID()
+	st.devices[id] = store.Device{ID: id, UserID: userID, Name: name, CreatedAt: s.d.now()}
+	return id, nil
+}
+
+func (s devices) Delete(ctx context.Context, userID, id string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if d, ok := st.devices[id]; !ok || d.UserID != userID {
+		return store.ErrNotFound
+	}
+	delete(st.devices, id)
+	for _, envs := range st.envelopes {
+		delete(envs, id)
+	}
+	return nil
+}
+
+func (s devices) Touch(ctx context.Context, userID, id string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	d, ok := st.devices[id]
+	if !ok || d.UserID != userID {
+		return store.ErrNotFound
+	}
+	d.LastSeenAt = nullTime(time.Now())
+	st.devices[id] = d
+	return nil
+}
@@ -0,0 +1,220 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type invites struct{ d *db }
+
+func (s invites) Create(ctx context.Context, ni store.NewInvite) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if ni.Kind == "" {
+		ni.Kind = "register"
+	}
+	for _, i := range st.invites {
+		if i.Code == ni.Code {
+			return "", store.ErrConflict
+		}
+	}
+	id := newID()
+	inv := invite{
+		Invite: store.Invite{
+			ID: id, Code: ni.Code, CreatedBy: nullString(ni.CreatedBy), MaxUses: ni.MaxUses, Active: true, Kind: ni.Kind,
+			Role: ni.Role, RequiresApproval: ni.RequiresApproval,
+		},
+		CreatedAt: s.d.now(),
+	}
+	if ni.ExpiresAt != nil {
+		inv.ExpiresAt = nullTime(*ni.ExpiresAt)
+	}
+	if ni.ChatID != nil {
+		inv.ChatID = nullString(*ni.ChatID)
+	}
+	st.invites[id] = inv
+	return id, nil
+}
+
+func (s invites) Get(ctx context.Context, id string) (store.Invite, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	i, ok := st.invites[id]
+	if !ok {
+		return store.Invite{}, store.ErrNotFound
+	}
+	return i.Invite, nil
+}
+
+func (s invites) GetByCode(ctx context.Context, code, kind string) (store.Invite, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, i := range st.invites {
+		if i.Code == code && i.Kind == kind {
+			return i.Invite, nil
+		}
+	}
+	return store.Invite{}, store.ErrNotFound
+}
+
+func (s invites) Consume(ctx context.Context, id string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	i, ok := st.invites[id]
+	if !ok || !i.Usable(time.Now()) {
+		return store.ErrNotFound
+	}
+	i.Uses++
+	st.invites[id] = i
+	return nil
+}
+
+func (s invites) List(ctx context.Context, all bool, createdBy string) ([]store.Invite, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.Invite
+	for _, i := range sorted(st.invites, func(i invite) bool { return all || i.CreatedBy.String == createdBy && i.CreatedBy.Valid }, func(a, b invite) int {
+		return -byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}) {
+		out = append(out, i.Invite)
+	}
+	return out, nil
+}
+
+func (s invites) Update(ctx context.Context, id string, u store.InviteUpdate) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	i, ok := st.invites[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if u.Active != nil {
+		i.Active = *u.Active
+	}
+	if u.MaxUses != nil {
+		i.MaxUses = *u.MaxUses
+	}
+	switch {
+	case u.NoExpiry:
+		i.ExpiresAt = sql.NullTime{}
+	case u.ExpiresAt != nil:
+		i.ExpiresAt = nullTime(*u.ExpiresAt)
+	}
+	st.invites[id] = i
+	return nil
+}
+
+func (s invites) Delete(ctx context.Context, id string) (store.Invite, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	i, ok := st.invites[id]
+	if !ok {
+		return store.Invite{}, store.ErrNotFound
+	}
+	delete(st.invites, id)
+	// invite_id references are SET NULL on delete.
+	for uid, u := range st.users {
+		if u.InviteID == id {
+			u.InviteID = ""
+			st.users[uid] = u
+		}
+	}
+	for _, reqs := range st.joinRequests {
+		for uid, r := range reqs {
+			if r.InviteID.String == id {
+				r.InviteID = sql.NullString{}
+				reqs[uid] = r
+			}
+		}
+	}
+	return i.Invite, nil
+}
+
+func (s invites) SlotsUsed(ctx context.Context, userID string) (int, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	now := time.Now()
+	used := 0
+	for _, i := range st.invites {
+		if !i.CreatedBy.Valid || i.CreatedBy.String != userID || i.Kind != "register" {
+			continue
+		}
+		if i.Active && (!i.ExpiresAt.Valid || i.ExpiresAt.Time.After(now)) {
+			used += max(i.MaxUses, i.Uses)
+		} else {
+			used += i.Uses
+		}
+	}
+	return used, nil
+}
+
+func (s invites) Registrations(ctx context.Context, id string) ([]store.User, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var out []store.User
+	for _, u := range sorted(st.users, func(u user) bool { return u.InviteID == id }, func(a, b user) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}) {
+		out = append(out, u.User)
+	}
+	return out, nil
+}
+
+func (s invites) Tree(ctx context.Context, rootID string) ([]store.TreeNode, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	parent := func(u user) (string, bool) {
+		i, ok := st.invites[u.InviteID]
+		return i.CreatedBy.String, ok && i.CreatedBy.Valid
+	}
+	in := map[string]string{}
+	for id, u := range st.users {
+		_, invited := parent(u)
+		if rootID == "" && !invited || id == rootID {
+			in[id] = ""
+		}
+	}
+	for grew := true; grew; {
+		grew = false
+		for id, u := range st.users {
+			if _, ok := in[id]; ok {
+				continue
+			}
+			if p, ok := parent(u); ok {
+				if _, ok := in[p]; ok {
+					in[id] = p
+					grew = true
+				}
+			}
+		}
+	}
+	var out []store.TreeNode
+	for _, u := range sorted(st.users, func(u user) bool { _, ok := in[u.ID]; return ok }, func(a, b user) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}) {
+		n := store.TreeNode{User: u.User, ParentID: in[u.ID]}
+		if i, ok := st.invites[u.InviteID]; ok {
+			n.InviteCode = nullString(i.Code)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func (s invites) DeactivateBranch(ctx context.Context, rootID string) (int64, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	branch := st.branch(rootID)
+	var n int64
+	for id, i := range st.invites {
+		if i.Active && i.CreatedBy.Valid && branch[i.CreatedBy.String] {
+			i.Active = false
+			st.invites[id] = i
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,324 @@
+// Package memstore is an in-memory store.Store for tests. It keeps the
+// semantics the handlers rely on from Postgres: unique constraints surface
+// as store.ErrConflict, conditional updates as store.ErrNotFound, and
+// deletes cascade the way the schema's foreign keys do.
+//
+// Transactions are serialized and roll back by restoring a snapshot taken
+// at Begin. They are not isolated: other callers see uncommitted writes,
+// and writes made outside the transaction while it is open are lost if it
+// rolls back.
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"messagingapi/internal/store"
+)
+
+type user struct {
+	store.User
+	InviteID           string
+	PublicKeyChangedAt sql.NullTime
+	TOTPSecret         sql.NullString
+	TOTPLastStep       int64
+	TOTPFailedAttempts int
+	TOTPLockedUntil    sql.NullTime
+	SealedKeyHash      string
+}
+
+type chat struct {
+	store.Chat
+	CreatedBy string
+}
+
+type member struct {
+	Role     string
+	JoinedAt time.Time
+}
+
+type joinRequest struct {
+	Role      string
+	InviteID  sql.NullString
+	CreatedAt time.Time
+}
+
+type invite struct {
+	store.Invite
+	CreatedAt time.Time
+}
+
+type attachment struct {
+	store.Attachment
+	CreatedAt time.Time
+}
+
+type recoveryCode struct {
+	Hash string
+	Used bool
+}
+
+type verification struct {
+	Key string
+	At  time.Time
+}
+
+type session struct {
+	store.WebAuthnSession
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+type challenge struct {
+	store.LoginChallenge
+	UserID    string
+	ExpiresAt time.Time
+}
+
+type issuance struct {
+	UserID string
+	Day    string
+}
+
+// state holds every table. Rows are stored by value, so a shallow copy of
+// each map, plus copies of the nested maps and slices, is a snapshot.
+type state struct {
+	users         map[string]user
+	chats         map[string]chat
+	members       map[string]map[string]member      // chat → user
+	joinRequests  map[string]map[string]joinRequest // chat → user
+	messages      map[string]store.Message
+	envelopes     map[string]map[string]string // message → device → ciphertext
+	invites       map[string]invite
+	attachments   map[string]attachment
+	audit         []store.AuditEntry
+	devices       map[string]store.Device
+	vaults        map[string]store.Vault
+	passkeys      map[string]store.Passkey
+	sessions      map[string]session
+	recoveryCodes map[string][]recoveryCode
+	verifications map[[2]string]verification // (user, contact)
+	tokens        map[string]time.Time
+	issued        map[issuance]int
+	challenges    map[string]challenge
+	refreshTokens map[string]refreshToken
+	// seq numbers messages in creation order for Sync.
+	seq int64
+}
+
+func newState() *state {
+	return &state{
+		users:         map[string]user{},
+		chats:         map[string]chat{},
+		members:       map[string]map[string]member{},
+		joinRequests:  map[string]map[string]joinRequest{},
+		messages:      map[string]store.Message{},
+		envelopes:     map[string]map[string]string{},
+		invites:       map[string]invite{},
+		attachments:   map[string]attachment{},
+		devices:       map[string]store.Device{},
+		vaults:        map[string]store.Vault{},
+		passkeys:      map[string]store.Passkey{},
+		sessions:      map[string]session{},
+		recoveryCodes: map[string][]recoveryCode{},
+		verifications: map[[2]string]verification{},
+		tokens:        map[string]time.Time{},
+		issued:        map[issuance]int{},
+		challenges:    map[string]challenge{},
+		refreshTokens: map[string]refreshToken{},
+	}
+}
+
+func cloneNested[K1, K2 comparable, V any](m map[K1]map[K2]V) map[K1]map[K2]V {
+	out := make(map[K1]map[K2]V, len(m))
+	for k, v := range m {
+		out[k] = maps.Clone(v)
+	}
+	return out
+}
+
+func (s *state) clone() *state {
+	c := *s
+	c.users = maps.Clone(s.users)
+	c.chats = maps.Clone(s.chats)
+	c.members = cloneNested(s.members)
+	c.joinRequests = cloneNested(s.joinRequests)
+	c.messages = maps.Clone(s.messages)
+	c.envelopes = cloneNested(s.envelopes)
+	c.invites = maps.Clone(s.invites)
+	c.attachments = maps.Clone(s.attachments)
+	c.audit = slices.Clone(s.audit)
+	c.devices = maps.Clone(s.devices)
+	c.vaults = maps.Clone(s.vaults)
+	c.passkeys = maps.Clone(s.passkeys)
+	c.sessions = maps.Clone(s.sessions)
+	c.recoveryCodes = make(map[string][]recoveryCode, len(s.recoveryCodes))
+	for k, v := range s.recoveryCodes {
+		c.recoveryCodes[k] = slices.Clone(v)
+	}
+	c.verifications = maps.Clone(s.verifications)
+	c.tokens = maps.Clone(s.tokens)
+	c.issued = maps.Clone(s.issued)
+	c.challenges = maps.Clone(s.challenges)
+	c.refreshTokens = maps.Clone(s.refreshTokens)
+	return &c
+}
+
+type db struct {
+	// txMu is held for the whole of a transaction, mu for each call.
+	txMu sync.Mutex
+	mu   sync.Mutex
+	s    *state
+	last time.Time
+	// open is set while a transaction runs; messages created after openSeq
+	// are then held back from Sync, as Postgres holds back transactions
+	// past the snapshot's xmin.
+	open    bool
+	openSeq int64
+}
+
+// New returns an empty Store.
+func New() *store.Store {
+	d := &db{s: newState()}
+	return &store.Store{
+		Users:         users{d},
+		Chats:         chats{d},
+		Messages:      messages{d},
+		Invites:       invites{d},
+		Attachments:   attachments{d},
+		Audit:         audit{d},
+		Devices:       devices{d},
+		Vaults:        vaults{d},
+		Passkeys:      passkeys{d},
+		TOTP:          totp{d},
+		Contacts:      contacts{d},
+		Sealed:        sealed{d},
+		Challenges:    challenges{d},
+		RefreshTokens: refreshTokens{d},
+		Transactor:    d,
+	}
+}
+
+// lock runs fn with the state locked.
+func (d *db) lock() (*state, func()) {
+	d.mu.Lock()
+	return d.s, d.mu.Unlock
+}
+
+// now returns a strictly increasing time at the database's microsecond
+// precision, so rows created in a row sort by creation.
+func (d *db) now() time.Time {
+	t := time.Now().Truncate(time.Microsecond)
+	if !t.After(d.last) {
+		t = d.last.Add(time.Microsecond)
+	}
+	d.last = t
+	return t
+}
+
+func newID() string { return uuid.NewString() }
+
+type txKey struct{}
+
+type tx struct {
+	d        *db
+	snapshot *state
+	done     bool
+}
+
+func (d *db) Begin(ctx context.Context) (context.Context, store.Tx, error) {
+	if _, ok := ctx.Value(txKey{}).(*tx); ok {
+		return ctx, joinedTx{}, nil
+	}
+	d.txMu.Lock()
+	d.mu.Lock()
+	t := &tx{d: d, snapshot: d.s.clone()}
+	d.open, d.openSeq = true, d.s.seq
+	d.mu.Unlock()
+	return context.WithValue(ctx, txKey{}, t), t, nil
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.d.mu.Lock()
+	t.d.open = false
+	t.d.mu.Unlock()
+	t.d.txMu.Unlock()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.d.mu.Lock()
+	t.d.s = t.snapshot
+	t.d.open = false
+	t.d.mu.Unlock()
+	t.d.txMu.Unlock()
+	return nil
+}
+
+type joinedTx struct{}
+
+func (joinedTx) Commit() error   { return nil }
+func (joinedTx) Rollback() error { return nil }
+
+func nullString(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+
+func nullTime(t time.Time) sql.NullTime { return sql.NullTime{Time: t, Valid: true} }
+
+func marshal(v any) (json.RawMessage, error) {
+	if v == nil {
+		return json.RawMessage(`{}`), nil
+	}
+	return json.Marshal(v)
+}
+
+// sorted returns the values of m ordered by less.
+func sorted[K comparable, V any](m map[K]V, keep func(V) bool, less func(a, b V) int) []V {
+	var out []V
+	for _, v := range m {
+		if keep == nil || keep(v) {
+			out = append(out, v)
+		}
+	}
+	slices.SortFunc(out, less)
+	return out
+}
+
+// byTime orders rows by creation time, then ID, like the SQL queries do.
+func byTime(at, bt time.Time, aid, bid string) int {
+	if c := at.Compare(bt); c != 0 {
+		return c
+	}
+	switch {
+	case aid < bid:
+		return -1
+	case aid > bid:
+		return 1
+	}
+	return 0
+}
+
+// page applies LIMIT and OFFSET.
+func page[T any](list []T, limit, offset int) []T {
+	if offset >= len(list) {
+		return nil
+	}
+	list = list[offset:]
+	if limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,13 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"messagingapi/internal/store"
+	"messagingapi/internal/store/memstore"
+	"messagingapi/internal/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(*testing.T) *store.Store { return memstore.New() })
+}
@@ -0,0 +1,218 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type messages struct{ d *db }
+
+func (s messages) Create(ctx context.Context, m store.NewMessage) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	id := newID()
+	msg := store.Message{
+		ID: id, ChatID: m.ChatID, SenderID: nullString(m.SenderID), Ciphertext: m.Ciphertext, Nonce: sql.NullString{String: m.Nonce, Valid: true},
+		CreatedAt: s.d.now(), Fanout: m.Fanout, Sealed: m.Sealed,
+	}
+	if m.ReplyTo != nil {
+		msg.ReplyTo = nullString(*m.ReplyTo)
+	}
+	st.addMessage(msg)
+	return id, nil
+}
+
+// addMessage stores a new message at the end of Sync order. Transactions
+// run one at a time here, so creation order is commit order.
+func (st *state) addMessage(m store.Message) {
+	st.seq++
+	m.Position.Seq = st.seq
+	st.messages[m.ID] = m
+}
+
+func (s messages) AddEnvelope(ctx context.Context, messageID, chatID, deviceID, ciphertext string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	d, ok := st.devices[deviceID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if _, ok := st.members[chatID][d.UserID]; !ok {
+		return store.ErrNotFound
+	}
+	if st.envelopes[messageID] == nil {
+		st.envelopes[messageID] = map[string]string{}
+	}
+	if _, ok := st.envelopes[messageID][deviceID]; !ok {
+		st.envelopes[messageID][deviceID] = ciphertext
+	}
+	return nil
+}
+
+// view returns m as seen by deviceID and whether that device may see it:
+// a fan-out message is only visible through an envelope addressed to it.
+func (st *state) view(m store.Message, deviceID string) (store.Message, bool) {
+	if m.Fanout {
+		ciphertext, ok := st.envelopes[m.ID][deviceID]
+		if !ok {
+			return m, false
+		}
+		m.Ciphertext = ciphertext
+	}
+	return st.withAttachments(m), true
+}
+
+// withAttachments fills in the JSON summary of m's attachments.
+func (st *state) withAttachments(m store.Message) store.Message {
+	type att struct {
+		ID          string `json:"id"`
+		ContentType string `json:"contentType"`
+		SizeBytes   int64  `json:"sizeBytes"`
+	}
+	atts := []att{}
+	for _, a := range st.messageAttachments(m.ID) {
+		atts = append(atts, att{a.ID, a.ContentType, a.SizeBytes})
+	}
+	m.Attachments, _ = json.Marshal(atts)
+	return m
+}
+
+func (st *state) messageAttachments(messageID string) []attachment {
+	return sorted(st.attachments, func(a attachment) bool { return a.MessageID == messageID }, func(a, b attachment) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	})
+}
+
+func (s messages) Get(ctx context.Context, id string) (store.Message, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	m, ok := st.messages[id]
+	if !ok {
+		return store.Message{}, store.ErrNotFound
+	}
+	// Without a device a fan-out message has no ciphertext.
+	if m.Fanout {
+		m.Ciphertext = ""
+	}
+	return st.withAttachments(m), nil
+}
+
+func (s messages) Edit(ctx context.Context, id, ciphertext, nonce string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	m, ok := st.messages[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	m.Ciphertext, m.Nonce, m.EditedAt = ciphertext, sql.NullString{String: nonce, Valid: true}, nullTime(time.Now())
+	st.messages[id] = m
+	return nil
+}
+
+func (s messages) Delete(ctx context.Context, id string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if _, ok := st.messages[id]; !ok {
+		return store.ErrNotFound
+	}
+	st.deleteMessage(id)
+	return nil
+}
+
+func (s messages) DeleteByChat(ctx context.Context, chatID string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for id, m := range st.messages {
+		if m.ChatID == chatID {
+			st.deleteMessage(id)
+		}
+	}
+	return nil
+}
+
+// deleteMessage removes a message with its attachments and envelopes and
+// unlinks replies to it.
+func (st *state) deleteMessage(id string) {
+	delete(st.messages, id)
+	delete(st.envelopes, id)
+	for aid, a := range st.attachments {
+		if a.MessageID == id {
+			delete(st.attachments, aid)
+		}
+	}
+	for mid, m := range st.messages {
+		if m.ReplyTo.Valid && m.ReplyTo.String == id {
+			m.ReplyTo = sql.NullString{}
+			st.messages[mid] = m
+		}
+	}
+}
+
+func (s messages) History(ctx context.Context, chatID, deviceID string, beforeAt time.Time, beforeID string, limit int) ([]store.Message, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	list := sorted(st.messages, func(m store.Message) bool {
+		return m.ChatID == chatID && byTime(m.CreatedAt, beforeAt, m.ID, beforeID) < 0
+	}, func(a, b store.Message) int { return -byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID) })
+	return st.views(list, deviceID, limit), nil
+}
+
+func (s messages) Sync(ctx context.Context, userID, deviceID string, after store.SyncPosition, limit int) ([]store.Message, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	list := sorted(st.messages, func(m store.Message) bool {
+		_, member := st.members[m.ChatID][userID]
+		held := s.d.open && m.Position.Seq > s.d.openSeq
+		return member && m.Position.Seq > after.Seq && !held
+	}, func(a, b store.Message) int { return cmp.Compare(a.Position.Seq, b.Position.Seq) })
+	return st.views(list, deviceID, limit), nil
+}
+
+// views returns up to limit of list that deviceID may see.
+func (st *state) views(list []store.Message, deviceID string, limit int) []store.Message {
+	var out []store.Message
+	for _, m := range list {
+		if len(out) == limit {
+			break
+		}
+		if v, ok := st.view(m, deviceID); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (s messages) Export(ctx context.Context, chatID, userID string, fn func(store.ExportedMessage) error) error {
+	st, unlock := s.d.lock()
+	list := sorted(st.messages, func(m store.Message) bool { return m.ChatID == chatID }, func(a, b store.Message) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	})
+	out := make([]store.ExportedMessage, len(list))
+	for i, m := range list {
+		out[i].Message = m
+		for _, a := range st.messageAttachments(m.ID) {
+			a.ChatID = m.ChatID
+			out[i].Files = append(out[i].Files, a.Attachment)
+		}
+		devices := sorted(st.devices, func(d store.Device) bool { return d.UserID == userID }, func(a, b store.Device) int {
+			return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+		})
+		for _, d := range devices {
+			if c, ok := st.envelopes[m.ID][d.ID]; ok {
+				out[i].Envelopes = append(out[i].Envelopes, store.Envelope{DeviceID: d.ID, Ciphertext: c})
+			}
+		}
+	}
+	unlock()
+	for _, m := range out {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type passkeys struct{ d *db }
+
+func (s passkeys) List(ctx context.Context, userID string) ([]store.Passkey, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	return sorted(st.passkeys, func(p store.Passkey) bool { return p.UserID == userID }, func(a, b store.Passkey) int {
+		return byTime(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s passkeys) Count(ctx context.Context, userID string) (int, error) {
+	list, err := s.List(ctx, userID)
+	return len(list), err
+}
+
+func (s passkeys) Create(ctx context.Context, p store.Passkey) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, other := range st.passkeys {
+		if bytes.Equal(other.CredentialID, p.CredentialID) {
+			return "", store.ErrConflict
+		}
+	}
+	p.ID, p.CreatedAt = newID(), s.d.now()
+	st.passkeys[p.ID] = p
+	return p.ID, nil
+}
+
+func (s passkeys) Delete(ctx context.Context, userID, id string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if p, ok := st.passkeys[id]; !ok || p.UserID != userID {
+		return store.ErrNotFound
+	}
+	delete(st.passkeys, id)
+	return nil
+}
+
+func (s passkeys) RecordUse(ctx context.Context, credentialID []byte, signCount uint32, userVerified, backupState bool) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for id, p := range st.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			p.SignCount, p.UserVerified, p.BackupState, p.LastUsedAt = signCount, userVerified, backupState, nullTime(time.Now())
+			st.passkeys[id] = p
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s passkeys) SaveSession(ctx context.Context, userID, purpose string, data json.RawMessage, expiresAt time.Time) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	now := time.Now()
+	for id, w := range st.sessions {
+		if w.ExpiresAt.Before(now) {
+			delete(st.sessions, id)
+		}
+	}
+	id := newID()
+	st.sessions[id] = session{WebAuthnSession: store.WebAuthnSession{UserID: nullString(userID), Data: data}, Purpose: purpose, ExpiresAt: expiresAt}
+	return id, nil
+}
+
+func (s passkeys) TakeSession(ctx context.Context, id, purpose string) (store.WebAuthnSession, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	w, ok := st.sessions[id]
+	if !ok || w.Purpose != purpose || !w.ExpiresAt.After(time.Now()) {
+		return store.WebAuthnSession{}, store.ErrNotFound
+	}
+	delete(st.sessions, id)
+	return w.WebAuthnSession, nil
+}
@@ -0,0 +1,81 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type refreshToken struct {
+	store.RefreshToken
+	ExpiresAt time.Time
+}
+
+type refreshTokens struct{ d *db }
+
+func (s refreshTokens) Create(ctx context.Context, userID, familyID, tokenHash string, expiresAt time.Time) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	now := time.Now()
+	for h, t := range st.refreshTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(st.refreshTokens, h)
+		}
+	}
+	if _, ok := st.users[userID]; !ok {
+		return store.ErrNotFound
+	}
+	if _, ok := st.refreshTokens[tokenHash]; ok {
+		return store.ErrConflict
+	}
+	if familyID == "" {
+		familyID = newID()
+	}
+	st.refreshTokens[tokenHash] = refreshToken{store.RefreshToken{UserID: userID, FamilyID: familyID}, expiresAt}
+	return nil
+}
+
+func (s refreshTokens) Lock(ctx context.Context, tokenHash string) (store.RefreshToken, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	t, ok := st.refreshTokens[tokenHash]
+	if !ok || !t.ExpiresAt.After(time.Now()) {
+		return store.RefreshToken{}, store.ErrNotFound
+	}
+	return t.RefreshToken, nil
+}
+
+func (s refreshTokens) Spend(ctx context.Context, tokenHash string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	t, ok := st.refreshTokens[tokenHash]
+	if !ok || t.Used {
+		return store.ErrNotFound
+	}
+	t.Used = true
+	st.refreshTokens[tokenHash] = t
+	return nil
+}
+
+func (s refreshTokens) RevokeFamily(ctx context.Context, familyID string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for h, t := range st.refreshTokens {
+		if t.FamilyID == familyID {
+			delete(st.refreshTokens, h)
+		}
+	}
+	return nil
+}
+
+func (s refreshTokens) RevokeUser(ctx context.Context, userID string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for h, t := range st.refreshTokens {
+		if t.UserID == userID {
+			delete(st.refreshTokens, h)
+		}
+	}
+	return nil
+}
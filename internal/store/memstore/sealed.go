@@ -0,0 +1,74 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type sealed struct{ d *db }
+
+func (s sealed) SetAccessKey(ctx context.Context, userID, keyHash string) error {
+	return users(s).update(userID, nil, func(u *user) { u.SealedKeyHash = keyHash })
+}
+
+func (s sealed) AccessKey(ctx context.Context, recipientID, chatID string) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	u, ok := st.users[recipientID]
+	if !ok || u.DisabledAt.Valid || u.SealedKeyHash == "" {
+		return "", store.ErrNotFound
+	}
+	if c, ok := st.chats[chatID]; !ok || c.IsGroup {
+		return "", store.ErrNotFound
+	}
+	if _, ok := st.members[chatID][recipientID]; !ok {
+		return "", store.ErrNotFound
+	}
+	return u.SealedKeyHash, nil
+}
+
+func today() string { return time.Now().Format(time.DateOnly) }
+
+func (s sealed) IssuedToday(ctx context.Context, userID string) (int, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	return st.issued[issuance{userID, today()}], nil
+}
+
+func (s sealed) AddTokens(ctx context.Context, userID string, tokenHashes []string, expiresAt time.Time) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, h := range tokenHashes {
+		if _, ok := st.tokens[h]; ok {
+			return store.ErrConflict
+		}
+		st.tokens[h] = expiresAt
+	}
+	day := today()
+	st.issued[issuance{userID, day}] += len(tokenHashes)
+	now := time.Now()
+	for h, exp := range st.tokens {
+		if exp.Before(now) {
+			delete(st.tokens, h)
+		}
+	}
+	for k := range st.issued {
+		if k.Day < day {
+			delete(st.issued, k)
+		}
+	}
+	return nil
+}
+
+func (s sealed) SpendToken(ctx context.Context, tokenHash string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	exp, ok := st.tokens[tokenHash]
+	if !ok || !exp.After(time.Now()) {
+		return store.ErrNotFound
+	}
+	delete(st.tokens, tokenHash)
+	return nil
+}
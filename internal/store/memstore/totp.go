@@ -0,0 +1,82 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type totp struct{ d *db }
+
+func (s totp) Get(ctx context.Context, userID string) (store.TOTP, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	u, ok := st.users[userID]
+	if !ok {
+		return store.TOTP{}, store.ErrNotFound
+	}
+	return store.TOTP{
+		Secret: u.TOTPSecret, EnabledAt: u.TOTPEnabledAt, LastStep: u.TOTPLastStep,
+		FailedAttempts: u.TOTPFailedAttempts, LockedUntil: u.TOTPLockedUntil,
+	}, nil
+}
+
+func (s totp) Lock(ctx context.Context, userID string) (store.TOTP, error) { return s.Get(ctx, userID) }
+
+func (s totp) Start(ctx context.Context, userID, secret string) error {
+	err := users(s).update(userID, func(u user) bool { return !u.TOTPEnabledAt.Valid }, func(u *user) { u.TOTPSecret = nullString(secret) })
+	if err == store.ErrNotFound {
+		return store.ErrConflict
+	}
+	return err
+}
+
+func (s totp) Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	err := users(s).update(userID, nil, func(u *user) { u.TOTPEnabledAt, u.TOTPLastStep = nullTime(time.Now()), step })
+	if err != nil {
+		return err
+	}
+	st, unlock := s.d.lock()
+	defer unlock()
+	codes := make([]recoveryCode, len(recoveryCodeHashes))
+	for i, h := range recoveryCodeHashes {
+		codes[i] = recoveryCode{Hash: h}
+	}
+	st.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s totp) Disable(ctx context.Context, userID string) error {
+	err := users(s).update(userID, nil, func(u *user) {
+		u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = sql.NullString{}, sql.NullTime{}, 0
+	})
+	if err != nil {
+		return err
+	}
+	st, unlock := s.d.lock()
+	defer unlock()
+	delete(st.recoveryCodes, userID)
+	return nil
+}
+
+func (s totp) AdvanceStep(ctx context.Context, userID string, step int64) error {
+	return users(s).update(userID, func(u user) bool { return u.TOTPLastStep < step }, func(u *user) { u.TOTPLastStep = step })
+}
+
+func (s totp) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for i, c := range st.recoveryCodes[userID] {
+		if c.Hash == codeHash && !c.Used {
+			st.recoveryCodes[userID][i].Used = true
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s totp) SetFailures(ctx context.Context, userID string, failures int, lockedUntil sql.NullTime) error {
+	return users(s).update(userID, nil, func(u *user) { u.TOTPFailedAttempts, u.TOTPLockedUntil = failures, lockedUntil })
+}
@@ -0,0 +1,246 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"messagingapi/internal/store"
+)
+
+type users struct{ d *db }
+
+func (s users) Get(ctx context.Context, id string) (store.User, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	u, ok := st.users[id]
+	if !ok {
+		return store.User{}, store.ErrNotFound
+	}
+	return u.User, nil
+}
+
+func (s users) GetByUsername(ctx context.Context, username string) (store.User, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, u := range st.users {
+		if u.Username == username {
+			return u.User, nil
+		}
+	}
+	return store.User{}, store.ErrNotFound
+}
+
+func (s users) Lock(ctx context.Context, id string) (store.User, error) { return s.Get(ctx, id) }
+
+func (s users) Create(ctx context.Context, nu store.NewUser) (string, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	for _, u := range st.users {
+		if u.Username == nu.Username {
+			return "", store.ErrConflict
+		}
+	}
+	id := newID()
+	st.users[id] = user{
+		User: store.User{
+			ID: id, Username: nu.Username, DisplayName: nu.DisplayName, PasswordHash: nu.PasswordHash, PINHash: nu.PINHash,
+			PublicKey: nu.PublicKey, CreatedAt: s.d.now(),
+		},
+		InviteID: nu.InviteID,
+	}
+	return id, nil
+}
+
+// update applies fn to the user id if it exists and match accepts it.
+func (s users) update(id string, match func(user) bool, fn func(*user)) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	u, ok := st.users[id]
+	if !ok || (match != nil && !match(u)) {
+		return store.ErrNotFound
+	}
+	fn(&u)
+	st.users[id] = u
+	return nil
+}
+
+func (s users) SetCredentials(ctx context.Context, id, passwordHash, pinHash string) error {
+	return s.update(id, nil, func(u *user) {
+		u.PasswordHash, u.PINHash, u.MustChangeCredentials = passwordHash, pinHash, false
+	})
+}
+
+func (s users) ReplaceCredentials(ctx context.Context, id, oldPasswordHash, oldPINHash, passwordHash, pinHash string) error {
+	err := s.update(id, func(u user) bool { return u.PasswordHash == oldPasswordHash && u.PINHash == oldPINHash }, func(u *user) {
+		u.PasswordHash, u.PINHash = passwordHash, pinHash
+	})
+	if err == store.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s users) SetPublicKey(ctx context.Context, id, publicKey string) (time.Time, error) {
+	var changedAt time.Time
+	err := s.update(id, nil, func(u *user) {
+		changedAt = s.d.now()
+		u.PublicKey, u.PublicKeyChangedAt = publicKey, nullTime(changedAt)
+	})
+	return changedAt, err
+}
+
+func (s users) SetAvatar(ctx context.Context, id, path string) error {
+	return s.update(id, nil, func(u *user) { u.AvatarPath = nullString(path) })
+}
+
+func (s users) ScheduleDeletion(ctx context.Context, id string, at time.Time) error {
+	return s.update(id, nil, func(u *user) { u.DeletionScheduledAt = nullTime(at) })
+}
+
+func (s users) CancelDeletion(ctx context.Context, id string) error {
+	return s.update(id, func(u user) bool { return u.DeletionScheduledAt.Valid }, func(u *user) { u.DeletionScheduledAt.Valid = false })
+}
+
+func (s users) CountAdmins(ctx context.Context) (int, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	n := 0
+	for _, u := range st.users {
+		if u.IsAdmin && !u.DeletionScheduledAt.Valid {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s users) TouchLastActive(ctx context.Context, id string) error {
+	_ = s.update(id, nil, func(u *user) { u.LastActiveAt = nullTime(time.Now()) })
+	return nil
+}
+
+func (s users) List(ctx context.Context, f store.UserFilter) ([]store.User, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	q := strings.ToLower(f.Query)
+	now := time.Now()
+	suspended := func(u user) bool { return u.SuspendedUntil.Valid && u.SuspendedUntil.Time.After(now) }
+	list := sorted(st.users, func(u user) bool {
+		if q != "" && !strings.Contains(strings.ToLower(u.Username), q) && !strings.Contains(strings.ToLower(u.DisplayName), q) {
+			return false
+		}
+		switch f.Status {
+		case "disabled":
+			return u.DisabledAt.Valid
+		case "suspended":
+			return suspended(u)
+		case "active":
+			return !u.DisabledAt.Valid && !suspended(u)
+		case "admin":
+			return u.IsAdmin
+		case "":
+			return true
+		}
+		return false
+	}, func(a, b user) int { return strings.Compare(a.Username, b.Username) })
+	var out []store.User
+	for _, u := range page(list, f.Limit, f.Offset) {
+		out = append(out, u.User)
+	}
+	return out, nil
+}
+
+func (s users) Usage(ctx context.Context, id string) (store.Usage, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var u store.Usage
+	for _, m := range st.messages {
+		if m.SenderID.String == id && m.SenderID.Valid {
+			u.MessageCount++
+		}
+	}
+	for _, a := range st.attachments {
+		if m := st.messages[a.MessageID]; m.SenderID.Valid && m.SenderID.String == id {
+			u.AttachmentCount++
+			u.AttachmentBytes += a.SizeBytes
+		}
+	}
+	return u, nil
+}
+
+func (s users) Disable(ctx context.Context, id string) error {
+	return s.update(id, nil, func(u *user) { u.DisabledAt = nullTime(time.Now()) })
+}
+
+func (s users) Suspend(ctx context.Context, id string, until time.Time) error {
+	return s.update(id, nil, func(u *user) { u.SuspendedUntil = nullTime(until) })
+}
+
+func (s users) Enable(ctx context.Context, id string) error {
+	return s.update(id, nil, func(u *user) { u.DisabledAt.Valid, u.SuspendedUntil.Valid = false, false })
+}
+
+func (s users) SetAdmin(ctx context.Context, id string, admin bool) error {
+	return s.update(id, func(u user) bool { return admin || u.IsAdmin }, func(u *user) { u.IsAdmin = admin })
+}
+
+func (s users) SetInviteQuota(ctx context.Context, id string, quota *int) error {
+	return s.update(id, nil, func(u *user) {
+		u.InviteQuota.Valid = quota != nil
+		if quota != nil {
+			u.InviteQuota.Int64 = int64(*quota)
+		}
+	})
+}
+
+func (s users) SetCredentialFailures(ctx context.Context, id string, failures int, lockedUntil sql.NullTime) error {
+	return s.update(id, nil, func(u *user) { u.FailedCredentialAttempts, u.CredentialsLockedUntil = failures, lockedUntil })
+}
+
+func (s users) ResetCredentials(ctx context.Context, id, passwordHash, pinHash string) error {
+	return s.update(id, nil, func(u *user) {
+		u.PasswordHash, u.PINHash, u.MustChangeCredentials = passwordHash, pinHash, true
+		u.FailedCredentialAttempts, u.CredentialsLockedUntil = 0, sql.NullTime{}
+	})
+}
+
+func (s users) DisableBranch(ctx context.Context, rootID, exceptID string) (int64, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	var n int64
+	now := time.Now()
+	for id := range st.branch(rootID) {
+		u := st.users[id]
+		if u.DisabledAt.Valid || id == exceptID {
+			continue
+		}
+		u.DisabledAt = nullTime(now)
+		st.users[id] = u
+		n++
+	}
+	return n, nil
+}
+
+// branch returns rootID and everyone who registered, directly or
+// transitively, through an invite they created.
+func (st *state) branch(rootID string) map[string]bool {
+	in := map[string]bool{}
+	if _, ok := st.users[rootID]; !ok {
+		return in
+	}
+	in[rootID] = true
+	for grew := true; grew; {
+		grew = false
+		for id, u := range st.users {
+			if in[id] || u.InviteID == "" {
+				continue
+			}
+			if inv, ok := st.invites[u.InviteID]; ok && inv.CreatedBy.Valid && in[inv.CreatedBy.String] {
+				in[id] = true
+				grew = true
+			}
+		}
+	}
+	return in
+}
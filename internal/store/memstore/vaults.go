@@ -0,0 +1,66 @@
+package memstore
+
+import (
+	"context"
+
+	"messagingapi/internal/store"
+)
+
+type vaults struct{ d *db }
+
+func (s vaults) Get(ctx context.Context, userID string) (store.Vault, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	v, ok := st.vaults[userID]
+	if !ok {
+		return store.Vault{}, store.ErrNotFound
+	}
+	return v, nil
+}
+
+func (s vaults) Lock(ctx context.Context, userID string) (store.Vault, error) {
+	return s.Get(ctx, userID)
+}
+
+func (s vaults) Create(ctx context.Context, userID, ciphertext string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if _, ok := st.vaults[userID]; ok {
+		return store.ErrConflict
+	}
+	if _, ok := st.users[userID]; !ok {
+		return store.ErrNotFound
+	}
+	now := s.d.now()
+	st.vaults[userID] = store.Vault{UserID: userID, Ciphertext: ciphertext, Version: 1, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+func (s vaults) update(userID string, fn func(*store.Vault)) (store.Vault, error) {
+	st, unlock := s.d.lock()
+	defer unlock()
+	v, ok := st.vaults[userID]
+	if !ok {
+		return v, store.ErrNotFound
+	}
+	fn(&v)
+	st.vaults[userID] = v
+	return v, nil
+}
+
+func (s vaults) Update(ctx context.Context, userID, ciphertext string) (int, error) {
+	v, err := s.update(userID, func(v *store.Vault) {
+		v.Ciphertext, v.Version, v.UpdatedAt = ciphertext, v.Version+1, s.d.now()
+	})
+	return v.Version, err
+}
+
+func (s vaults) Delete(ctx context.Context, userID string) error {
+	st, unlock := s.d.lock()
+	defer unlock()
+	if _, ok := st.vaults[userID]; !ok {
+		return store.ErrNotFound
+	}
+	delete(st.vaults, userID)
+	return nil
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type pgMessages struct{ pg }
+
+// messageColumns selects a message as seen by the device $1. For fan-out
+// messages the ciphertext is that device's envelope; queries must also
+// filter with messageVisible so envelopes addressed elsewhere are skipped.
+const messageColumns = `m.id, m.chat_id, m.sender_id, COALESCE(CASE WHEN m.fanout THEN e.ciphertext ELSE m.ciphertext END, ''), m.nonce, m.reply_to, m.is_deleted, m.edited_at, m.created_at, m.event, m.fanout, m.sealed, m.tx_id::text::bigint, m.seq,
+	COALESCE((SELECT json_agg(json_build_object('id', a.id, 'contentType', a.content_type, 'sizeBytes', a.size_bytes) ORDER BY a.created_at) FROM attachments a WHERE a.message_id=m.id), '[]')
+	FROM messages m LEFT JOIN message_envelopes e ON e.message_id=m.id AND e.device_id=$1`
+
+const messageVisible = `(NOT m.fanout OR e.device_id IS NOT NULL)`
+
+func scanMessage(rows *sql.Rows) (Message, error) {
+	var m Message
+	var event, attachments []byte
+	err := rows.Scan(&m.ID, &m.ChatID, &m.SenderID, &m.Ciphertext, &m.Nonce, &m.ReplyTo, &m.IsDeleted, &m.EditedAt, &m.CreatedAt, &event, &m.Fanout, &m.Sealed, &m.Position.Tx, &m.Position.Seq, &attachments)
+	m.Event, m.Attachments = event, attachments
+	return m, err
+}
+
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (s pgMessages) Create(ctx context.Context, m NewMessage) (string, error) {
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO messages (chat_id, sender_id, ciphertext, nonce, reply_to, fanout, sealed) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id`,
+		m.ChatID, nullable(m.SenderID), m.Ciphertext, m.Nonce, m.ReplyTo, m.Fanout, m.Sealed).Scan(&id)
+	return id, err
+}
+
+func (s pgMessages) AddEnvelope(ctx context.Context, messageID, chatID, deviceID, ciphertext string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `INSERT INTO message_envelopes (message_id, device_id, ciphertext)
+		SELECT $1, d.id, $3 FROM devices d JOIN chat_members cm ON cm.user_id=d.user_id AND cm.chat_id=$4 WHERE d.id=$2
+		ON CONFLICT DO NOTHING`, messageID, deviceID, ciphertext, chatID))
+}
+
+func (s pgMessages) Get(ctx context.Context, id string) (Message, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+messageColumns+` WHERE m.id=$2`, nil, id)
+	list, err := collect(rows, err, scanMessage)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(list) == 0 {
+		return Message{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func (s pgMessages) Edit(ctx context.Context, id, ciphertext, nonce string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE messages SET ciphertext=$1, nonce=$2, edited_at=now() WHERE id=$3`, ciphertext, nonce, id))
+}
+
+func (s pgMessages) Delete(ctx context.Context, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM messages WHERE id=$1`, id))
+}
+
+func (s pgMessages) DeleteByChat(ctx context.Context, chatID string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM messages WHERE chat_id=$1`, chatID)
+	return err
+}
+
+func (s pgMessages) History(ctx context.Context, chatID, deviceID string, beforeAt time.Time, beforeID string, limit int) ([]Message, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+messageColumns+` WHERE m.chat_id=$2 AND (m.created_at, m.id) < ($3, $4::uuid) AND `+messageVisible+`
+		ORDER BY m.created_at DESC, m.id DESC LIMIT $5`, nullable(deviceID), chatID, beforeAt, beforeID, limit)
+	return collect(rows, err, scanMessage)
+}
+
+// Sync holds back messages of transactions at or after the snapshot's xmin:
+// those may still be running, and once they commit their messages sort
+// after everything returned here. The price is that any open transaction,
+// even an unrelated one, delays every user's sync until it ends; the app's
+// connections bound that with idle_in_transaction_session_timeout and
+// statement_timeout (see db.Connect).
+func (s pgMessages) Sync(ctx context.Context, userID, deviceID string, after SyncPosition, limit int) ([]Message, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+messageColumns+` JOIN chat_members cm ON cm.chat_id=m.chat_id AND cm.user_id=$2
+		WHERE (m.tx_id, m.seq) > ($3::text::xid8, $4) AND m.tx_id < pg_snapshot_xmin(pg_current_snapshot()) AND `+messageVisible+`
+		ORDER BY m.tx_id, m.seq LIMIT $5`, nullable(deviceID), userID, after.Tx, after.Seq, limit)
+	return collect(rows, err, scanMessage)
+}
+
+// exportPage is how many messages Export reads per query.
+const exportPage = 500
+
+// Export reads the chat in keyset pages and calls fn between queries, so a
+// slow consumer never keeps a statement running into statement_timeout.
+func (s pgMessages) Export(ctx context.Context, chatID, userID string, fn func(ExportedMessage) error) error {
+	afterAt, afterID := time.Time{}, uuid.Nil.String()
+	for {
+		page, err := s.exportPage(ctx, chatID, userID, afterAt, afterID)
+		if err != nil {
+			return err
+		}
+		for _, m := range page {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterAt, afterID = last.CreatedAt, last.ID
+	}
+}
+
+func (s pgMessages) exportPage(ctx context.Context, chatID, userID string, afterAt time.Time, afterID string) ([]ExportedMessage, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT m.id, m.chat_id, m.sender_id, m.ciphertext, m.nonce, m.reply_to, m.is_deleted, m.edited_at, m.created_at, m.event, m.fanout, m.sealed,
+		COALESCE((SELECT json_agg(json_build_object('id', a.id, 'path', a.file_path, 'contentType', a.content_type, 'sizeBytes', a.size_bytes) ORDER BY a.created_at) FROM attachments a WHERE a.message_id=m.id), '[]'),
+		COALESCE((SELECT json_agg(json_build_object('deviceId', e.device_id, 'ciphertext', e.ciphertext) ORDER BY d.created_at) FROM message_envelopes e JOIN devices d ON d.id=e.device_id WHERE e.message_id=m.id AND d.user_id=$2), '[]')
+		FROM messages m WHERE m.chat_id=$1 AND (m.created_at, m.id) > ($3, $4::uuid) ORDER BY m.created_at, m.id LIMIT $5`, chatID, userID, afterAt, afterID, exportPage)
+	return collect(rows, err, scanExportedMessage)
+}
+
+func scanExportedMessage(rows *sql.Rows) (ExportedMessage, error) {
+	var m ExportedMessage
+	var event, files, envelopes []byte
+	if err := rows.Scan(&m.ID, &m.ChatID, &m.SenderID, &m.Ciphertext, &m.Nonce, &m.ReplyTo, &m.IsDeleted, &m.EditedAt, &m.CreatedAt, &event, &m.Fanout, &m.Sealed, &files, &envelopes); err != nil {
+		return m, err
+	}
+	m.Event = event
+	var atts []struct {
+		ID          string `json:"id"`
+		Path        string `json:"path"`
+		ContentType string `json:"contentType"`
+		SizeBytes   int64  `json:"sizeBytes"`
+	}
+	var envs []struct {
+		DeviceID   string `json:"deviceId"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(files, &atts); err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(envelopes, &envs); err != nil {
+		return m, err
+	}
+	for _, a := range atts {
+		m.Files = append(m.Files, Attachment{ID: a.ID, MessageID: m.ID, ChatID: m.ChatID, FilePath: a.Path, ContentType: a.ContentType, SizeBytes: a.SizeBytes})
+	}
+	for _, e := range envs {
+		m.Envelopes = append(m.Envelopes, Envelope(e))
+	}
+	return m, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type pgPasskeys struct{ pg }
+
+func (s pgPasskeys) List(ctx context.Context, userID string) ([]Passkey, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+		user_verified, backup_eligible, backup_state, created_at, last_used_at FROM webauthn_credentials WHERE user_id=$1 ORDER BY created_at, id`, userID)
+	return collect(rows, err, func(rows *sql.Rows) (Passkey, error) {
+		var p Passkey
+		var transports string
+		var signCount int64
+		err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.PublicKey, &p.AttestationType, &transports, &p.AAGUID, &signCount,
+			&p.UserVerified, &p.BackupEligible, &p.BackupState, &p.CreatedAt, &p.LastUsedAt)
+		p.SignCount = uint32(signCount)
+		if transports != "" {
+			p.Transports = strings.Split(transports, ",")
+		}
+		return p, err
+	})
+}
+
+func (s pgPasskeys) Count(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM webauthn_credentials WHERE user_id=$1`, userID).Scan(&n)
+	return n, err
+}
+
+func (s pgPasskeys) Create(ctx context.Context, p Passkey) (string, error) {
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count, user_verified, backup_eligible, backup_state)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id`,
+		p.UserID, p.Name, p.CredentialID, p.PublicKey, p.AttestationType, strings.Join(p.Transports, ","), p.AAGUID, int64(p.SignCount),
+		p.UserVerified, p.BackupEligible, p.BackupState).Scan(&id)
+	return id, translate(err)
+}
+
+func (s pgPasskeys) Delete(ctx context.Context, userID, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id=$1 AND user_id=$2`, id, userID))
+}
+
+func (s pgPasskeys) RecordUse(ctx context.Context, credentialID []byte, signCount uint32, userVerified, backupState bool) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count=$1, user_verified=$2, backup_state=$3, last_used_at=now() WHERE credential_id=$4`,
+		int64(signCount), userVerified, backupState, credentialID))
+}
+
+func (s pgPasskeys) SaveSession(ctx context.Context, userID, purpose string, data json.RawMessage, expiresAt time.Time) (string, error) {
+	// Abandoned ceremonies are swept whenever a new one starts.
+	if _, err := s.q(ctx).ExecContext(ctx, `DELETE FROM webauthn_sessions WHERE expires_at < now()`); err != nil {
+		return "", err
+	}
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO webauthn_sessions (user_id, purpose, data, expires_at) VALUES ($1,$2,$3,$4) RETURNING id`,
+		nullable(userID), purpose, []byte(data), expiresAt).Scan(&id)
+	return id, err
+}
+
+func (s pgPasskeys) TakeSession(ctx context.Context, id, purpose string) (WebAuthnSession, error) {
+	var w WebAuthnSession
+	var data []byte
+	err := s.q(ctx).QueryRowContext(ctx, `DELETE FROM webauthn_sessions WHERE id=$1 AND purpose=$2 AND expires_at > now() RETURNING user_id, data`, id, purpose).Scan(&w.UserID, &data)
+	w.Data = data
+	return w, translate(err)
+}
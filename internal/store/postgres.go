@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+type pg struct{ db *sql.DB }
+
+// q returns the transaction carried by ctx, or the pool.
+func (p pg) q(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return p.db
+}
+
+// NewPostgres returns a Store backed by db.
+func NewPostgres(db *sql.DB) *Store {
+	p := pg{db: db}
+	return &Store{
+		Users:         pgUsers{p},
+		Chats:         pgChats{p},
+		Messages:      pgMessages{p},
+		Invites:       pgInvites{p},
+		Attachments:   pgAttachments{p},
+		Audit:         pgAudit{p},
+		Devices:       pgDevices{p},
+		Vaults:        pgVaults{p},
+		Passkeys:      pgPasskeys{p},
+		TOTP:          pgTOTP{p},
+		Contacts:      pgContacts{p},
+		Sealed:        pgSealed{p},
+		Challenges:    pgChallenges{p},
+		RefreshTokens: pgRefreshTokens{p},
+		Transactor:    p,
+	}
+}
+
+func (p pg) Begin(ctx context.Context) (context.Context, Tx, error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return ctx, joinedTx{}, nil
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, txKey{}, tx), sqlTx{tx}, nil
+}
+
+type sqlTx struct{ tx *sql.Tx }
+
+func (t sqlTx) Commit() error { return t.tx.Commit() }
+
+func (t sqlTx) Rollback() error {
+	if err := t.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+	return nil
+}
+
+// joinedTx is handed out by a nested Begin; the outer caller owns the
+// real transaction.
+type joinedTx struct{}
+
+func (joinedTx) Commit() error   { return nil }
+func (joinedTx) Rollback() error { return nil }
+
+// translate maps driver errors onto the package's sentinel errors. An id
+// that is not a valid UUID (22P02) cannot name a row, so it is not found,
+// as in memstore.
+func translate(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return ErrConflict
+		case "22P02":
+			return ErrNotFound
+		}
+	}
+	return err
+}
+
+// affected turns a write that matched no rows into ErrNotFound.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func collect[T any](rows *sql.Rows, err error, scan func(*sql.Rows) (T, error)) ([]T, error) {
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	var out []T
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func scanString(rows *sql.Rows) (string, error) {
+	var s string
+	err := rows.Scan(&s)
+	return s, err
+}
+
+// prefixed qualifies each column of a column list with table.
+func prefixed(table, columns string) string {
+	cols := strings.Split(columns, ",")
+	for i, c := range cols {
+		cols[i] = table + "." + strings.TrimSpace(c)
+	}
+	return strings.Join(cols, ", ")
+}
@@ -0,0 +1,13 @@
+package store_test
+
+import (
+	"testing"
+
+	"messagingapi/internal/db/dbtest"
+	"messagingapi/internal/store"
+	"messagingapi/internal/store/storetest"
+)
+
+func TestPostgres(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store { return store.NewPostgres(dbtest.Open(t)) })
+}
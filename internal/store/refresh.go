@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+type pgRefreshTokens struct{ pg }
+
+func (s pgRefreshTokens) Create(ctx context.Context, userID, familyID, tokenHash string, expiresAt time.Time) error {
+	if _, err := s.q(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	_, err := s.q(ctx).ExecContext(ctx, `INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at) VALUES ($1,$2,coalesce($3::uuid, gen_random_uuid()),$4)`,
+		tokenHash, userID, nullable(familyID), expiresAt)
+	return translate(err)
+}
+
+func (s pgRefreshTokens) Lock(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	var t RefreshToken
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT user_id, family_id, used_at IS NOT NULL FROM refresh_tokens WHERE token_hash=$1 AND expires_at > now() FOR UPDATE`, tokenHash).Scan(&t.UserID, &t.FamilyID, &t.Used)
+	return t, translate(err)
+}
+
+func (s pgRefreshTokens) Spend(ctx context.Context, tokenHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE refresh_tokens SET used_at=now() WHERE token_hash=$1 AND used_at IS NULL`, tokenHash))
+}
+
+func (s pgRefreshTokens) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE family_id=$1`, familyID)
+	return err
+}
+
+func (s pgRefreshTokens) RevokeUser(ctx context.Context, userID string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id=$1`, userID)
+	return err
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type pgSealed struct{ pg }
+
+func (s pgSealed) SetAccessKey(ctx context.Context, userID, keyHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET sealed_access_key_hash=$1, updated_at=now() WHERE id=$2`, nullable(keyHash), userID))
+}
+
+func (s pgSealed) AccessKey(ctx context.Context, recipientID, chatID string) (string, error) {
+	var hash sql.NullString
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT u.sealed_access_key_hash FROM users u
+		JOIN chat_members cm ON cm.user_id=u.id JOIN chats ch ON ch.id=cm.chat_id
+		WHERE u.id=$1 AND ch.id=$2 AND NOT ch.is_group AND u.disabled_at IS NULL`, recipientID, chatID).Scan(&hash)
+	if err == nil && !hash.Valid {
+		return "", ErrNotFound
+	}
+	return hash.String, translate(err)
+}
+
+func (s pgSealed) IssuedToday(ctx context.Context, userID string) (int, error) {
+	var issued int
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO delivery_token_issuance (user_id, day, issued) VALUES ($1, current_date, 0)
+		ON CONFLICT (user_id, day) DO UPDATE SET issued=delivery_token_issuance.issued RETURNING issued`, userID).Scan(&issued)
+	return issued, err
+}
+
+func (s pgSealed) AddTokens(ctx context.Context, userID string, tokenHashes []string, expiresAt time.Time) error {
+	for _, h := range tokenHashes {
+		if _, err := s.q(ctx).ExecContext(ctx, `INSERT INTO delivery_tokens (token_hash, expires_at) VALUES ($1,$2)`, h, expiresAt); err != nil {
+			return err
+		}
+	}
+	if _, err := s.q(ctx).ExecContext(ctx, `INSERT INTO delivery_token_issuance (user_id, day, issued) VALUES ($1, current_date, $2)
+		ON CONFLICT (user_id, day) DO UPDATE SET issued=delivery_token_issuance.issued+$2`, userID, len(tokenHashes)); err != nil {
+		return err
+	}
+	if _, err := s.q(ctx).ExecContext(ctx, `DELETE FROM delivery_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM delivery_token_issuance WHERE day < current_date`)
+	return err
+}
+
+func (s pgSealed) SpendToken(ctx context.Context, tokenHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM delivery_tokens WHERE token_hash=$1 AND expires_at > now()`, tokenHash))
+}
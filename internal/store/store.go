@@ -0,0 +1,581 @@
+// Package store is the data access layer behind the HTTP handlers. Handlers
+// depend on the interfaces here; Postgres is the production implementation.
+//
+// Transactions travel in the context: Begin returns a context carrying the
+// transaction, and every store call made with that context joins it.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the addressed row does not exist or a
+	// conditional update matched nothing.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write violates a uniqueness constraint.
+	ErrConflict = errors.New("conflict")
+)
+
+// Store groups the repositories of one database, one per aggregate.
+type Store struct {
+	Users         UserStore
+	Chats         ChatStore
+	Messages      MessageStore
+	Invites       InviteStore
+	Attachments   AttachmentStore
+	Audit         AuditStore
+	Devices       DeviceStore
+	Vaults        VaultStore
+	Passkeys      PasskeyStore
+	TOTP          TOTPStore
+	Contacts      ContactStore
+	Sealed        SealedStore
+	Challenges    ChallengeStore
+	RefreshTokens RefreshTokenStore
+	Transactor
+}
+
+// Transactor starts transactions. A Begin on a context that already carries
+// a transaction joins it; Commit and Rollback are then left to the outer
+// caller.
+type Transactor interface {
+	Begin(ctx context.Context) (context.Context, Tx, error)
+}
+
+// Tx ends a transaction started by Begin. Rollback after Commit is a no-op,
+// so it can always be deferred.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+type User struct {
+	ID                    string
+	Username              string
+	DisplayName           string
+	PasswordHash          string
+	PINHash               string
+	PublicKey             string
+	AvatarPath            sql.NullString
+	IsAdmin               bool
+	InviteQuota           sql.NullInt64
+	MustChangeCredentials bool
+	TOTPEnabledAt         sql.NullTime
+	DisabledAt            sql.NullTime
+	SuspendedUntil        sql.NullTime
+	LastActiveAt          sql.NullTime
+	DeletionScheduledAt   sql.NullTime
+	// FailedCredentialAttempts counts wrong PINs since the last correct
+	// one, across every endpoint that checks it.
+	FailedCredentialAttempts int
+	CredentialsLockedUntil   sql.NullTime
+	CreatedAt                time.Time
+}
+
+type NewUser struct {
+	Username     string
+	DisplayName  string
+	PasswordHash string
+	PINHash      string
+	PublicKey    string
+	InviteID     string
+}
+
+type UserStore interface {
+	Get(ctx context.Context, id string) (User, error)
+	GetByUsername(ctx context.Context, username string) (User, error)
+	// Lock is Get with the row locked until the transaction in ctx ends.
+	Lock(ctx context.Context, id string) (User, error)
+	// Create returns ErrConflict when the username is taken.
+	Create(ctx context.Context, u NewUser) (string, error)
+	// SetCredentials replaces both hashes and clears must_change_credentials.
+	SetCredentials(ctx context.Context, id, passwordHash, pinHash string) error
+	// ReplaceCredentials swaps the hashes only if they still equal the old
+	// ones, so a concurrent change is not overwritten.
+	ReplaceCredentials(ctx context.Context, id, oldPasswordHash, oldPINHash, passwordHash, pinHash string) error
+	// SetPublicKey replaces the identity key and returns when it changed.
+	SetPublicKey(ctx context.Context, id, publicKey string) (time.Time, error)
+	SetAvatar(ctx context.Context, id, path string) error
+	ScheduleDeletion(ctx context.Context, id string, at time.Time) error
+	// CancelDeletion returns ErrNotFound when no deletion was scheduled.
+	CancelDeletion(ctx context.Context, id string) error
+	// CountAdmins counts admins not scheduled for deletion.
+	CountAdmins(ctx context.Context) (int, error)
+	TouchLastActive(ctx context.Context, id string) error
+
+	// List pages users by username for the admin console.
+	List(ctx context.Context, f UserFilter) ([]User, error)
+	Usage(ctx context.Context, id string) (Usage, error)
+	Disable(ctx context.Context, id string) error
+	Suspend(ctx context.Context, id string, until time.Time) error
+	// Enable lifts both a disable and a suspension.
+	Enable(ctx context.Context, id string) error
+	// SetAdmin returns ErrNotFound when revoking from a non-admin.
+	SetAdmin(ctx context.Context, id string, admin bool) error
+	// SetInviteQuota overrides the configured quota; nil removes the override.
+	SetInviteQuota(ctx context.Context, id string, quota *int) error
+	// SetCredentialFailures records the failed-attempt count and lockout
+	// of the PIN checks.
+	SetCredentialFailures(ctx context.Context, id string, failures int, lockedUntil sql.NullTime) error
+	// ResetCredentials sets temporary hashes and forces a change on next
+	// login. It also clears the failed-attempt count and lockout.
+	ResetCredentials(ctx context.Context, id, passwordHash, pinHash string) error
+	// DisableBranch disables rootID and everyone below it in the invite
+	// tree, except exceptID, and returns how many accounts it disabled.
+	DisableBranch(ctx context.Context, rootID, exceptID string) (int64, error)
+}
+
+// UserFilter selects users for UserStore.List. Query matches username or
+// display name case-insensitively and literally; Status is one of active,
+// disabled, suspended or admin, or empty for all.
+type UserFilter struct {
+	Query  string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Usage is what a user's messages hold on the server.
+type Usage struct {
+	AttachmentBytes int64
+	AttachmentCount int64
+	MessageCount    int64
+}
+
+type JoinRequest struct {
+	UserID      string
+	Username    string
+	DisplayName string
+	Role        string
+	InviteID    sql.NullString
+	CreatedAt   time.Time
+}
+
+type Chat struct {
+	ID        string
+	Title     sql.NullString
+	IsGroup   bool
+	CreatedAt time.Time
+}
+
+type MemberDevice struct {
+	UserID   string
+	DeviceID string
+}
+
+type ChatStore interface {
+	Create(ctx context.Context, title string, isGroup bool, createdBy string) (string, error)
+	AddMember(ctx context.Context, chatID, userID, role string) error
+	// MemberRole returns ErrNotFound when userID is not a member.
+	MemberRole(ctx context.Context, chatID, userID string) (string, error)
+	IsMember(ctx context.Context, chatID, userID string) (bool, error)
+	// ChatIDs lists the chats userID is a member of.
+	ChatIDs(ctx context.Context, userID string) ([]string, error)
+	// List returns the chats userID is a member of, oldest first.
+	List(ctx context.Context, userID string) ([]Chat, error)
+	// MemberIDs lists a chat's members in the order they joined.
+	MemberIDs(ctx context.Context, chatID string) ([]string, error)
+	// ShareChat reports whether the two users are members of a common chat.
+	ShareChat(ctx context.Context, userID, otherID string) (bool, error)
+	// Join adds the user and posts a member_joined event, or files a join
+	// request when needsApproval is set; it reports whether it is pending.
+	Join(ctx context.Context, chatID, userID, role, inviteID string, needsApproval bool) (bool, error)
+	// PostEvent stores a plaintext system event in the chat's history.
+	PostEvent(ctx context.Context, chatID, userID string, event any) error
+	JoinRequests(ctx context.Context, chatID string) ([]JoinRequest, error)
+	// TakeJoinRequest deletes and returns a pending request.
+	TakeJoinRequest(ctx context.Context, chatID, userID string) (JoinRequest, error)
+	DeleteJoinRequest(ctx context.Context, chatID, userID string) error
+	MemberDevices(ctx context.Context, chatID string) ([]MemberDevice, error)
+}
+
+type Message struct {
+	ID         string
+	ChatID     string
+	SenderID   sql.NullString
+	Ciphertext string
+	Nonce      sql.NullString
+	ReplyTo    sql.NullString
+	IsDeleted  bool
+	EditedAt   sql.NullTime
+	CreatedAt  time.Time
+	Event      json.RawMessage
+	Fanout     bool
+	Sealed     bool
+	// Attachments is a JSON array of {id, contentType, sizeBytes}.
+	Attachments json.RawMessage
+	// Position is where the message sorts for Sync.
+	Position SyncPosition
+}
+
+// SyncPosition orders messages for Sync by the transaction that created
+// them, then by creation within it. Sync only returns messages of
+// transactions older than any still running, so a message that commits
+// late can never sort before a position Sync has already handed out.
+type SyncPosition struct {
+	Tx  int64
+	Seq int64
+}
+
+type NewMessage struct {
+	ChatID string
+	// SenderID is empty for sealed messages.
+	SenderID   string
+	Ciphertext string
+	Nonce      string
+	ReplyTo    *string
+	Fanout     bool
+	Sealed     bool
+}
+
+type Envelope struct {
+	DeviceID   string
+	Ciphertext string
+}
+
+// ExportedMessage is a message as written to a data export: with its
+// attachments' stored files and the envelopes addressed to the exporting
+// user's devices.
+type ExportedMessage struct {
+	Message
+	Files     []Attachment
+	Envelopes []Envelope
+}
+
+type MessageStore interface {
+	Create(ctx context.Context, m NewMessage) (string, error)
+	// AddEnvelope stores the copy of a fan-out message for one device. It
+	// returns ErrNotFound unless the device belongs to a member of chatID.
+	AddEnvelope(ctx context.Context, messageID, chatID, deviceID, ciphertext string) error
+	Get(ctx context.Context, id string) (Message, error)
+	Edit(ctx context.Context, id, ciphertext, nonce string) error
+	Delete(ctx context.Context, id string) error
+	DeleteByChat(ctx context.Context, chatID string) error
+	// History pages a chat newest first, strictly before (beforeAt, beforeID),
+	// as seen by deviceID ("" for none).
+	History(ctx context.Context, chatID, deviceID string, beforeAt time.Time, beforeID string, limit int) ([]Message, error)
+	// Sync pages all of userID's chats in SyncPosition order, strictly after
+	// after; the zero position starts with the oldest.
+	Sync(ctx context.Context, userID, deviceID string, after SyncPosition, limit int) ([]Message, error)
+	// Export calls fn for every message of chatID, oldest first, as seen by
+	// userID. Messages are read in pages between calls, so a slow fn does
+	// not hold a query open.
+	Export(ctx context.Context, chatID, userID string, fn func(ExportedMessage) error) error
+}
+
+type Invite struct {
+	ID               string
+	Code             string
+	CreatedBy        sql.NullString
+	MaxUses          int
+	Uses             int
+	Active           bool
+	ExpiresAt        sql.NullTime
+	Kind             string
+	ChatID           sql.NullString
+	Role             string
+	RequiresApproval bool
+}
+
+// Usable reports whether the invite can still be redeemed at now.
+func (i Invite) Usable(now time.Time) bool {
+	return i.Active && (!i.ExpiresAt.Valid || now.Before(i.ExpiresAt.Time)) && i.Uses < i.MaxUses
+}
+
+type NewInvite struct {
+	Code string
+	// CreatedBy is empty for invites not attributed to a user.
+	CreatedBy        string
+	MaxUses          int
+	ExpiresAt        *time.Time
+	Kind             string
+	ChatID           *string
+	Role             string
+	RequiresApproval bool
+}
+
+// InviteUpdate leaves nil fields unchanged; NoExpiry clears expires_at.
+type InviteUpdate struct {
+	Active    *bool
+	MaxUses   *int
+	ExpiresAt *time.Time
+	NoExpiry  bool
+}
+
+type InviteStore interface {
+	// Create returns ErrConflict when the code is taken.
+	Create(ctx context.Context, inv NewInvite) (string, error)
+	Get(ctx context.Context, id string) (Invite, error)
+	GetByCode(ctx context.Context, code, kind string) (Invite, error)
+	// Consume takes one use of a usable invite, or returns ErrNotFound.
+	// The check and increment are one statement, so concurrent redemptions
+	// cannot exceed max_uses.
+	Consume(ctx context.Context, id string) error
+	// List returns every invite when all is set, else those of createdBy.
+	List(ctx context.Context, all bool, createdBy string) ([]Invite, error)
+	Update(ctx context.Context, id string, u InviteUpdate) error
+	Delete(ctx context.Context, id string) (Invite, error)
+	// SlotsUsed counts the registration slots a user's invites hold: uses
+	// already consumed plus the remaining capacity of invites that can
+	// still be redeemed.
+	SlotsUsed(ctx context.Context, userID string) (int, error)
+	// Registrations lists the users registered through the invite.
+	Registrations(ctx context.Context, id string) ([]User, error)
+	// Tree returns the invite tree below rootID, or from every user not
+	// invited by another user when rootID is empty, in registration order.
+	Tree(ctx context.Context, rootID string) ([]TreeNode, error)
+	// DeactivateBranch deactivates every invite created by rootID or anyone
+	// below it in the invite tree and returns how many it deactivated.
+	DeactivateBranch(ctx context.Context, rootID string) (int64, error)
+}
+
+// TreeNode is a user in the invite tree. ParentID is the creator of the
+// invite the user registered with, or empty for a root.
+type TreeNode struct {
+	User
+	ParentID   string
+	InviteCode sql.NullString
+}
+
+type Attachment struct {
+	ID          string
+	MessageID   string
+	ChatID      string
+	FilePath    string
+	ContentType string
+	SizeBytes   int64
+}
+
+type AttachmentStore interface {
+	Create(ctx context.Context, messageID, path, contentType string, size int64) (string, error)
+	Get(ctx context.Context, id string) (Attachment, error)
+	PathsByMessage(ctx context.Context, messageID string) ([]string, error)
+	PathsByChat(ctx context.Context, chatID string) ([]string, error)
+}
+
+type AuditEntry struct {
+	ID         string
+	ActorID    sql.NullString
+	Action     string
+	TargetType string
+	TargetID   string
+	Details    json.RawMessage
+	CreatedAt  time.Time
+}
+
+// AuditFilter selects entries for AuditStore.List; empty fields match all.
+type AuditFilter struct {
+	ActorID  string
+	TargetID string
+	Action   string
+	Limit    int
+	Offset   int
+}
+
+type AuditStore interface {
+	// Record appends an audit_log entry; call it with the transaction of
+	// the change it describes.
+	Record(ctx context.Context, actorID, action, targetType, targetID string, details any) error
+	// List returns matching entries, newest first.
+	List(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+}
+
+type Device struct {
+	ID         string
+	UserID     string
+	Name       string
+	CreatedAt  time.Time
+	LastSeenAt sql.NullTime
+}
+
+type DeviceStore interface {
+	List(ctx context.Context, userID string) ([]Device, error)
+	Create(ctx context.Context, userID, name string) (string, error)
+	// Delete removes a device of userID and every envelope addressed to it.
+	Delete(ctx context.Context, userID, id string) error
+	// Touch records use of a device and returns ErrNotFound unless userID
+	// owns it.
+	Touch(ctx context.Context, userID, id string) error
+}
+
+// Vault is a client-encrypted backup of a user's private keys.
+type Vault struct {
+	UserID     string
+	Ciphertext string
+	Version    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type VaultStore interface {
+	Get(ctx context.Context, userID string) (Vault, error)
+	// Lock is Get with the row locked until the transaction in ctx ends.
+	Lock(ctx context.Context, userID string) (Vault, error)
+	// Create returns ErrConflict when the user already has a vault.
+	Create(ctx context.Context, userID, ciphertext string) error
+	// Update replaces the ciphertext and returns the new version.
+	Update(ctx context.Context, userID, ciphertext string) (int, error)
+	Delete(ctx context.Context, userID string) error
+}
+
+// Passkey is a stored WebAuthn credential.
+type Passkey struct {
+	ID              string
+	UserID          string
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	UserVerified    bool
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      sql.NullTime
+}
+
+// WebAuthnSession is the server half of a ceremony in progress. UserID is
+// NULL for discoverable logins.
+type WebAuthnSession struct {
+	UserID sql.NullString
+	Data   json.RawMessage
+}
+
+type PasskeyStore interface {
+	List(ctx context.Context, userID string) ([]Passkey, error)
+	Count(ctx context.Context, userID string) (int, error)
+	// Create returns ErrConflict when the credential is already registered.
+	Create(ctx context.Context, p Passkey) (string, error)
+	Delete(ctx context.Context, userID, id string) error
+	// RecordUse stores the sign counter and flags of a verified assertion.
+	RecordUse(ctx context.Context, credentialID []byte, signCount uint32, userVerified, backupState bool) error
+	// SaveSession stores a ceremony until expiresAt and sweeps expired ones.
+	// userID is empty for discoverable logins.
+	SaveSession(ctx context.Context, userID, purpose string, data json.RawMessage, expiresAt time.Time) (string, error)
+	// TakeSession deletes and returns an unexpired ceremony of purpose, so a
+	// challenge can only be answered once.
+	TakeSession(ctx context.Context, id, purpose string) (WebAuthnSession, error)
+}
+
+// TOTP is a user's authenticator enrollment. Secret is set once enrollment
+// starts and EnabledAt once the first code is confirmed; LastStep is the
+// newest time step accepted, so codes cannot be replayed. FailedAttempts
+// counts wrong codes at login since the last accepted one.
+type TOTP struct {
+	Secret         sql.NullString
+	EnabledAt      sql.NullTime
+	LastStep       int64
+	FailedAttempts int
+	LockedUntil    sql.NullTime
+}
+
+type TOTPStore interface {
+	Get(ctx context.Context, userID string) (TOTP, error)
+	// Lock is Get with the user row locked until the transaction in ctx ends.
+	Lock(ctx context.Context, userID string) (TOTP, error)
+	// Start stores a new unconfirmed secret. It returns ErrConflict while
+	// TOTP is enabled.
+	Start(ctx context.Context, userID, secret string) error
+	// Enable confirms the enrollment at step and replaces the recovery codes.
+	Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	// Disable removes the secret and the recovery codes.
+	Disable(ctx context.Context, userID string) error
+	// AdvanceStep records an accepted code. It returns ErrNotFound when the
+	// step is not newer than the last one, i.e. the code was replayed.
+	AdvanceStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode marks an unused code as used, or returns ErrNotFound.
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	// SetFailures records the failed login attempts and lockout.
+	SetFailures(ctx context.Context, userID string, failures int, lockedUntil sql.NullTime) error
+}
+
+// LoginChallenge is the state of a challenge token from the first login
+// step, identified by its jti.
+type LoginChallenge struct {
+	Failures int
+	Used     bool
+}
+
+type ChallengeStore interface {
+	// Lock returns the state of the challenge jti issued to userID, recording
+	// it when first presented, with the row locked until the transaction in
+	// ctx ends. Challenges past expiresAt are swept.
+	Lock(ctx context.Context, jti, userID string, expiresAt time.Time) (LoginChallenge, error)
+	// Update stores the wrong codes counted against the challenge and
+	// whether it is spent.
+	Update(ctx context.Context, jti string, c LoginChallenge) error
+}
+
+// RefreshToken is the state of a refresh token. Tokens rotate: each use
+// spends the token and issues the next one in the same family.
+type RefreshToken struct {
+	UserID   string
+	FamilyID string
+	Used     bool
+}
+
+type RefreshTokenStore interface {
+	// Create stores a token by hash in familyID, or in a new family when
+	// familyID is empty. Expired tokens are swept.
+	Create(ctx context.Context, userID, familyID, tokenHash string, expiresAt time.Time) error
+	// Lock returns an unexpired token with the row locked until the
+	// transaction in ctx ends, or ErrNotFound.
+	Lock(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// Spend marks the token used. It returns ErrNotFound when it already was.
+	Spend(ctx context.Context, tokenHash string) error
+	// RevokeFamily deletes every token of the family.
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeUser deletes every token of userID, ending all their sessions.
+	RevokeUser(ctx context.Context, userID string) error
+}
+
+// ContactKey is a user's identity key as seen by one of their contacts.
+type ContactKey struct {
+	PublicKey   string
+	ChangedAt   sql.NullTime
+	VerifiedKey sql.NullString
+	VerifiedAt  sql.NullTime
+}
+
+// Verification is a contact the user compared safety numbers with. Current
+// is false once the contact's key has changed since.
+type Verification struct {
+	ContactID  string
+	Current    bool
+	VerifiedAt time.Time
+}
+
+type ContactStore interface {
+	// Key returns contactID's key along with userID's verification of it.
+	Key(ctx context.Context, userID, contactID string) (ContactKey, error)
+	Verifications(ctx context.Context, userID string) ([]Verification, error)
+	// Verify pins publicKey for the contact. It returns ErrConflict unless
+	// publicKey is still the contact's current key.
+	Verify(ctx context.Context, userID, contactID, publicKey string) error
+	Unverify(ctx context.Context, userID, contactID string) error
+}
+
+type SealedStore interface {
+	// SetAccessKey stores the hash of a user's access key; "" disables
+	// sealed delivery to them.
+	SetAccessKey(ctx context.Context, userID, keyHash string) error
+	// AccessKey returns the access key hash of a recipient reachable by
+	// sealed sender in chatID: an enabled account in a 1:1 chat. It returns
+	// ErrNotFound otherwise, including when no key is set.
+	AccessKey(ctx context.Context, recipientID, chatID string) (string, error)
+	// IssuedToday returns how many delivery tokens userID got today and
+	// locks the count until the transaction in ctx ends.
+	IssuedToday(ctx context.Context, userID string) (int, error)
+	// AddTokens stores delivery tokens, unlinked from userID, and counts
+	// them against userID's day. Expired tokens and past days are swept.
+	AddTokens(ctx context.Context, userID string, tokenHashes []string, expiresAt time.Time) error
+	// SpendToken deletes an unexpired token, or returns ErrNotFound.
+	SpendToken(ctx context.Context, tokenHash string) error
+}
@@ -0,0 +1,815 @@
+// Package storetest is a contract test suite for store.Store
+// implementations. Run it from each implementation's tests so the
+// in-memory store used by handler tests keeps behaving like Postgres.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"messagingapi/internal/store"
+)
+
+// Run runs the suite. open must return a fresh store for each call; it may
+// already hold seed data.
+func Run(t *testing.T, open func(t *testing.T) *store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st *store.Store)
+	}{
+		{"Users", testUsers},
+		{"UserList", testUserList},
+		{"Transactions", testTransactions},
+		{"Chats", testChats},
+		{"Messages", testMessages},
+		{"SyncOrder", testSyncOrder},
+		{"Invites", testInvites},
+		{"InviteTree", testInviteTree},
+		{"Devices", testDevices},
+		{"Vaults", testVaults},
+		{"Passkeys", testPasskeys},
+		{"TOTP", testTOTP},
+		{"Challenges", testChallenges},
+		{"RefreshTokens", testRefreshTokens},
+		{"Contacts", testContacts},
+		{"Sealed", testSealed},
+		{"Audit", testAudit},
+		{"MalformedIDs", testMalformedIDs},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, open(t)) })
+	}
+}
+
+func wantErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: err = %v, want %v", what, err, want)
+	}
+}
+
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+// newUser creates a user with a unique username built from name.
+func newUser(t *testing.T, st *store.Store, name string, inviteID string) store.User {
+	t.Helper()
+	ctx := context.Background()
+	id, err := st.Users.Create(ctx, store.NewUser{Username: name + "-" + uuid.NewString()[:8], DisplayName: name, PasswordHash: "pwd", PINHash: "pin", PublicKey: "pk-" + name, InviteID: inviteID})
+	must(t, "create user", err)
+	u, err := st.Users.Get(ctx, id)
+	must(t, "get user", err)
+	return u
+}
+
+func testUsers(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	u := newUser(t, st, "alice", "")
+	_, err := st.Users.Create(ctx, store.NewUser{Username: u.Username, DisplayName: "dup"})
+	wantErr(t, "duplicate username", err, store.ErrConflict)
+	_, err = st.Users.Get(ctx, uuid.NewString())
+	wantErr(t, "unknown id", err, store.ErrNotFound)
+	got, err := st.Users.GetByUsername(ctx, u.Username)
+	must(t, "by username", err)
+	if got.ID != u.ID {
+		t.Fatalf("GetByUsername = %s, want %s", got.ID, u.ID)
+	}
+
+	lockedUntil := sql.NullTime{Time: time.Now().Add(time.Hour).Truncate(time.Second), Valid: true}
+	must(t, "credential failures", st.Users.SetCredentialFailures(ctx, u.ID, 5, lockedUntil))
+	got, _ = st.Users.Get(ctx, u.ID)
+	if got.FailedCredentialAttempts != 5 || !got.CredentialsLockedUntil.Time.Equal(lockedUntil.Time) {
+		t.Fatalf("after credential failures: %+v", got)
+	}
+	must(t, "reset", st.Users.ResetCredentials(ctx, u.ID, "tmp-pwd", "tmp-pin"))
+	got, _ = st.Users.Get(ctx, u.ID)
+	if !got.MustChangeCredentials || got.PasswordHash != "tmp-pwd" || got.FailedCredentialAttempts != 0 || got.CredentialsLockedUntil.Valid {
+		t.Fatalf("after reset: %+v", got)
+	}
+	must(t, "set credentials", st.Users.SetCredentials(ctx, u.ID, "pwd2", "pin2"))
+	// A stale compare-and-swap is ignored.
+	must(t, "stale replace", st.Users.ReplaceCredentials(ctx, u.ID, "pwd", "pin", "pwd3", "pin3"))
+	got, _ = st.Users.Get(ctx, u.ID)
+	if got.MustChangeCredentials || got.PasswordHash != "pwd2" {
+		t.Fatalf("after set and stale replace: %+v", got)
+	}
+	must(t, "replace", st.Users.ReplaceCredentials(ctx, u.ID, "pwd2", "pin2", "pwd3", "pin3"))
+	got, _ = st.Users.Get(ctx, u.ID)
+	if got.PasswordHash != "pwd3" || got.PINHash != "pin3" {
+		t.Fatalf("after replace: %+v", got)
+	}
+
+	wantErr(t, "cancel unscheduled deletion", st.Users.CancelDeletion(ctx, u.ID), store.ErrNotFound)
+	must(t, "schedule deletion", st.Users.ScheduleDeletion(ctx, u.ID, time.Now().Add(time.Hour)))
+	must(t, "cancel deletion", st.Users.CancelDeletion(ctx, u.ID))
+
+	admins, err := st.Users.CountAdmins(ctx)
+	must(t, "count admins", err)
+	wantErr(t, "revoke from non-admin", st.Users.SetAdmin(ctx, u.ID, false), store.ErrNotFound)
+	must(t, "grant admin", st.Users.SetAdmin(ctx, u.ID, true))
+	if n, _ := st.Users.CountAdmins(ctx); n != admins+1 {
+		t.Fatalf("CountAdmins = %d, want %d", n, admins+1)
+	}
+	must(t, "revoke admin", st.Users.SetAdmin(ctx, u.ID, false))
+
+	quota := 3
+	must(t, "set quota", st.Users.SetInviteQuota(ctx, u.ID, &quota))
+	if got, _ = st.Users.Get(ctx, u.ID); !got.InviteQuota.Valid || got.InviteQuota.Int64 != 3 {
+		t.Fatalf("InviteQuota = %+v", got.InviteQuota)
+	}
+	must(t, "clear quota", st.Users.SetInviteQuota(ctx, u.ID, nil))
+	if got, _ = st.Users.Get(ctx, u.ID); got.InviteQuota.Valid {
+		t.Fatalf("InviteQuota = %+v, want NULL", got.InviteQuota)
+	}
+
+	must(t, "suspend", st.Users.Suspend(ctx, u.ID, time.Now().Add(time.Hour)))
+	must(t, "disable", st.Users.Disable(ctx, u.ID))
+	must(t, "enable", st.Users.Enable(ctx, u.ID))
+	if got, _ = st.Users.Get(ctx, u.ID); got.DisabledAt.Valid || got.SuspendedUntil.Valid {
+		t.Fatalf("after enable: %+v", got)
+	}
+	wantErr(t, "disable unknown", st.Users.Disable(ctx, uuid.NewString()), store.ErrNotFound)
+}
+
+func testUserList(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	tag := uuid.NewString()[:8]
+	plain := newUser(t, st, "plain"+tag, "")
+	// Wildcards in the query match literally.
+	pct := newUser(t, st, "50%off"+tag, "")
+	under := newUser(t, st, "a_b"+tag, "")
+	// Decoys an unescaped % or _ would also match.
+	newUser(t, st, "50xoff"+tag, "")
+	newUser(t, st, "axb"+tag, "")
+	disabled := newUser(t, st, "gone"+tag, "")
+	must(t, "disable", st.Users.Disable(ctx, disabled.ID))
+
+	ids := func(f store.UserFilter) map[string]bool {
+		t.Helper()
+		if f.Limit == 0 {
+			f.Limit = 100
+		}
+		list, err := st.Users.List(ctx, f)
+		must(t, "list", err)
+		out := map[string]bool{}
+		for _, u := range list {
+			out[u.ID] = true
+		}
+		return out
+	}
+	if got := ids(store.UserFilter{Query: "0%off" + tag}); len(got) != 1 || !got[pct.ID] {
+		t.Errorf("query %%: %v", got)
+	}
+	if got := ids(store.UserFilter{Query: "a_b" + tag}); len(got) != 1 || !got[under.ID] {
+		t.Errorf("query _: %v", got)
+	}
+	if got := ids(store.UserFilter{Query: "PLAIN" + tag}); len(got) != 1 || !got[plain.ID] {
+		t.Errorf("case-insensitive query: %v", got)
+	}
+	if got := ids(store.UserFilter{Query: tag, Status: "disabled"}); len(got) != 1 || !got[disabled.ID] {
+		t.Errorf("status disabled: %v", got)
+	}
+	if got := ids(store.UserFilter{Query: tag, Status: "active"}); len(got) != 5 || got[disabled.ID] {
+		t.Errorf("status active: %v", got)
+	}
+	if got := ids(store.UserFilter{Query: tag, Limit: 2, Offset: 5}); len(got) != 1 {
+		t.Errorf("limit/offset: %v", got)
+	}
+}
+
+func testTransactions(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	u := newUser(t, st, "tx", "")
+
+	txCtx, tx, err := st.Begin(ctx)
+	must(t, "begin", err)
+	must(t, "disable", st.Users.Disable(txCtx, u.ID))
+	// A nested Begin joins; its Rollback leaves the outer transaction alone.
+	inner, innerTx, err := st.Begin(txCtx)
+	must(t, "nested begin", err)
+	must(t, "set avatar", st.Users.SetAvatar(inner, u.ID, "a.png"))
+	must(t, "nested rollback", innerTx.Rollback())
+	must(t, "rollback", tx.Rollback())
+	must(t, "rollback after rollback", tx.Rollback())
+	if got, _ := st.Users.Get(ctx, u.ID); got.DisabledAt.Valid || got.AvatarPath.Valid {
+		t.Fatalf("rolled back writes persisted: %+v", got)
+	}
+
+	txCtx, tx, err = st.Begin(ctx)
+	must(t, "begin", err)
+	must(t, "disable", st.Users.Disable(txCtx, u.ID))
+	must(t, "commit", tx.Commit())
+	must(t, "rollback after commit", tx.Rollback())
+	if got, _ := st.Users.Get(ctx, u.ID); !got.DisabledAt.Valid {
+		t.Fatal("committed write lost")
+	}
+}
+
+func testChats(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	owner, bob, carol := newUser(t, st, "owner", ""), newUser(t, st, "bob", ""), newUser(t, st, "carol", "")
+	chatID, err := st.Chats.Create(ctx, "room", true, owner.ID)
+	must(t, "create chat", err)
+	must(t, "add owner", st.Chats.AddMember(ctx, chatID, owner.ID, "owner"))
+	must(t, "add owner again", st.Chats.AddMember(ctx, chatID, owner.ID, "member"))
+	if role, _ := st.Chats.MemberRole(ctx, chatID, owner.ID); role != "owner" {
+		t.Fatalf("role = %q after duplicate add", role)
+	}
+	_, err = st.Chats.MemberRole(ctx, chatID, bob.ID)
+	wantErr(t, "role of non-member", err, store.ErrNotFound)
+
+	pending, err := st.Chats.Join(ctx, chatID, bob.ID, "member", "", true)
+	must(t, "request join", err)
+	if !pending {
+		t.Fatal("join with approval not pending")
+	}
+	if ok, _ := st.Chats.IsMember(ctx, chatID, bob.ID); ok {
+		t.Fatal("pending user is a member")
+	}
+	reqs, err := st.Chats.JoinRequests(ctx, chatID)
+	must(t, "join requests", err)
+	if len(reqs) != 1 || reqs[0].UserID != bob.ID || reqs[0].Username != bob.Username {
+		t.Fatalf("join requests = %+v", reqs)
+	}
+	req, err := st.Chats.TakeJoinRequest(ctx, chatID, bob.ID)
+	must(t, "take request", err)
+	if req.Role != "member" {
+		t.Fatalf("taken request = %+v", req)
+	}
+	_, err = st.Chats.TakeJoinRequest(ctx, chatID, bob.ID)
+	wantErr(t, "take twice", err, store.ErrNotFound)
+	wantErr(t, "delete missing request", st.Chats.DeleteJoinRequest(ctx, chatID, bob.ID), store.ErrNotFound)
+
+	pending, err = st.Chats.Join(ctx, chatID, bob.ID, "member", "", false)
+	must(t, "join", err)
+	if pending {
+		t.Fatal("join without approval pending")
+	}
+	members, err := st.Chats.MemberIDs(ctx, chatID)
+	must(t, "member ids", err)
+	if len(members) != 2 || members[0] != owner.ID || members[1] != bob.ID {
+		t.Fatalf("members = %v", members)
+	}
+	list, err := st.Chats.List(ctx, bob.ID)
+	must(t, "list", err)
+	if len(list) != 1 || list[0].ID != chatID || list[0].Title.String != "room" || !list[0].IsGroup {
+		t.Fatalf("chats = %+v", list)
+	}
+	if ok, _ := st.Chats.ShareChat(ctx, owner.ID, bob.ID); !ok {
+		t.Fatal("owner and bob share no chat")
+	}
+	if ok, _ := st.Chats.ShareChat(ctx, owner.ID, carol.ID); ok {
+		t.Fatal("owner and carol share a chat")
+	}
+	// Joining posts a member_joined event.
+	msgs, err := st.Messages.Sync(ctx, bob.ID, "", store.SyncPosition{}, 10)
+	must(t, "sync", err)
+	if len(msgs) != 1 || len(msgs[0].Event) == 0 {
+		t.Fatalf("events = %+v", msgs)
+	}
+}
+
+func testMessages(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	chatID, _ := st.Chats.Create(ctx, "", false, alice.ID)
+	must(t, "add alice", st.Chats.AddMember(ctx, chatID, alice.ID, "owner"))
+	must(t, "add bob", st.Chats.AddMember(ctx, chatID, bob.ID, "member"))
+	phone, err := st.Devices.Create(ctx, bob.ID, "phone")
+	must(t, "device", err)
+	laptop, _ := st.Devices.Create(ctx, bob.ID, "laptop")
+
+	first, err := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: alice.ID, Ciphertext: "c1", Nonce: "n1"})
+	must(t, "create", err)
+	reply := first
+	second, _ := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: alice.ID, Ciphertext: "c2", ReplyTo: &reply})
+	fanout, _ := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: alice.ID, Fanout: true})
+	must(t, "envelope", st.Messages.AddEnvelope(ctx, fanout, chatID, phone, "for-phone"))
+	wantErr(t, "envelope to unknown device", st.Messages.AddEnvelope(ctx, fanout, chatID, uuid.NewString(), "x"), store.ErrNotFound)
+	sealed, _ := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, Ciphertext: "c4", Sealed: true})
+	_, err = st.Attachments.Create(ctx, first, "/tmp/a", "image/png", 10)
+	must(t, "attachment", err)
+
+	m, err := st.Messages.Get(ctx, sealed)
+	must(t, "get sealed", err)
+	if m.SenderID.Valid || !m.Sealed {
+		t.Fatalf("sealed message = %+v", m)
+	}
+
+	// History runs newest first; the phone sees its envelope, the laptop
+	// does not see the fan-out message at all.
+	now := time.Now().Add(time.Hour)
+	hist, err := st.Messages.History(ctx, chatID, phone, now, uuid.Max.String(), 10)
+	must(t, "history", err)
+	if len(hist) != 4 || hist[0].ID != sealed || hist[1].ID != fanout || hist[1].Ciphertext != "for-phone" || hist[3].ID != first {
+		t.Fatalf("phone history = %+v", hist)
+	}
+	if string(hist[3].Attachments) == "[]" {
+		t.Fatalf("attachments missing: %s", hist[3].Attachments)
+	}
+	hist, _ = st.Messages.History(ctx, chatID, laptop, now, uuid.Max.String(), 10)
+	if len(hist) != 3 {
+		t.Fatalf("laptop history has %d messages, want 3", len(hist))
+	}
+	hist, _ = st.Messages.History(ctx, chatID, phone, hist[1].CreatedAt, hist[1].ID, 10)
+	if len(hist) != 1 || hist[0].ID != first {
+		t.Fatalf("history before second = %+v", hist)
+	}
+
+	synced, err := st.Messages.Sync(ctx, bob.ID, phone, store.SyncPosition{}, 2)
+	must(t, "sync", err)
+	if len(synced) != 2 || synced[0].ID != first || synced[1].ID != second {
+		t.Fatalf("sync page = %+v", synced)
+	}
+	synced, _ = st.Messages.Sync(ctx, bob.ID, phone, synced[1].Position, 10)
+	if len(synced) != 2 || synced[0].ID != fanout {
+		t.Fatalf("sync next page = %+v", synced)
+	}
+
+	var exported []store.ExportedMessage
+	must(t, "export", st.Messages.Export(ctx, chatID, bob.ID, func(m store.ExportedMessage) error {
+		exported = append(exported, m)
+		return nil
+	}))
+	if len(exported) != 4 || len(exported[0].Files) != 1 || exported[0].Files[0].FilePath != "/tmp/a" {
+		t.Fatalf("export = %+v", exported)
+	}
+	if e := exported[2].Envelopes; len(e) != 1 || e[0].DeviceID != phone || e[0].Ciphertext != "for-phone" {
+		t.Fatalf("exported envelopes = %+v", e)
+	}
+
+	must(t, "edit", st.Messages.Edit(ctx, second, "c2'", "n2"))
+	if m, _ := st.Messages.Get(ctx, second); m.Ciphertext != "c2'" || !m.EditedAt.Valid {
+		t.Fatalf("edited = %+v", m)
+	}
+	// Deleting a message drops its attachments and unlinks replies.
+	must(t, "delete", st.Messages.Delete(ctx, first))
+	if paths, _ := st.Attachments.PathsByChat(ctx, chatID); len(paths) != 0 {
+		t.Fatalf("attachments survived: %v", paths)
+	}
+	if m, _ := st.Messages.Get(ctx, second); m.ReplyTo.Valid {
+		t.Fatal("reply still points at deleted message")
+	}
+	wantErr(t, "delete twice", st.Messages.Delete(ctx, first), store.ErrNotFound)
+	// Deleting a device drops the envelopes addressed to it.
+	must(t, "delete device", st.Devices.Delete(ctx, bob.ID, phone))
+	hist, _ = st.Messages.History(ctx, chatID, phone, now, uuid.Max.String(), 10)
+	for _, m := range hist {
+		if m.ID == fanout {
+			t.Fatal("envelope of deleted device still visible")
+		}
+	}
+	must(t, "delete by chat", st.Messages.DeleteByChat(ctx, chatID))
+	if hist, _ = st.Messages.History(ctx, chatID, "", now, uuid.Max.String(), 10); len(hist) != 0 {
+		t.Fatalf("messages survived DeleteByChat: %d", len(hist))
+	}
+}
+
+// testSyncOrder checks that Sync neither skips nor repeats a message whose
+// transaction commits after a message created later.
+func testSyncOrder(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice := newUser(t, st, "alice", "")
+	chatID, _ := st.Chats.Create(ctx, "", false, alice.ID)
+	must(t, "add alice", st.Chats.AddMember(ctx, chatID, alice.ID, "owner"))
+	var seen []string
+	var cursor store.SyncPosition
+	sync := func() {
+		msgs, err := st.Messages.Sync(ctx, alice.ID, "", cursor, 100)
+		must(t, "sync", err)
+		for _, m := range msgs {
+			seen = append(seen, m.ID)
+			cursor = m.Position
+		}
+	}
+	sync()
+
+	txCtx, tx, err := st.Begin(ctx)
+	must(t, "begin", err)
+	defer tx.Rollback()
+	slow, err := st.Messages.Create(txCtx, store.NewMessage{ChatID: chatID, SenderID: alice.ID, Ciphertext: "slow"})
+	must(t, "create slow", err)
+	fast, err := st.Messages.Create(ctx, store.NewMessage{ChatID: chatID, SenderID: alice.ID, Ciphertext: "fast"})
+	must(t, "create fast", err)
+	// While the slow transaction is open, neither its message nor the one
+	// committed after it started is handed out: fast would otherwise move
+	// the cursor past slow.
+	sync()
+	if len(seen) != 0 {
+		t.Fatalf("synced %v while a transaction was open", seen)
+	}
+	must(t, "commit", tx.Commit())
+	sync()
+	if !slices.Equal(seen, []string{slow, fast}) {
+		t.Fatalf("synced %v after commit, want slow %s then fast %s", seen, slow, fast)
+	}
+}
+
+func testInvites(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice := newUser(t, st, "alice", "")
+	code := uuid.NewString()
+	id, err := st.Invites.Create(ctx, store.NewInvite{Code: code, CreatedBy: alice.ID, MaxUses: 2, Role: "member"})
+	must(t, "create", err)
+	_, err = st.Invites.Create(ctx, store.NewInvite{Code: code, MaxUses: 1, Role: "member"})
+	wantErr(t, "duplicate code", err, store.ErrConflict)
+	inv, err := st.Invites.GetByCode(ctx, code, "register")
+	must(t, "by code", err)
+	if inv.ID != id || inv.Kind != "register" || !inv.Active {
+		t.Fatalf("invite = %+v", inv)
+	}
+	_, err = st.Invites.GetByCode(ctx, code, "chat")
+	wantErr(t, "wrong kind", err, store.ErrNotFound)
+
+	if n, _ := st.Invites.SlotsUsed(ctx, alice.ID); n != 2 {
+		t.Fatalf("SlotsUsed = %d, want 2", n)
+	}
+	must(t, "consume", st.Invites.Consume(ctx, id))
+	must(t, "consume", st.Invites.Consume(ctx, id))
+	wantErr(t, "consume exhausted", st.Invites.Consume(ctx, id), store.ErrNotFound)
+
+	inactive := false
+	more := 5
+	must(t, "update", st.Invites.Update(ctx, id, store.InviteUpdate{Active: &inactive, MaxUses: &more}))
+	if inv, _ = st.Invites.Get(ctx, id); inv.Active || inv.MaxUses != 5 {
+		t.Fatalf("updated = %+v", inv)
+	}
+	// Inactive invites only hold the uses already consumed.
+	if n, _ := st.Invites.SlotsUsed(ctx, alice.ID); n != 2 {
+		t.Fatalf("SlotsUsed = %d, want 2", n)
+	}
+	past := time.Now().Add(-time.Hour)
+	active := true
+	must(t, "expire", st.Invites.Update(ctx, id, store.InviteUpdate{Active: &active, ExpiresAt: &past}))
+	wantErr(t, "consume expired", st.Invites.Consume(ctx, id), store.ErrNotFound)
+	must(t, "clear expiry", st.Invites.Update(ctx, id, store.InviteUpdate{NoExpiry: true}))
+	must(t, "consume", st.Invites.Consume(ctx, id))
+
+	list, err := st.Invites.List(ctx, false, alice.ID)
+	must(t, "list", err)
+	if len(list) != 1 || list[0].ID != id {
+		t.Fatalf("list = %+v", list)
+	}
+	bob := newUser(t, st, "bob", id)
+	regs, err := st.Invites.Registrations(ctx, id)
+	must(t, "registrations", err)
+	if len(regs) != 1 || regs[0].ID != bob.ID {
+		t.Fatalf("registrations = %+v", regs)
+	}
+	deleted, err := st.Invites.Delete(ctx, id)
+	must(t, "delete", err)
+	if deleted.Code != code || deleted.Uses != 3 {
+		t.Fatalf("deleted = %+v", deleted)
+	}
+	_, err = st.Invites.Get(ctx, id)
+	wantErr(t, "get deleted", err, store.ErrNotFound)
+}
+
+func testInviteTree(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	root := newUser(t, st, "root", "")
+	invite := func(by store.User) string {
+		id, err := st.Invites.Create(ctx, store.NewInvite{Code: uuid.NewString(), CreatedBy: by.ID, MaxUses: 5, Role: "member"})
+		must(t, "invite", err)
+		return id
+	}
+	fromRoot := invite(root)
+	child := newUser(t, st, "child", fromRoot)
+	grandchild := newUser(t, st, "grandchild", invite(child))
+	other := newUser(t, st, "other", "")
+	invite(other)
+
+	tree, err := st.Invites.Tree(ctx, root.ID)
+	must(t, "tree", err)
+	if len(tree) != 3 || tree[0].ID != root.ID || tree[0].ParentID != "" || tree[1].ID != child.ID || tree[1].ParentID != root.ID ||
+		tree[2].ID != grandchild.ID || tree[2].ParentID != child.ID || !tree[1].InviteCode.Valid {
+		t.Fatalf("tree = %+v", tree)
+	}
+	all, err := st.Invites.Tree(ctx, "")
+	must(t, "full tree", err)
+	seen := map[string]string{}
+	for _, n := range all {
+		seen[n.ID] = n.ParentID
+	}
+	if p, ok := seen[root.ID]; !ok || p != "" {
+		t.Fatal("root missing from full tree")
+	}
+	if seen[grandchild.ID] != child.ID {
+		t.Fatal("grandchild not under child in full tree")
+	}
+
+	n, err := st.Invites.DeactivateBranch(ctx, root.ID)
+	must(t, "deactivate branch", err)
+	if n != 2 {
+		t.Fatalf("deactivated %d invites, want 2", n)
+	}
+	n, err = st.Users.DisableBranch(ctx, root.ID, child.ID)
+	must(t, "disable branch", err)
+	if n != 2 {
+		t.Fatalf("disabled %d accounts, want 2", n)
+	}
+	if u, _ := st.Users.Get(ctx, child.ID); u.DisabledAt.Valid {
+		t.Fatal("excepted user disabled")
+	}
+	if u, _ := st.Users.Get(ctx, other.ID); u.DisabledAt.Valid {
+		t.Fatal("user outside the branch disabled")
+	}
+}
+
+func testDevices(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	id, err := st.Devices.Create(ctx, alice.ID, "phone")
+	must(t, "create", err)
+	wantErr(t, "touch by other user", st.Devices.Touch(ctx, bob.ID, id), store.ErrNotFound)
+	must(t, "touch", st.Devices.Touch(ctx, alice.ID, id))
+	list, err := st.Devices.List(ctx, alice.ID)
+	must(t, "list", err)
+	if len(list) != 1 || list[0].Name != "phone" || !list[0].LastSeenAt.Valid {
+		t.Fatalf("devices = %+v", list)
+	}
+	wantErr(t, "delete by other user", st.Devices.Delete(ctx, bob.ID, id), store.ErrNotFound)
+	must(t, "delete", st.Devices.Delete(ctx, alice.ID, id))
+}
+
+func testVaults(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	u := newUser(t, st, "alice", "")
+	_, err := st.Vaults.Get(ctx, u.ID)
+	wantErr(t, "get missing", err, store.ErrNotFound)
+	must(t, "create", st.Vaults.Create(ctx, u.ID, "v1"))
+	wantErr(t, "create twice", st.Vaults.Create(ctx, u.ID, "v1"), store.ErrConflict)
+	version, err := st.Vaults.Update(ctx, u.ID, "v2")
+	must(t, "update", err)
+	if version != 2 {
+		t.Fatalf("version = %d, want 2", version)
+	}
+	v, _ := st.Vaults.Get(ctx, u.ID)
+	if v.Ciphertext != "v2" || v.Version != 2 {
+		t.Fatalf("vault = %+v", v)
+	}
+	must(t, "delete", st.Vaults.Delete(ctx, u.ID))
+	wantErr(t, "delete twice", st.Vaults.Delete(ctx, u.ID), store.ErrNotFound)
+}
+
+func testPasskeys(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	p := store.Passkey{UserID: alice.ID, Name: "key", CredentialID: []byte(uuid.NewString()), PublicKey: []byte("pub"), Transports: []string{"usb", "nfc"}}
+	id, err := st.Passkeys.Create(ctx, p)
+	must(t, "create", err)
+	_, err = st.Passkeys.Create(ctx, p)
+	wantErr(t, "duplicate credential", err, store.ErrConflict)
+	must(t, "record use", st.Passkeys.RecordUse(ctx, p.CredentialID, 7, true, true))
+	list, err := st.Passkeys.List(ctx, alice.ID)
+	must(t, "list", err)
+	if len(list) != 1 || list[0].SignCount != 7 || !list[0].LastUsedAt.Valid || len(list[0].Transports) != 2 {
+		t.Fatalf("passkeys = %+v", list)
+	}
+	if n, _ := st.Passkeys.Count(ctx, alice.ID); n != 1 {
+		t.Fatalf("Count = %d", n)
+	}
+	wantErr(t, "delete by other user", st.Passkeys.Delete(ctx, bob.ID, id), store.ErrNotFound)
+	must(t, "delete", st.Passkeys.Delete(ctx, alice.ID, id))
+
+	sid, err := st.Passkeys.SaveSession(ctx, alice.ID, "register", []byte(`{"challenge":"x"}`), time.Now().Add(time.Minute))
+	must(t, "save session", err)
+	_, err = st.Passkeys.TakeSession(ctx, sid, "login")
+	wantErr(t, "take with other purpose", err, store.ErrNotFound)
+	w, err := st.Passkeys.TakeSession(ctx, sid, "register")
+	must(t, "take session", err)
+	if w.UserID.String != alice.ID || string(w.Data) != `{"challenge":"x"}` && string(w.Data) != `{"challenge": "x"}` {
+		t.Fatalf("session = %+v", w)
+	}
+	_, err = st.Passkeys.TakeSession(ctx, sid, "register")
+	wantErr(t, "take twice", err, store.ErrNotFound)
+	expired, _ := st.Passkeys.SaveSession(ctx, "", "login", []byte(`{}`), time.Now().Add(-time.Minute))
+	_, err = st.Passkeys.TakeSession(ctx, expired, "login")
+	wantErr(t, "take expired", err, store.ErrNotFound)
+}
+
+func testTOTP(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	u := newUser(t, st, "alice", "")
+	must(t, "start", st.TOTP.Start(ctx, u.ID, "secret"))
+	must(t, "enable", st.TOTP.Enable(ctx, u.ID, 100, []string{"h1", "h2"}))
+	wantErr(t, "start while enabled", st.TOTP.Start(ctx, u.ID, "other"), store.ErrConflict)
+	got, err := st.TOTP.Get(ctx, u.ID)
+	must(t, "get", err)
+	if got.Secret.String != "secret" || !got.EnabledAt.Valid || got.LastStep != 100 {
+		t.Fatalf("totp = %+v", got)
+	}
+	if usr, _ := st.Users.Get(ctx, u.ID); !usr.TOTPEnabledAt.Valid {
+		t.Fatal("User.TOTPEnabledAt not set")
+	}
+	wantErr(t, "replayed step", st.TOTP.AdvanceStep(ctx, u.ID, 100), store.ErrNotFound)
+	must(t, "advance", st.TOTP.AdvanceStep(ctx, u.ID, 101))
+	must(t, "recovery code", st.TOTP.UseRecoveryCode(ctx, u.ID, "h1"))
+	wantErr(t, "recovery code twice", st.TOTP.UseRecoveryCode(ctx, u.ID, "h1"), store.ErrNotFound)
+	wantErr(t, "unknown recovery code", st.TOTP.UseRecoveryCode(ctx, u.ID, "nope"), store.ErrNotFound)
+	lockedUntil := sql.NullTime{Time: time.Now().Add(time.Hour).Truncate(time.Second), Valid: true}
+	must(t, "failures", st.TOTP.SetFailures(ctx, u.ID, 3, lockedUntil))
+	if got, _ = st.TOTP.Get(ctx, u.ID); got.FailedAttempts != 3 || !got.LockedUntil.Time.Equal(lockedUntil.Time) {
+		t.Fatalf("after failures: %+v", got)
+	}
+	must(t, "disable", st.TOTP.Disable(ctx, u.ID))
+	if got, _ = st.TOTP.Get(ctx, u.ID); got.Secret.Valid || got.EnabledAt.Valid {
+		t.Fatalf("after disable: %+v", got)
+	}
+	wantErr(t, "recovery code after disable", st.TOTP.UseRecoveryCode(ctx, u.ID, "h2"), store.ErrNotFound)
+}
+
+func testChallenges(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	jti, expires := uuid.NewString(), time.Now().Add(5*time.Minute)
+	c, err := st.Challenges.Lock(ctx, jti, alice.ID, expires)
+	must(t, "first lock", err)
+	if c.Failures != 0 || c.Used {
+		t.Fatalf("new challenge = %+v", c)
+	}
+	must(t, "fail", st.Challenges.Update(ctx, jti, store.LoginChallenge{Failures: 1}))
+	if c, _ = st.Challenges.Lock(ctx, jti, alice.ID, expires); c.Failures != 1 || c.Used {
+		t.Fatalf("after a failure = %+v", c)
+	}
+	must(t, "use", st.Challenges.Update(ctx, jti, store.LoginChallenge{Failures: 1, Used: true}))
+	if c, _ = st.Challenges.Lock(ctx, jti, alice.ID, expires); !c.Used {
+		t.Fatalf("after use = %+v", c)
+	}
+	_, err = st.Challenges.Lock(ctx, jti, bob.ID, expires)
+	wantErr(t, "other user's jti", err, store.ErrNotFound)
+	wantErr(t, "update unknown", st.Challenges.Update(ctx, uuid.NewString(), store.LoginChallenge{Used: true}), store.ErrNotFound)
+
+	// An expired challenge is swept, so its jti starts over.
+	old := uuid.NewString()
+	_, err = st.Challenges.Lock(ctx, old, alice.ID, time.Now().Add(-time.Minute))
+	must(t, "lock expired", err)
+	must(t, "use expired", st.Challenges.Update(ctx, old, store.LoginChallenge{Used: true}))
+	_, err = st.Challenges.Lock(ctx, uuid.NewString(), alice.ID, expires)
+	must(t, "sweep", err)
+	wantErr(t, "update swept", st.Challenges.Update(ctx, old, store.LoginChallenge{}), store.ErrNotFound)
+}
+
+func testRefreshTokens(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	expires := time.Now().Add(time.Hour)
+	must(t, "create", st.RefreshTokens.Create(ctx, alice.ID, "", "a1", expires))
+	must(t, "create other family", st.RefreshTokens.Create(ctx, alice.ID, "", "b1", expires))
+	must(t, "create bob's", st.RefreshTokens.Create(ctx, bob.ID, "", "c1", expires))
+	wantErr(t, "duplicate hash", st.RefreshTokens.Create(ctx, bob.ID, "", "a1", expires), store.ErrConflict)
+	a1, err := st.RefreshTokens.Lock(ctx, "a1")
+	must(t, "lock", err)
+	b1, _ := st.RefreshTokens.Lock(ctx, "b1")
+	if a1.UserID != alice.ID || a1.Used || a1.FamilyID == "" || a1.FamilyID == b1.FamilyID {
+		t.Fatalf("a1 = %+v, b1 = %+v", a1, b1)
+	}
+
+	// Rotating spends the token and continues its family.
+	must(t, "spend", st.RefreshTokens.Spend(ctx, "a1"))
+	wantErr(t, "spend twice", st.RefreshTokens.Spend(ctx, "a1"), store.ErrNotFound)
+	must(t, "rotate", st.RefreshTokens.Create(ctx, alice.ID, a1.FamilyID, "a2", expires))
+	if a1, _ = st.RefreshTokens.Lock(ctx, "a1"); !a1.Used {
+		t.Fatalf("spent token = %+v", a1)
+	}
+	if a2, _ := st.RefreshTokens.Lock(ctx, "a2"); a2.FamilyID != a1.FamilyID || a2.Used {
+		t.Fatalf("rotated token = %+v, want family %s", a2, a1.FamilyID)
+	}
+
+	must(t, "revoke family", st.RefreshTokens.RevokeFamily(ctx, a1.FamilyID))
+	for _, h := range []string{"a1", "a2"} {
+		_, err := st.RefreshTokens.Lock(ctx, h)
+		wantErr(t, "revoked "+h, err, store.ErrNotFound)
+	}
+	_, err = st.RefreshTokens.Lock(ctx, "b1")
+	must(t, "other family after revoke", err)
+
+	must(t, "revoke user", st.RefreshTokens.RevokeUser(ctx, alice.ID))
+	_, err = st.RefreshTokens.Lock(ctx, "b1")
+	wantErr(t, "alice's token after revoke", err, store.ErrNotFound)
+	_, err = st.RefreshTokens.Lock(ctx, "c1")
+	must(t, "bob's token after revoke", err)
+
+	// Expired tokens are not returned, and are swept by the next Create.
+	must(t, "create expired", st.RefreshTokens.Create(ctx, bob.ID, "", "old", time.Now().Add(-time.Minute)))
+	_, err = st.RefreshTokens.Lock(ctx, "old")
+	wantErr(t, "expired", err, store.ErrNotFound)
+	must(t, "create after expiry", st.RefreshTokens.Create(ctx, bob.ID, "", "new", expires))
+	must(t, "reuse swept hash", st.RefreshTokens.Create(ctx, bob.ID, "", "old", expires))
+}
+
+func testContacts(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	wantErr(t, "verify stale key", st.Contacts.Verify(ctx, alice.ID, bob.ID, "not-bobs-key"), store.ErrConflict)
+	must(t, "verify", st.Contacts.Verify(ctx, alice.ID, bob.ID, bob.PublicKey))
+	k, err := st.Contacts.Key(ctx, alice.ID, bob.ID)
+	must(t, "key", err)
+	if k.PublicKey != bob.PublicKey || k.VerifiedKey.String != bob.PublicKey {
+		t.Fatalf("key = %+v", k)
+	}
+	_, err = st.Users.SetPublicKey(ctx, bob.ID, "rotated")
+	must(t, "rotate", err)
+	vs, err := st.Contacts.Verifications(ctx, alice.ID)
+	must(t, "verifications", err)
+	if len(vs) != 1 || vs[0].ContactID != bob.ID || vs[0].Current {
+		t.Fatalf("verifications after rotation = %+v", vs)
+	}
+	if k, _ = st.Contacts.Key(ctx, alice.ID, bob.ID); !k.ChangedAt.Valid {
+		t.Fatal("ChangedAt not set after rotation")
+	}
+	must(t, "unverify", st.Contacts.Unverify(ctx, alice.ID, bob.ID))
+	if vs, _ = st.Contacts.Verifications(ctx, alice.ID); len(vs) != 0 {
+		t.Fatalf("verifications after unverify = %+v", vs)
+	}
+	_, err = st.Contacts.Key(ctx, alice.ID, uuid.NewString())
+	wantErr(t, "unknown contact", err, store.ErrNotFound)
+}
+
+func testSealed(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	alice, bob := newUser(t, st, "alice", ""), newUser(t, st, "bob", "")
+	direct, _ := st.Chats.Create(ctx, "", false, alice.ID)
+	group, _ := st.Chats.Create(ctx, "g", true, alice.ID)
+	for _, c := range []string{direct, group} {
+		must(t, "add alice", st.Chats.AddMember(ctx, c, alice.ID, "owner"))
+		must(t, "add bob", st.Chats.AddMember(ctx, c, bob.ID, "member"))
+	}
+	_, err := st.Sealed.AccessKey(ctx, bob.ID, direct)
+	wantErr(t, "no key set", err, store.ErrNotFound)
+	must(t, "set key", st.Sealed.SetAccessKey(ctx, bob.ID, "hash"))
+	key, err := st.Sealed.AccessKey(ctx, bob.ID, direct)
+	must(t, "access key", err)
+	if key != "hash" {
+		t.Fatalf("key = %q", key)
+	}
+	_, err = st.Sealed.AccessKey(ctx, bob.ID, group)
+	wantErr(t, "group chat", err, store.ErrNotFound)
+	must(t, "disable bob", st.Users.Disable(ctx, bob.ID))
+	_, err = st.Sealed.AccessKey(ctx, bob.ID, direct)
+	wantErr(t, "disabled recipient", err, store.ErrNotFound)
+	must(t, "clear key", st.Sealed.SetAccessKey(ctx, alice.ID, ""))
+
+	ctx, tx, err := st.Begin(ctx)
+	must(t, "begin", err)
+	defer tx.Rollback()
+	if n, _ := st.Sealed.IssuedToday(ctx, alice.ID); n != 0 {
+		t.Fatalf("IssuedToday = %d", n)
+	}
+	token := uuid.NewString()
+	must(t, "add tokens", st.Sealed.AddTokens(ctx, alice.ID, []string{token, uuid.NewString()}, time.Now().Add(time.Hour)))
+	if n, _ := st.Sealed.IssuedToday(ctx, alice.ID); n != 2 {
+		t.Fatalf("IssuedToday = %d, want 2", n)
+	}
+	must(t, "commit", tx.Commit())
+	ctx = context.Background()
+	must(t, "spend", st.Sealed.SpendToken(ctx, token))
+	wantErr(t, "spend twice", st.Sealed.SpendToken(ctx, token), store.ErrNotFound)
+}
+
+func testAudit(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	admin := newUser(t, st, "admin", "")
+	target := uuid.NewString()
+	must(t, "record", st.Audit.Record(ctx, admin.ID, "user.disable", "user", target, map[string]any{"reason": "spam"}))
+	must(t, "record", st.Audit.Record(ctx, admin.ID, "user.enable", "user", target, nil))
+	list, err := st.Audit.List(ctx, store.AuditFilter{TargetID: target, Limit: 10})
+	must(t, "list", err)
+	if len(list) != 2 || list[0].Action != "user.enable" || list[0].ActorID.String != admin.ID {
+		t.Fatalf("entries = %+v", list)
+	}
+	list, _ = st.Audit.List(ctx, store.AuditFilter{TargetID: target, Action: "user.disable", Limit: 10})
+	if len(list) != 1 || len(list[0].Details) == 0 {
+		t.Fatalf("filtered entries = %+v", list)
+	}
+	list, _ = st.Audit.List(ctx, store.AuditFilter{TargetID: target, Limit: 1, Offset: 1})
+	if len(list) != 1 || list[0].Action != "user.disable" {
+		t.Fatalf("paged entries = %+v", list)
+	}
+}
+
+// testMalformedIDs checks that an id which is not a UUID, as a client can
+// put in any path, is simply not found rather than a database error.
+func testMalformedIDs(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	const bad = "not-a-uuid"
+	_, err := st.Users.Get(ctx, bad)
+	wantErr(t, "get user", err, store.ErrNotFound)
+	wantErr(t, "disable user", st.Users.Disable(ctx, bad), store.ErrNotFound)
+	_, err = st.Invites.Get(ctx, bad)
+	wantErr(t, "get invite", err, store.ErrNotFound)
+	_, err = st.Messages.Get(ctx, bad)
+	wantErr(t, "get message", err, store.ErrNotFound)
+	wantErr(t, "delete message", st.Messages.Delete(ctx, bad), store.ErrNotFound)
+	_, err = st.Attachments.Get(ctx, bad)
+	wantErr(t, "get attachment", err, store.ErrNotFound)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type pgTOTP struct{ pg }
+
+const totpColumns = `totp_secret, totp_enabled_at, totp_last_step, totp_failed_attempts, totp_locked_until`
+
+func (s pgTOTP) Get(ctx context.Context, userID string) (TOTP, error) {
+	var t TOTP
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT `+totpColumns+` FROM users WHERE id=$1`, userID).Scan(&t.Secret, &t.EnabledAt, &t.LastStep, &t.FailedAttempts, &t.LockedUntil)
+	return t, translate(err)
+}
+
+func (s pgTOTP) Lock(ctx context.Context, userID string) (TOTP, error) {
+	var t TOTP
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT `+totpColumns+` FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&t.Secret, &t.EnabledAt, &t.LastStep, &t.FailedAttempts, &t.LockedUntil)
+	return t, translate(err)
+}
+
+func (s pgTOTP) Start(ctx context.Context, userID, secret string) error {
+	err := affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET totp_secret=$1, updated_at=now() WHERE id=$2 AND totp_enabled_at IS NULL`, secret, userID))
+	if err == ErrNotFound {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s pgTOTP) Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	if err := affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET totp_enabled_at=now(), totp_last_step=$1, updated_at=now() WHERE id=$2`, step, userID)); err != nil {
+		return err
+	}
+	if _, err := s.q(ctx).ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	for _, h := range recoveryCodeHashes {
+		if _, err := s.q(ctx).ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1,$2)`, userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s pgTOTP) Disable(ctx context.Context, userID string) error {
+	if err := affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_step=0, updated_at=now() WHERE id=$1`, userID)); err != nil {
+		return err
+	}
+	_, err := s.q(ctx).ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID)
+	return err
+}
+
+func (s pgTOTP) AdvanceStep(ctx context.Context, userID string, step int64) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1`, step, userID))
+}
+
+func (s pgTOTP) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE recovery_codes SET used_at=now() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, codeHash))
+}
+
+func (s pgTOTP) SetFailures(ctx context.Context, userID string, failures int, lockedUntil sql.NullTime) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET totp_failed_attempts=$1, totp_locked_until=$2 WHERE id=$3`, failures, lockedUntil, userID))
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslate(t *testing.T) {
+	other, fk := errors.New("boom"), &pq.Error{Code: "23503"}
+	for _, tc := range []struct {
+		err, want error
+	}{
+		{sql.ErrNoRows, ErrNotFound},
+		{fmt.Errorf("wrapped: %w", &pq.Error{Code: "23505"}), ErrConflict},
+		{&pq.Error{Code: "22P02", Message: `invalid input syntax for type uuid: "x"`}, ErrNotFound},
+		{fk, fk},
+		{other, other},
+	} {
+		if got := translate(tc.err); !errors.Is(got, tc.want) {
+			t.Errorf("translate(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
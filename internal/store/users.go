@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type pgUsers struct{ pg }
+
+const userColumns = `id, username, display_name, password_hash, pin_hash, public_key, avatar_path, is_admin, invite_quota,
+	must_change_credentials, totp_enabled_at, disabled_at, suspended_until, last_active_at, deletion_scheduled_at,
+	failed_credential_attempts, credentials_locked_until, created_at`
+
+// userDest returns the scan destinations for userColumns, in order, so
+// queries selecting more than a user can append their own.
+func userDest(u *User) []any {
+	return []any{&u.ID, &u.Username, &u.DisplayName, &u.PasswordHash, &u.PINHash, &u.PublicKey, &u.AvatarPath, &u.IsAdmin, &u.InviteQuota,
+		&u.MustChangeCredentials, &u.TOTPEnabledAt, &u.DisabledAt, &u.SuspendedUntil, &u.LastActiveAt, &u.DeletionScheduledAt,
+		&u.FailedCredentialAttempts, &u.CredentialsLockedUntil, &u.CreatedAt}
+}
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	err := row.Scan(userDest(&u)...)
+	return u, translate(err)
+}
+
+func (s pgUsers) Get(ctx context.Context, id string) (User, error) {
+	return scanUser(s.q(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id=$1`, id))
+}
+
+func (s pgUsers) GetByUsername(ctx context.Context, username string) (User, error) {
+	return scanUser(s.q(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE username=$1`, username))
+}
+
+func (s pgUsers) Lock(ctx context.Context, id string) (User, error) {
+	return scanUser(s.q(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id=$1 FOR UPDATE`, id))
+}
+
+func (s pgUsers) Create(ctx context.Context, u NewUser) (string, error) {
+	var invite *string
+	if u.InviteID != "" {
+		invite = &u.InviteID
+	}
+	var id string
+	err := s.q(ctx).QueryRowContext(ctx, `INSERT INTO users (username, display_name, password_hash, pin_hash, public_key, invite_id) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+		u.Username, u.DisplayName, u.PasswordHash, u.PINHash, u.PublicKey, invite).Scan(&id)
+	return id, translate(err)
+}
+
+func (s pgUsers) SetCredentials(ctx context.Context, id, passwordHash, pinHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET password_hash=$1, pin_hash=$2, must_change_credentials=false, updated_at=now() WHERE id=$3`, passwordHash, pinHash, id))
+}
+
+func (s pgUsers) ReplaceCredentials(ctx context.Context, id, oldPasswordHash, oldPINHash, passwordHash, pinHash string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `UPDATE users SET password_hash=$1, pin_hash=$2 WHERE id=$3 AND password_hash=$4 AND pin_hash=$5`, passwordHash, pinHash, id, oldPasswordHash, oldPINHash)
+	return err
+}
+
+func (s pgUsers) SetPublicKey(ctx context.Context, id, publicKey string) (time.Time, error) {
+	var changedAt time.Time
+	err := s.q(ctx).QueryRowContext(ctx, `UPDATE users SET public_key=$1, public_key_changed_at=now(), updated_at=now() WHERE id=$2 RETURNING public_key_changed_at`, publicKey, id).Scan(&changedAt)
+	return changedAt, translate(err)
+}
+
+func (s pgUsers) SetAvatar(ctx context.Context, id, path string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET avatar_path=$1, updated_at=now() WHERE id=$2`, path, id))
+}
+
+func (s pgUsers) ScheduleDeletion(ctx context.Context, id string, at time.Time) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET deletion_scheduled_at=$1, updated_at=now() WHERE id=$2`, at, id))
+}
+
+func (s pgUsers) CancelDeletion(ctx context.Context, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET deletion_scheduled_at=NULL, updated_at=now() WHERE id=$1 AND deletion_scheduled_at IS NOT NULL`, id))
+}
+
+func (s pgUsers) CountAdmins(ctx context.Context) (int, error) {
+	var n int
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE is_admin=true AND deletion_scheduled_at IS NULL`).Scan(&n)
+	return n, err
+}
+
+func (s pgUsers) TouchLastActive(ctx context.Context, id string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `UPDATE users SET last_active_at=now() WHERE id=$1`, id)
+	return err
+}
+
+func (s pgUsers) List(ctx context.Context, f UserFilter) ([]User, error) {
+	rows, err := s.q(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users
+		WHERE ($1 = '' OR lower(username) LIKE '%' || $1 || '%' ESCAPE '\' OR lower(display_name) LIKE '%' || $1 || '%' ESCAPE '\')
+		AND ($2 = '' OR ($2 = 'disabled' AND disabled_at IS NOT NULL) OR ($2 = 'suspended' AND suspended_until > now())
+			OR ($2 = 'active' AND disabled_at IS NULL AND (suspended_until IS NULL OR suspended_until <= now())) OR ($2 = 'admin' AND is_admin))
+		ORDER BY username LIMIT $3 OFFSET $4`, escapeLike(strings.ToLower(f.Query)), f.Status, f.Limit, f.Offset)
+	return collect(rows, err, func(rows *sql.Rows) (User, error) { return scanUser(rows) })
+}
+
+// likeEscaper escapes the LIKE wildcards so a search matches them literally;
+// queries using it must declare ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string { return likeEscaper.Replace(s) }
+
+func (s pgUsers) Usage(ctx context.Context, id string) (Usage, error) {
+	var u Usage
+	err := s.q(ctx).QueryRowContext(ctx, `SELECT
+		(SELECT COALESCE(SUM(a.size_bytes),0) FROM attachments a JOIN messages m ON a.message_id=m.id WHERE m.sender_id=$1),
+		(SELECT COUNT(*) FROM attachments a JOIN messages m ON a.message_id=m.id WHERE m.sender_id=$1),
+		(SELECT COUNT(*) FROM messages WHERE sender_id=$1)`, id).Scan(&u.AttachmentBytes, &u.AttachmentCount, &u.MessageCount)
+	return u, err
+}
+
+func (s pgUsers) Disable(ctx context.Context, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET disabled_at=now(), updated_at=now() WHERE id=$1`, id))
+}
+
+func (s pgUsers) Suspend(ctx context.Context, id string, until time.Time) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET suspended_until=$1, updated_at=now() WHERE id=$2`, until, id))
+}
+
+func (s pgUsers) Enable(ctx context.Context, id string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET disabled_at=NULL, suspended_until=NULL, updated_at=now() WHERE id=$1`, id))
+}
+
+func (s pgUsers) SetAdmin(ctx context.Context, id string, admin bool) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET is_admin=$1, updated_at=now() WHERE id=$2 AND ($1 OR is_admin)`, admin, id))
+}
+
+func (s pgUsers) SetInviteQuota(ctx context.Context, id string, quota *int) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET invite_quota=$1, updated_at=now() WHERE id=$2`, quota, id))
+}
+
+func (s pgUsers) SetCredentialFailures(ctx context.Context, id string, failures int, lockedUntil sql.NullTime) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET failed_credential_attempts=$1, credentials_locked_until=$2 WHERE id=$3`, failures, lockedUntil, id))
+}
+
+func (s pgUsers) ResetCredentials(ctx context.Context, id, passwordHash, pinHash string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `UPDATE users SET password_hash=$1, pin_hash=$2, must_change_credentials=true,
+		failed_credential_attempts=0, credentials_locked_until=NULL, updated_at=now() WHERE id=$3`, passwordHash, pinHash, id))
+}
+
+// inviteBranchCTE selects the user $1 and everyone who registered, directly
+// or transitively, through an invite they created.
+const inviteBranchCTE = `WITH RECURSIVE branch AS (SELECT id FROM users WHERE id=$1
+	UNION
+	SELECT u.id FROM users u JOIN invites i ON u.invite_id=i.id JOIN branch b ON i.created_by=b.id)`
+
+func (s pgUsers) DisableBranch(ctx context.Context, rootID, exceptID string) (int64, error) {
+	res, err := s.q(ctx).ExecContext(ctx, inviteBranchCTE+`
+		UPDATE users SET disabled_at=now(), updated_at=now() WHERE disabled_at IS NULL AND id IN (SELECT id FROM branch) AND id::text <> $2`, rootID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,15 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+// Queries that select userColumns next to other columns scan with
+// userDest, so the two have to stay in step.
+func TestUserDestMatchesColumns(t *testing.T) {
+	columns := strings.Split(userColumns, ",")
+	if got := len(userDest(&User{})); got != len(columns) {
+		t.Fatalf("userDest has %d destinations for %d columns", got, len(columns))
+	}
+}
@@ -0,0 +1,36 @@
+package store
+
+import "context"
+
+type pgVaults struct{ pg }
+
+const vaultColumns = `user_id, ciphertext, version, created_at, updated_at`
+
+func scanVault(row interface{ Scan(...any) error }) (Vault, error) {
+	var v Vault
+	err := row.Scan(&v.UserID, &v.Ciphertext, &v.Version, &v.CreatedAt, &v.UpdatedAt)
+	return v, translate(err)
+}
+
+func (s pgVaults) Get(ctx context.Context, userID string) (Vault, error) {
+	return scanVault(s.q(ctx).QueryRowContext(ctx, `SELECT `+vaultColumns+` FROM key_vaults WHERE user_id=$1`, userID))
+}
+
+func (s pgVaults) Lock(ctx context.Context, userID string) (Vault, error) {
+	return scanVault(s.q(ctx).QueryRowContext(ctx, `SELECT `+vaultColumns+` FROM key_vaults WHERE user_id=$1 FOR UPDATE`, userID))
+}
+
+func (s pgVaults) Create(ctx context.Context, userID, ciphertext string) error {
+	_, err := s.q(ctx).ExecContext(ctx, `INSERT INTO key_vaults (user_id, ciphertext) VALUES ($1,$2)`, userID, ciphertext)
+	return translate(err)
+}
+
+func (s pgVaults) Update(ctx context.Context, userID, ciphertext string) (int, error) {
+	var version int
+	err := s.q(ctx).QueryRowContext(ctx, `UPDATE key_vaults SET ciphertext=$1, version=version+1, updated_at=now() WHERE user_id=$2 RETURNING version`, ciphertext, userID).Scan(&version)
+	return version, translate(err)
+}
+
+func (s pgVaults) Delete(ctx context.Context, userID string) error {
+	return affected(s.q(ctx).ExecContext(ctx, `DELETE FROM key_vaults WHERE user_id=$1`, userID))
+}
@@ -0,0 +1,57 @@
+// Package tracing configures the OpenTelemetry tracer provider and the W3C
+// trace context propagator used by the HTTP server and database driver.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"messagingapi/internal/config"
+)
+
+// ServiceName identifies this server in exported spans.
+const ServiceName = "messaging-api"
+
+// Setup installs the global tracer provider and propagator. With the "none"
+// exporter spans are still created, so trace IDs propagate to downstream
+// services, but nothing is exported. The OTLP exporter is configured by the
+// standard OTEL_EXPORTER_OTLP_* variables (endpoint, headers, TLS).
+// The returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, cfg config.Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var opts []sdktrace.TracerProviderOption
+	switch cfg.TracingExporter {
+	case "", "none":
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", cfg.TracingExporter)
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1")
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		sdktrace.WithResource(res),
+		// Honour the caller's sampling decision so a trace is either complete
+		// or absent across services.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}